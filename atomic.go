@@ -0,0 +1,119 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// Unpacks the archive into a temporary folder inside dest and only if that
+// succeeds moves what was unpacked into dest. The temporary folder is
+// always removed (even on SIGINT or SIGTERM, or if opts.Context is
+// canceled), as is dest if it was created
+// here and nothing was moved into it. Since the temporary folder is on the
+// same filesystem as dest, the moves are renames that can't fail part way
+// through a file; if one fails, those already made are undone.
+func unpackAtomic(archive, dest string, form format, opts *Options) error {
+	_, err := os.Stat(dest)
+	created := err != nil
+	if err := makeFolder(dest, opts.Force); err != nil {
+		return err
+	}
+	temp, err := os.MkdirTemp(dest, ".unz-*")
+	if err == nil {
+		stop := func() {}
+		if opts.Context == nil { // else canceling it stops unpacking
+			stop = onSignal(func() {
+				_ = os.RemoveAll(temp)
+				if created {
+					_ = os.Remove(dest)
+				}
+			})
+		}
+		opts.Atomic = false // nested archives are unpacked inside temp
+		opts.atomicFolder, opts.destFolder = temp, dest
+		err = unpackInto(archive, temp, form, opts)
+		if err == nil {
+			err = moveEntries(temp, dest)
+		}
+		stop()
+		if rerr := os.RemoveAll(temp); rerr != nil && err == nil {
+			err = rerr
+		}
+	} else {
+		err = fmt.Errorf("failed to create temporary folder in %s: %w",
+			dest, err)
+	}
+	if err != nil && created {
+		_ = os.Remove(dest) // only succeeds if empty
+	}
+	return err
+}
+
+// Moves the entries in the temp folder into dest, first checking that
+// none of them exist there (since replacing them couldn't be undone).
+func moveEntries(temp, dest string) error {
+	entries, err := os.ReadDir(temp)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", temp, err)
+	}
+	for _, entry := range entries {
+		name := filepath.Join(dest, entry.Name())
+		if _, err := os.Lstat(name); err == nil {
+			return fmt.Errorf("cannot unpack %s atomically: %w", name,
+				ErrExists)
+		}
+	}
+	for i, entry := range entries {
+		source := filepath.Join(temp, entry.Name())
+		target := filepath.Join(dest, entry.Name())
+		if err := os.Rename(source, target); err != nil {
+			for _, moved := range entries[:i] {
+				_ = os.Rename(filepath.Join(dest, moved.Name()),
+					filepath.Join(temp, moved.Name()))
+			}
+			return fmt.Errorf("failed to move %s to %s: %w", source, target,
+				err)
+		}
+	}
+	return nil
+}
+
+// Calls cleanup if SIGINT or SIGTERM arrives before the returned stop
+// function is called, and then re-raises the signal (which terminates the
+// program unless it handles or ignores the signal itself).
+func onSignal(cleanup func()) (stop func()) {
+	signals := make(chan os.Signal, 1)
+	done := make(chan struct{})
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			cleanup()
+			signal.Stop(signals)
+			if process, err := os.FindProcess(os.Getpid()); err == nil {
+				_ = process.Signal(sig)
+			}
+		case <-done:
+		}
+	}()
+	return func() {
+		signal.Stop(signals)
+		close(done)
+	}
+}
+
+// Returns the text with the temporary folder used by Atomic replaced by
+// the folder it stands in for (so that reports show final paths).
+func (me *Options) shown(text string) string {
+	if me.atomicFolder == "" {
+		return text
+	}
+	return strings.ReplaceAll(text, me.atomicFolder, me.destFolder)
+}
@@ -1 +1 @@
-go build -o unz .
+go build -o unz ./cmd/unz
@@ -1 +1 @@
-env GOOS=windows GOARCH=386 go build -o unz.exe .
+env GOOS=windows GOARCH=386 go build -o unz.exe ./cmd/unz
@@ -0,0 +1,115 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"archive/zip"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"unicode/utf8"
+)
+
+// Charsets are the names of the character sets that may be given as
+// Options.Charset for zip members' names that aren't flagged as UTF-8.
+// ("utf8" means leave such names as they are.)
+var Charsets = []string{"cp437", "latin1", "utf8"}
+
+// The characters for CP437's bytes 0x80 to 0xFF (its lower half is ASCII).
+var cp437 = []rune("ÇüéâäàåçêëèïîìÄÅÉæÆôöòûùÿÖÜ¢£¥₧ƒáíóúñÑªº¿⌐¬½¼¡«»" +
+	"░▒▓│┤╡╢╖╕╣║╗╝╜╛┐└┴┬├─┼╞╟╚╔╩╦╠═╬╧╨╤╥╙╘╒╓╫╪┘┌█▄▌▐▀" +
+	"αßΓπΣσµτΦΘΩδ∞φε∩≡±≥≤⌠⌡÷≈°∙·√ⁿ²■\u00a0")
+
+const (
+	utf8Flag       = 0x800  // general purpose bit 11 (APPNOTE.TXT 4.4.4)
+	unicodePathTag = 0x7075 // Info-ZIP Unicode Path extra field
+)
+
+// Returns true if the charset is "" (meaning decide per member) or one of
+// Charsets.
+func validCharset(charset string) bool {
+	if charset == "" {
+		return true
+	}
+	for _, name := range Charsets {
+		if name == charset {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the member's name as UTF-8. An Info-ZIP Unicode Path extra field
+// (for the member's current name) is used if there is one. Otherwise, a
+// name that has non-ASCII bytes and isn't flagged as UTF-8 is decoded from
+// the charset; if the charset is "", names of members made on DOS or
+// Windows are decoded from CP437 (as Info-ZIP's unzip does) and the rest
+// are left as they are (since, e.g., many Unix tools write UTF-8 names
+// without setting the flag).
+func zipName(file *zip.File, charset string, fromDOS bool) string {
+	if name, ok := unicodePath(file); ok {
+		return name
+	}
+	if file.Flags&utf8Flag != 0 || isASCII(file.Name) {
+		return file.Name
+	}
+	if charset == "" && fromDOS {
+		charset = "cp437"
+	}
+	switch charset {
+	case "cp437":
+		return decode(file.Name, func(b byte) rune {
+			return cp437[b-0x80]
+		})
+	case "latin1":
+		return decode(file.Name, func(b byte) rune { return rune(b) })
+	}
+	return file.Name
+}
+
+// Returns the name from the member's Unicode Path extra field if it has
+// one whose CRC-32 matches the member's (undecoded) name.
+func unicodePath(file *zip.File) (string, bool) {
+	extra := file.Extra
+	for len(extra) >= 4 {
+		tag := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		field := extra[:size]
+		extra = extra[size:]
+		if tag != unicodePathTag || size < 5 || field[0] != 1 {
+			continue
+		}
+		if binary.LittleEndian.Uint32(field[1:]) ==
+			crc32.ChecksumIEEE([]byte(file.Name)) && utf8.Valid(field[5:]) {
+			return string(field[5:]), true
+		}
+	}
+	return "", false
+}
+
+// Returns the text with each non-ASCII byte replaced by the given rune.
+func decode(text string, high func(byte) rune) string {
+	var decoded strings.Builder
+	for i := 0; i < len(text); i++ {
+		if b := text[i]; b < utf8.RuneSelf {
+			decoded.WriteByte(b)
+		} else {
+			decoded.WriteRune(high(b))
+		}
+	}
+	return decoded.String()
+}
+
+func isASCII(text string) bool {
+	for i := 0; i < len(text); i++ {
+		if text[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
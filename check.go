@@ -0,0 +1,113 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"fmt"
+	"io"
+)
+
+// MemberError records why a member failed CheckIntegrity().
+type MemberError struct {
+	Member string
+	Err    error
+}
+
+func (me *MemberError) Error() string {
+	return fmt.Sprintf("%s: %s", me.Member, me.Err)
+}
+
+func (me *MemberError) Unwrap() error { return me.Err }
+
+// TestFunc is called by TestIntegrity() for each member that is checked,
+// with a nil err if the member is intact.
+type TestFunc func(member Member, err error)
+
+// CheckIntegrity reads the content of each of the archive's members that
+// opts wants (see WalkWith()) in full, discarding it, so that everything
+// the format stores for checking is checked: zip and 7z members' CRC-32s,
+// and the checksums of compressed streams (e.g., gzip's CRC-32 or xz's
+// check). Tarballs have no per-member checksums (only their headers'), so
+// for these (and single compressed files) the whole stream is decompressed
+// to its end. Returns the members that failed (in archive order) and an
+// ErrBroken error, or no members and nil if the archive is intact. If the
+// archive can't be read to its end (e.g., a tarball with a corrupt header
+// or stream), the error says so, and the members that failed before then
+// are returned too.
+func CheckIntegrity(archive string, opts Options) ([]*MemberError,
+	error) {
+	return TestIntegrity(archive, opts, nil)
+}
+
+// TestIntegrity is like CheckIntegrity() but also calls fn (unless it is
+// nil) as each member is checked, e.g., to report each member's result.
+func TestIntegrity(archive string, opts Options, fn TestFunc) (
+	[]*MemberError, error) {
+	if !validCharset(opts.Charset) {
+		return nil, fmt.Errorf("%w: %s", ErrCharset, opts.Charset)
+	}
+	var failures []*MemberError
+	check := func(member Member, reader io.Reader) error {
+		_, err := io.Copy(io.Discard, reader)
+		if err != nil {
+			failures = append(failures, &MemberError{member.Name, err})
+		}
+		if fn != nil {
+			fn(member, err)
+		}
+		return nil
+	}
+	var err error
+	if form, ferr := archiveFormat(archive, opts.Format); ferr == nil &&
+		form.kind.readsAsTarball() {
+		err = checkTarball(archive, form, &opts, check)
+	} else {
+		err = WalkWith(archive, opts, check)
+	}
+	if err != nil {
+		return failures, err
+	}
+	switch n := len(failures); n {
+	case 0:
+		return nil, nil
+	case 1:
+		return failures, fmt.Errorf("%s has a broken member: %w", archive,
+			ErrBroken)
+	default:
+		return failures, fmt.Errorf("%s has %d broken members: %w",
+			archive, n, ErrBroken)
+	}
+}
+
+// Like walkTarball() but once the tarball's end-of-archive marker is
+// reached, decompresses the rest of the stream so that its checksum (and
+// any padding or trailing data) is checked too.
+func checkTarball(archive string, form format, opts *Options,
+	fn WalkFunc) error {
+	stream, closer, err := openDecompressed(archive, form.compression)
+	if err != nil {
+		return err
+	}
+	defer closer()
+	reader := newHeaderReader(form.kind, stream)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", archive, err)
+		}
+		if member := tarMember(header); opts.WantedMember(member) {
+			if err := fn(member, reader); err != nil {
+				return err
+			}
+		}
+	}
+	if _, err := io.Copy(io.Discard, stream); err != nil {
+		return fmt.Errorf("failed to decompress %s to its end: %w", archive,
+			err)
+	}
+	return nil
+}
@@ -0,0 +1,42 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"runtime"
+	"strings"
+
+	"github.com/mark-summerfield/unz"
+)
+
+// buildInfo is what --build-info shows.
+type buildInfo struct {
+	Version string   `json:"version"`
+	Go      string   `json:"go"`
+	OS      string   `json:"os"`
+	Arch    string   `json:"arch"`
+	Formats []string `json:"formats"`
+}
+
+// Writes unz's version, the Go version and platform it was built with,
+// and the formats it supports (all of which are always compiled in) to
+// stdout, as JSON if asJSON.
+func writeBuildInfo(asJSON bool) error {
+	info := buildInfo{Version: strings.TrimSpace(unz.Version),
+		Go: runtime.Version(), OS: runtime.GOOS, Arch: runtime.GOARCH,
+		Formats: unz.Formats}
+	if asJSON {
+		data, err := json.MarshalIndent(info, "", "  ")
+		if err != nil {
+			return err
+		}
+		output("%s\n", data)
+		return nil
+	}
+	output("unz %s\ngo %s\nplatform %s/%s\nformats %s\n", info.Version,
+		strings.TrimPrefix(info.Go, "go"), info.OS, info.Arch,
+		strings.Join(info.Formats, " "))
+	return nil
+}
@@ -0,0 +1,319 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/mark-summerfield/clip"
+)
+
+// The shells that --completion can write a script for.
+var shells = []string{"bash", "zsh", "fish"}
+
+// The suffixes of the files that unz recognizes by name (ignoring case)
+// and so offers when completing archives. Compressed tarballs' suffixes,
+// e.g., tar.gz, are covered by compressed files' suffixes, e.g., gz.
+var archiveSuffixes = []string{"tar", "tgz", "tzst", "tlz4", "tbr", "taZ",
+	"zip", "7z", "gz", "bz2", "xz", "zst", "lz4", "br", "Z"}
+
+// completable is the part of clip's options that completion needs.
+// (clip doesn't expose a parser's options, so they're listed explicitly.)
+type completable interface {
+	LongName() string
+	ShortName() rune
+	Help() string
+	VarName() string
+}
+
+// builtinOption stands in for the options that clip adds itself.
+type builtinOption struct {
+	longName  string
+	shortName rune
+	help      string
+}
+
+func (me builtinOption) LongName() string { return me.longName }
+func (me builtinOption) ShortName() rune  { return me.shortName }
+func (me builtinOption) Help() string     { return me.help }
+func (me builtinOption) VarName() string  { return "" }
+
+type valueKind uint8
+
+const (
+	textValue   valueKind = iota // or no value for a flag
+	fileValue                    // an existing file
+	folderValue                  // a folder
+)
+
+// completion describes how to complete an option and its value.
+type completion struct {
+	option  completable
+	kind    valueKind
+	choices []string // the valid values (if any)
+}
+
+// Returns the option's long name as it's normally typed, e.g., --keep-newer.
+func (me completion) long() string {
+	return "--" + strings.ReplaceAll(me.option.LongName(), "_", "-")
+}
+
+// Returns the option's short name, e.g., -v, or "" if it has none.
+func (me completion) short() string {
+	if name := me.option.ShortName(); name != clip.NoShortName {
+		return "-" + string(name)
+	}
+	return ""
+}
+
+// Returns the option's short and long names, e.g., "-v --verbose".
+func (me completion) names() []string {
+	if short := me.short(); short != "" {
+		return []string{short, me.long()}
+	}
+	return []string{me.long()}
+}
+
+func (me completion) isFlag() bool {
+	switch me.option.(type) {
+	case *clip.FlagOption, builtinOption:
+		return true
+	}
+	return false
+}
+
+// Returns true if the option's value is optional, in which case it can
+// only be given attached, e.g., --flatten=rename.
+func (me completion) isImplicit() bool {
+	option, ok := me.option.(*clip.StrOption)
+	return ok && option.AllowImplicit
+}
+
+// Returns the first sentence (or clause) of the option's help, e.g., "The
+// folder to unpack into", for shells that show options' descriptions.
+func (me completion) summary() string {
+	text := me.option.Help()
+	for _, end := range []string{". ", " (", " [", ": "} {
+		if i := strings.Index(text, end); i > -1 {
+			text = text[:i]
+		}
+	}
+	return strings.TrimSuffix(text, ".")
+}
+
+// Writes the completion script for the given shell (one of shells) to
+// stdout.
+func writeCompletion(shell string, completions []completion) {
+	completions = append(completions,
+		completion{option: builtinOption{"help", 'h', "Show help and quit."}},
+		completion{option: builtinOption{"version", 'V',
+			"Show version and quit."}})
+	switch shell {
+	case "bash":
+		output("%s", bashCompletion(completions))
+	case "zsh":
+		output("%s", zshCompletion(completions))
+	case "fish":
+		output("%s", fishCompletion(completions))
+	}
+}
+
+func bashCompletion(completions []completion) string {
+	var script strings.Builder
+	script.WriteString(`# bash completion for unz
+# (generated by unz --completion bash)
+#
+# To install, save it where bash-completion looks for completions, e.g.,
+#   unz --completion bash > ~/.local/share/bash-completion/completions/unz
+# or source it from ~/.bashrc, e.g.,
+#   source <(unz --completion bash)
+
+_unz() {
+    local cur=${COMP_WORDS[COMP_CWORD]} prev=${COMP_WORDS[COMP_CWORD-1]}
+    local attached= name
+    COMPREPLY=()
+    if [[ $cur == = ]]; then # e.g., --sort= (since = is a word break)
+        cur= attached=1
+    elif [[ $prev == = ]]; then # e.g., --sort=na
+        prev=${COMP_WORDS[COMP_CWORD-2]} attached=1
+    fi
+    case $prev in
+`)
+	var names []string
+	var texts []string
+	for _, completion := range completions {
+		names = append(names, completion.names()...)
+		if completion.isFlag() {
+			continue
+		}
+		pattern := strings.Join(completion.names(), "|")
+		switch {
+		case completion.isImplicit():
+			fmt.Fprintf(&script, "    %s)\n        if [[ $attached ]]; "+
+				"then\n            mapfile -t COMPREPLY < <(compgen -W "+
+				"'%s' -- \"$cur\")\n            return\n        fi;;\n",
+				pattern, strings.Join(completion.choices, " "))
+		case completion.choices != nil:
+			fmt.Fprintf(&script, "    %s)\n        mapfile -t COMPREPLY < "+
+				"<(compgen -W '%s' -- \"$cur\")\n        return;;\n",
+				pattern, strings.Join(completion.choices, " "))
+		case completion.kind == fileValue:
+			fmt.Fprintf(&script, "    %s)\n        mapfile -t COMPREPLY < "+
+				"<(compgen -f -- \"$cur\")\n        return;;\n", pattern)
+		case completion.kind == folderValue:
+			fmt.Fprintf(&script, "    %s)\n        mapfile -t COMPREPLY < "+
+				"<(compgen -d -- \"$cur\")\n        return;;\n", pattern)
+		default:
+			texts = append(texts, pattern)
+		}
+	}
+	fmt.Fprintf(&script, "    %s)\n        return;;\n    esac\n",
+		strings.Join(texts, "|"))
+	fmt.Fprintf(&script, `    if [[ $cur == -* ]]; then
+        mapfile -t COMPREPLY < <(compgen -W '%s' -- "$cur")
+        return
+    fi
+    while IFS= read -r name; do
+        if [[ -d $name ]]; then
+            COMPREPLY+=("$name")
+        else
+            case $name in
+            %s)
+                COMPREPLY+=("$name");;
+            esac
+        fi
+    done < <(compgen -f -- "$cur")
+}
+
+complete -o filenames -F _unz unz
+`, strings.Join(names, " "), bashSuffixPattern())
+	return script.String()
+}
+
+// Returns a case pattern that matches archives' names ignoring case, e.g.,
+// *.[tT][aA][rR]|*.[zZ][iI][pP]|...
+func bashSuffixPattern() string {
+	patterns := make([]string, 0, len(archiveSuffixes))
+	for _, suffix := range archiveSuffixes {
+		var pattern strings.Builder
+		pattern.WriteString("*.")
+		for _, c := range suffix {
+			lower, upper := unicode.ToLower(c), unicode.ToUpper(c)
+			if lower != upper {
+				fmt.Fprintf(&pattern, "[%c%c]", lower, upper)
+			} else {
+				pattern.WriteRune(c)
+			}
+		}
+		patterns = append(patterns, pattern.String())
+	}
+	return strings.Join(patterns, "|")
+}
+
+func zshCompletion(completions []completion) string {
+	var script strings.Builder
+	script.WriteString(`#compdef unz
+# zsh completion for unz (generated by unz --completion zsh)
+#
+# To install, save it as _unz in a folder that's in $fpath, e.g.,
+#   unz --completion zsh > ~/.zfunc/_unz
+# with fpath=(~/.zfunc $fpath) before compinit in ~/.zshrc.
+
+_arguments -s -S \
+`)
+	for _, completion := range completions {
+		description := "[" + zshEscape(completion.summary()) + "]"
+		short, long := completion.short(), completion.long()
+		var spec string
+		if completion.isFlag() {
+			if short == "" {
+				spec = "'" + long + description + "'"
+			} else {
+				spec = fmt.Sprintf("'(%s %s)'{%s,%s}'%s'", short, long,
+					short, long, description)
+			}
+		} else {
+			action := " "
+			switch {
+			case completion.choices != nil:
+				action = "(" + strings.Join(completion.choices, " ") + ")"
+			case completion.kind == fileValue:
+				action = "_files"
+			case completion.kind == folderValue:
+				action = "_files -/"
+			}
+			value := ":" + strings.ToLower(completion.option.VarName()) + ":" +
+				action
+			if completion.isImplicit() {
+				spec = "'" + long + "=-" + description + value + "'"
+			} else if short == "" {
+				spec = "'" + long + "=" + description + value + "'"
+			} else {
+				spec = fmt.Sprintf("'(%s %s)'{%s+,%s=}'%s%s'", short, long,
+					short, long, description, value)
+			}
+		}
+		fmt.Fprintf(&script, "  %s \\\n", spec)
+	}
+	fmt.Fprintf(&script, "  '*:archive:_files -g \"*.(#i)(%s)(-.)\"'\n",
+		strings.Join(archiveSuffixes, "|"))
+	return script.String()
+}
+
+// Returns text that can go inside an _arguments spec's [description] in
+// single quotes.
+func zshEscape(text string) string {
+	text = strings.ReplaceAll(text, "'", `'\''`)
+	return strings.NewReplacer("[", `\[`, "]", `\]`, ":", `\:`).Replace(
+		text)
+}
+
+func fishCompletion(completions []completion) string {
+	var script strings.Builder
+	script.WriteString(`# fish completion for unz
+# (generated by unz --completion fish)
+#
+# To install, save it where fish looks for completions, e.g.,
+#   unz --completion fish > ~/.config/fish/completions/unz.fish
+
+`)
+	for _, completion := range completions {
+		script.WriteString("complete -c unz")
+		if short := completion.short(); short != "" {
+			script.WriteString(" -s " + short[1:])
+		}
+		script.WriteString(" -l " + completion.long()[2:])
+		switch {
+		case completion.isFlag():
+		case completion.isImplicit(): // so only --flatten=VALUE
+			fmt.Fprintf(&script, " -f -a '%s'",
+				strings.Join(completion.choices, " "))
+		case completion.choices != nil:
+			fmt.Fprintf(&script, " -x -a '%s'",
+				strings.Join(completion.choices, " "))
+		case completion.kind == fileValue:
+			script.WriteString(" -r -F")
+		case completion.kind == folderValue:
+			script.WriteString(" -x -a '(__fish_complete_directories)'")
+		default:
+			script.WriteString(" -x")
+		}
+		fmt.Fprintf(&script, " -d %s\n", fishQuote(completion.summary()))
+	}
+	script.WriteString("complete -c unz -f\n")
+	for _, suffix := range archiveSuffixes {
+		fmt.Fprintf(&script,
+			"complete -c unz -k -a '(__fish_complete_suffix .%s)'\n",
+			suffix)
+	}
+	return script.String()
+}
+
+// Returns the text in single quotes for fish.
+func fishQuote(text string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(text) +
+		"'"
+}
@@ -0,0 +1,101 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mark-summerfield/unz"
+)
+
+// The temporary files holding downloaded archives and their URLs.
+var downloads = map[string]string{}
+
+// Returns true if the archive positional is an http:// or https:// URL.
+func isURL(archive string) bool {
+	lower := strings.ToLower(archive)
+	return strings.HasPrefix(lower, "http://") ||
+		strings.HasPrefix(lower, "https://")
+}
+
+// Downloads the URL (following redirects) to a temporary file (since zip
+// and format sniffing need random access) and returns the file's name, or
+// an error. The file is named after the last component of the URL's path
+// (e.g., release.tar.gz) so that its format can be recognized by its
+// suffix and any subfolder created for it has a sensible name. A timeout
+// of 0 means none, and the download stops if ctx is canceled.
+func download(ctx context.Context, rawURL string, timeout time.Duration) (
+	string, error) {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %w", rawURL, err)
+	}
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, rawURL,
+		nil)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL %s: %w", rawURL, err)
+	}
+	request.Header.Set("User-Agent", "unz/"+strings.TrimSpace(unz.Version))
+	client := &http.Client{Timeout: timeout}
+	response, err := client.Do(request)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) { // its text repeats the URL
+			err = urlErr.Err
+		}
+		return "", fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download %s: HTTP %s", rawURL,
+			response.Status)
+	}
+	folder, err := os.MkdirTemp(tempFolder, "unz-")
+	if err != nil {
+		return "", fmt.Errorf(
+			"failed to create temporary folder for %s: %w", rawURL, err)
+	}
+	archive := filepath.Join(folder, downloadName(parsed.Path))
+	file, err := os.Create(archive)
+	if err == nil {
+		_, err = io.Copy(file, response.Body)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		os.RemoveAll(folder)
+		return "", fmt.Errorf("failed to download %s: %w", rawURL, err)
+	}
+	downloads[archive] = rawURL
+	return archive, nil
+}
+
+// Returns the last component of the URL's path for use as a file name,
+// with any separators (including \ and : which Windows treats as such)
+// replaced by _, or "download" if it has no usable name.
+func downloadName(urlPath string) string {
+	name := strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':':
+			return '_'
+		}
+		return r
+	}, path.Base(urlPath))
+	name = filepath.Base(name)
+	if name == "." || name == ".." || name == "_" {
+		return "download"
+	}
+	return name
+}
@@ -0,0 +1,34 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestDownloadName(t *testing.T) {
+	for _, test := range []struct {
+		url  string
+		want string
+	}{
+		{"https://example.com/r/release.tar.gz", "release.tar.gz"},
+		{"https://example.com/release.zip?x=1#y", "release.zip"},
+		{"https://example.com/", "download"},
+		{"https://example.com", "download"},
+		{"https://example.com/a/..", "download"},
+		{"https://example.com/a/%2E%2E", "download"},
+		{"https://example.com/..%5C..%5Cevil.zip", ".._.._evil.zip"},
+		{"https://example.com/C:%5Cevil.zip", "C__evil.zip"},
+		{"https://example.com/a%2F..%2F..%2Fevil.zip", "evil.zip"},
+	} {
+		parsed, err := url.Parse(test.url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := downloadName(parsed.Path); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.url, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"sort"
+	"time"
+
+	"github.com/mark-summerfield/unz"
+)
+
+const headerIndent = "    "
+
+// Prints the member's header fields as an indented block (for
+// --verbose-headers). Prints nothing if the member has no header (e.g.,
+// for 7z files and single compressed files).
+func listHeader(member unz.Member) {
+	switch header := member.Header.(type) {
+	case *tar.Header:
+		listTarHeader(header)
+	case *zip.FileHeader:
+		listZipHeader(header)
+	}
+}
+
+func listTarHeader(header *tar.Header) {
+	field("typeflag", "%q (%s)", header.Typeflag,
+		tarTypeName(header.Typeflag))
+	field("linkname", "%s", orDash(header.Linkname))
+	field("size", "%s", commas64(header.Size))
+	field("mode", "%#o", header.Mode)
+	field("uid/gid", "%d/%d", header.Uid, header.Gid)
+	field("uname/gname", "%s/%s", orDash(header.Uname),
+		orDash(header.Gname))
+	field("devmajor/minor", "%d/%d", header.Devmajor, header.Devminor)
+	field("mtime", "%s", timestamp(header.ModTime))
+	field("atime", "%s", timestamp(header.AccessTime))
+	field("ctime", "%s", timestamp(header.ChangeTime))
+	field("format", "%s", header.Format)
+	keys := make([]string, 0, len(header.PAXRecords))
+	for key := range header.PAXRecords {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		field("pax "+key, "%s", header.PAXRecords[key])
+	}
+}
+
+func tarTypeName(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg, tar.TypeRegA:
+		return "regular file"
+	case tar.TypeLink:
+		return "hard link"
+	case tar.TypeSymlink:
+		return "soft link"
+	case tar.TypeChar:
+		return "character device"
+	case tar.TypeBlock:
+		return "block device"
+	case tar.TypeDir:
+		return "folder"
+	case tar.TypeFifo:
+		return "FIFO"
+	case tar.TypeCont:
+		return "contiguous file"
+	case tar.TypeXHeader, tar.TypeXGlobalHeader:
+		return "PAX header"
+	case tar.TypeGNUSparse:
+		return "GNU sparse file"
+	case tar.TypeGNULongName, tar.TypeGNULongLink:
+		return "GNU long name"
+	}
+	return "unknown"
+}
+
+func listZipHeader(header *zip.FileHeader) {
+	field("method", "%d (%s)", header.Method, zipMethodName(header.Method))
+	field("crc32", "%08x", header.CRC32)
+	field("flags", "0x%04x%s", header.Flags, zipFlagNames(header.Flags))
+	field("compressed", "%s", commas64(int64(header.CompressedSize64)))
+	field("uncompressed", "%s", commas64(int64(header.UncompressedSize64)))
+	field("modified", "%s", timestamp(header.Modified))
+	field("external attrs", "0x%08x", header.ExternalAttrs)
+	field("creator version", "0x%04x", header.CreatorVersion)
+	field("reader version", "0x%04x", header.ReaderVersion)
+	if header.Comment != "" {
+		field("comment", "%s", header.Comment)
+	}
+	if len(header.Extra) > 0 {
+		field("extra", "%s bytes", commas(len(header.Extra)))
+	}
+}
+
+func zipMethodName(method uint16) string {
+	switch method {
+	case zip.Store:
+		return "store"
+	case zip.Deflate:
+		return "deflate"
+	case 12:
+		return "bzip2"
+	case 14:
+		return "LZMA"
+	case 93:
+		return "zstd"
+	case 95:
+		return "xz"
+	case 99:
+		return "AES"
+	}
+	return "unknown"
+}
+
+func zipFlagNames(flags uint16) string {
+	names := ""
+	for _, flag := range []struct {
+		bit  uint16
+		name string
+	}{{0x1, "encrypted"}, {0x8, "data descriptor"}, {0x800, "UTF-8"}} {
+		if flags&flag.bit != 0 {
+			names += ", " + flag.name
+		}
+	}
+	if names != "" {
+		names = " (" + names[2:] + ")"
+	}
+	return names
+}
+
+func field(name, format string, args ...any) {
+	output(headerIndent+"%-17s"+format+"\n", append([]any{name + ":"},
+		args...)...)
+}
+
+func orDash(text string) string {
+	if text == "" {
+		return "-"
+	}
+	return text
+}
+
+func timestamp(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,138 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/mark-summerfield/unz"
+)
+
+// listFields are the --list-format placeholders' names and how to get each
+// one's value from a member. Zip-only fields are "-" for other formats.
+var listFields = map[string]func(unz.Member) string{
+	"name": func(member unz.Member) string { return member.Name },
+	"size": func(member unz.Member) string {
+		return strconv.FormatInt(member.Size, 10)
+	},
+	"csize": func(member unz.Member) string {
+		if header, ok := member.Header.(*zip.FileHeader); ok {
+			return strconv.FormatUint(header.CompressedSize64, 10)
+		}
+		return "-"
+	},
+	"mtime": func(member unz.Member) string {
+		return timestamp(member.ModTime)
+	},
+	"mode": func(member unz.Member) string { return member.Mode.String() },
+	"method": func(member unz.Member) string {
+		if header, ok := member.Header.(*zip.FileHeader); ok {
+			return zipMethodName(header.Method)
+		}
+		return "-"
+	},
+	"crc": func(member unz.Member) string {
+		if header, ok := member.Header.(*zip.FileHeader); ok {
+			return fmt.Sprintf("%08x", header.CRC32)
+		}
+		return "-"
+	},
+	"isdir": func(member unz.Member) string {
+		return strconv.FormatBool(member.IsDir())
+	},
+}
+
+// listFieldNames are listFields' names in the order they're documented.
+var listFieldNames = []string{"name", "size", "csize", "mtime", "mode",
+	"method", "crc", "isdir"}
+
+// listFormat is a parsed --list-format template: literal text and fields
+// which are output in order for each member.
+type listFormat []listPart
+
+// listPart is either literal text or (if field isn't nil) a placeholder.
+type listPart struct {
+	text  string
+	field func(unz.Member) string
+}
+
+// The characters that may follow a backslash in a --list-format template
+// and what each stands for.
+var escapes = map[byte]byte{'t': '\t', 'n': '\n', '\\': '\\'}
+
+// Parses a template such as "{size}\t{mtime}\t{name}" in which each
+// {field} is replaced by the member's value, {{ and }} stand for literal
+// braces, and \t, \n, and \\ stand for a tab, newline, and backslash.
+func parseListFormat(template string) (listFormat, error) {
+	var format listFormat
+	var text strings.Builder
+	flush := func() {
+		if text.Len() > 0 {
+			format = append(format, listPart{text: text.String()})
+			text.Reset()
+		}
+	}
+	for i := 0; i < len(template); i++ {
+		c := template[i]
+		switch {
+		case c == '\\' && i+1 < len(template) &&
+			escapes[template[i+1]] != 0:
+			i++
+			text.WriteByte(escapes[template[i]])
+		case (c == '{' || c == '}') && i+1 < len(template) &&
+			template[i+1] == c:
+			i++
+			text.WriteByte(c)
+		case c == '{':
+			end := strings.IndexByte(template[i:], '}')
+			if end == -1 {
+				return nil, fmt.Errorf("unclosed { in %q", template)
+			}
+			name := template[i+1 : i+end]
+			field, ok := listFields[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown field {%s}; expected one "+
+					"of: %s", name, strings.Join(listFieldNames, " "))
+			}
+			flush()
+			format = append(format, listPart{field: field})
+			i += end
+		case c == '}':
+			return nil, fmt.Errorf("unmatched } in %q (use }} for a "+
+				"literal })", template)
+		default:
+			text.WriteByte(c)
+		}
+	}
+	flush()
+	return format, nil
+}
+
+// Returns the member formatted according to the template.
+func (me listFormat) format(member unz.Member) string {
+	var text strings.Builder
+	for _, part := range me {
+		if part.field != nil {
+			text.WriteString(part.field(member))
+		} else {
+			text.WriteString(part.text)
+		}
+	}
+	return text.String()
+}
+
+// Prints each member formatted according to the template, one per line,
+// optionally followed by its header.
+func listFormatted(members []unz.Member, format listFormat,
+	headers bool) {
+	for _, member := range members {
+		output("%s\n", format.format(member))
+		if headers {
+			listHeader(member)
+		}
+	}
+}
@@ -0,0 +1,1941 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"os/signal"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/mark-summerfield/clip"
+	"github.com/mark-summerfield/unz"
+)
+
+func main() {
+	config := getConfig()
+	ok := run(config)
+	if config.manifest != "" {
+		if err := writeManifest(config.manifest, config.created,
+			jsonErrors); err != nil {
+			complain(err.Error())
+			ok = false
+		}
+	}
+	if config.interrupted() {
+		complain("interrupted")
+		os.Exit(130) // as shells report for SIGINT
+	}
+	if !ok {
+		os.Exit(1)
+	}
+}
+
+// Processes every archive (even if some fail) and returns false if any
+// archive couldn't be read, failed verification, or failed to list or
+// unpack fully. Errors are reported inline only if verbose, but are always
+// summarized at the end.
+func run(config *config) bool {
+	if config.create {
+		return create(config)
+	}
+	var failures []failure
+	var grand totals
+	for _, archive := range config.archives {
+		if config.interrupted() {
+			break
+		}
+		currentArchive = archive
+		var err error
+		if archive == "-" {
+			if archive, err = readStdin(); err != nil {
+				failures = report(failures, "-", err, config.verbose)
+				continue
+			}
+			config.stdin = archive
+			defer os.RemoveAll(filepath.Dir(archive))
+		} else if isURL(archive) {
+			url := archive
+			if archive, err = download(config.context(), url,
+				config.timeout); err != nil {
+				failures = report(failures, url, err, config.verbose)
+				continue
+			}
+			defer os.RemoveAll(filepath.Dir(archive))
+		}
+		name := config.displayName(archive)
+		currentArchive = name
+		if config.checksums != nil {
+			if err = verify(archive, config); err != nil {
+				failures = append(failures, failure{name, err})
+				if config.unpack {
+					continue // refuse to unpack
+				}
+			}
+		}
+		if config.check {
+			err = check(archive, config)
+		} else if config.test {
+			err = test(archive, config)
+		} else if config.toStdout {
+			err = writeToStdout(archive, config)
+		} else if config.unpack {
+			err = unz.Unpack(archive, config.output, config.options)
+		} else {
+			var listed totals
+			listed, err = listArchive(archive, config)
+			grand.add(listed)
+		}
+		if err != nil {
+			failures = report(failures, name, err, config.verbose)
+		}
+	}
+	if config.totals && grand.archives > 1 {
+		if config.count {
+			output("total: %s\n", config.number(grand.members))
+		} else {
+			output("%s archives, ", commas(grand.archives))
+			grand.print()
+		}
+	}
+	summarize(failures, len(config.archives))
+	return len(failures) == 0
+}
+
+// failure records why an archive failed.
+type failure struct {
+	archive string
+	err     error
+}
+
+// Returns failures with the archive's failure appended, having logged the
+// error if verbose.
+func report(failures []failure, archive string, err error,
+	verbose bool) []failure {
+	if verbose && !jsonErrors { // with --json they're only summarized
+		complain(err.Error())
+	}
+	return append(failures, failure{archive, err})
+}
+
+// Logs each failing archive (once) and why it failed.
+func summarize(failures []failure, count int) {
+	if len(failures) == 0 {
+		return
+	}
+	if jsonErrors {
+		for _, failure := range failures {
+			complainAbout(failure.archive, "", failure.err.Error(), "")
+		}
+		return
+	}
+	failed := map[string]bool{}
+	for _, failure := range failures {
+		failed[failure.archive] = true
+	}
+	complain(fmt.Sprintf("%d of %d archive%s had errors:", len(failed),
+		count, s(count)))
+	for _, failure := range failures {
+		diagnostic("  %s: %s", failure.archive, failure.err)
+	}
+}
+
+// totals holds counts for --totals.
+type totals struct {
+	archives int
+	members  int
+	size     int64
+}
+
+func (me *totals) add(other totals) {
+	me.archives += other.archives
+	me.members += other.members
+	me.size += other.size
+}
+
+func (me *totals) print() {
+	output("%s member%s, %s bytes\n", commas(me.members),
+		s(me.members), commas64(me.size))
+}
+
+// Prints PASS or FAIL for the archive and returns nil if it passed or an
+// error explaining why it failed.
+func verify(archive string, config *config) error {
+	name := config.displayName(archive)
+	if err := config.checksums.verify(archive, name); err != nil {
+		complainAbout(name, "", err.Error(),
+			fmt.Sprintf("FAIL %s: %s", name, err))
+		return fmt.Errorf("failed verification: %w", err)
+	}
+	if !config.quiet {
+		output("PASS %s\n", name)
+	}
+	return nil
+}
+
+// Prints FAIL for each of the archive's corrupt members (and the archive
+// if it can't be read to its end) or PASS if it is intact, and returns an
+// error if it isn't.
+func check(archive string, config *config) error {
+	name := config.displayName(archive)
+	failures, err := unz.CheckIntegrity(archive, config.options)
+	for _, failure := range failures {
+		complainAbout(name, "", failure.Error(),
+			fmt.Sprintf("FAIL %s: %s", name, failure))
+	}
+	if err != nil {
+		if !errors.Is(err, unz.ErrBroken) {
+			complainAbout(name, "", err.Error(),
+				fmt.Sprintf("FAIL %s: %s", name, err))
+		}
+		return err
+	}
+	if !config.quiet {
+		output("PASS %s\n", name)
+	}
+	return nil
+}
+
+// Reads every (wanted) member of the archive in full, reporting each as
+// OK or FAILED, followed by the overall result. Returns an error if any
+// member failed or the archive couldn't be read to its end.
+func test(archive string, config *config) error {
+	name := config.displayName(archive)
+	if !config.quiet {
+		output("testing %s\n", bold(name))
+	}
+	count := 0
+	failures, err := unz.TestIntegrity(archive, config.options,
+		func(member unz.Member, err error) {
+			count++
+			if err != nil {
+				complainAbout(name, member.Name, err.Error(),
+					fmt.Sprintf("    FAILED  %s: %s", member.Name, err))
+			} else if !config.quiet {
+				output("    OK      %s\n", member.Name)
+			}
+		})
+	if err != nil {
+		if !errors.Is(err, unz.ErrBroken) {
+			complainAbout(name, "", err.Error(),
+				fmt.Sprintf("FAILED %s: %s", name, err))
+		} else {
+			message := fmt.Sprintf("%s of %s member%s failed",
+				commas(len(failures)), commas(count), s(count))
+			complainAbout(name, "", message,
+				fmt.Sprintf("FAILED %s: %s", name, message))
+		}
+		return err
+	}
+	if !config.quiet {
+		output("no errors detected in %s (%s member%s)\n", name,
+			commas(count), s(count))
+	}
+	return nil
+}
+
+// Writes the content of the archive's wanted regular file members to
+// stdout as it is. Unless concatenating, it is an error (checked before
+// anything is written) if more than one member is wanted; and it is an
+// error if none is.
+func writeToStdout(archive string, config *config) error {
+	if !config.concat {
+		members, err := unz.ListWith(archive, config.options)
+		if err != nil {
+			return err
+		}
+		names := []string{}
+		for _, member := range members {
+			if member.Mode.IsRegular() && !member.IsDir() &&
+				config.options.WantedMember(member) {
+				names = append(names, member.Name)
+			}
+		}
+		if len(names) > 1 {
+			return fmt.Errorf("%s has %s wanted members (e.g., %s and %s) "+
+				"but --to-stdout writes only one unless --concatenate is "+
+				"given", config.displayName(archive), commas(len(names)),
+				names[0], names[1])
+		}
+	}
+	written := 0
+	err := unz.WalkWith(archive, config.options,
+		func(member unz.Member, reader io.Reader) error {
+			if !member.Mode.IsRegular() || member.IsDir() {
+				return nil
+			}
+			written++
+			bar.clear()
+			if _, err := io.Copy(os.Stdout, reader); err != nil {
+				return fmt.Errorf("failed to write %s to stdout: %w",
+					member.Name, err)
+			}
+			return nil
+		})
+	if err == nil && written == 0 {
+		err = fmt.Errorf("%s has no wanted members to write to stdout",
+			config.displayName(archive))
+	}
+	return err
+}
+
+// Creates the --output archive from the sources (given as the archives)
+// and returns false if that failed.
+func create(config *config) bool {
+	err := unz.Create(config.output, config.archives, config.options)
+	if err != nil {
+		complain(err.Error())
+		return false
+	}
+	return true
+}
+
+type config struct {
+	verbose   bool
+	quiet     bool
+	unpack    bool
+	check     bool
+	test      bool
+	toStdout  bool
+	manifest  string
+	created   []string // paths unpacked (for manifest)
+	concat    bool
+	create    bool
+	long      bool
+	human     bool
+	si        bool
+	sortBy    string
+	totals    bool
+	headers   bool
+	tree      bool
+	maxDepth  int
+	format    listFormat
+	count     bool
+	dirsOnly  bool
+	filesOnly bool
+	emptyDirs bool
+	unsafe    bool
+	comments  bool
+	prefix    string
+	common    bool
+	reverse   bool
+	output    string
+	timeout   time.Duration
+	checksums checksums
+	options   unz.Options
+	archives  []string
+	stdin     string // the temporary copy of the archive read from stdin
+}
+
+func getConfig() *config {
+	parser := clip.NewParserUser("unz", unz.Version)
+	parser.LongDesc = `Unpacks (or lists) each archive (.tar, .tar.gz,
+	.tar.bz2, .tar.xz, .tar.zst, .tar.lz4, .tar.br, .tar.Z, .tgz, .tzst,
+	.tlz4, .tbr, .taZ, .zip, .7z, .a, .deb, or .cpio, which may be
+	compressed like a tarball, e.g., .cpio.gz). Use - to read an archive
+	from stdin.
+	Single compressed files (.gz, .bz2, .xz, .zst, .lz4, .br, or .Z) are
+	decompressed, e.g., file.txt.gz → file.txt. Brotli files have no magic
+	number so they're only recognized by their suffix (or by --format).
+
+	When unpacking (the default behavior), if all of an archive's members
+	are inside a single top-level folder (a "wrapper", e.g., GitHub's
+	repo-abcdef1/), that folder is stripped and its contents are unpacked
+	directly into the output folder (by default the current folder); use
+	--keep-wrapper to prevent this. Otherwise, for each archive at most one
+	file or folder is created in the output folder. If the archive contains
+	one file or folder, that file or folder is unpacked into the output
+	folder. If the archive contains more than one member, then a new
+	subfolder is created based on the archive's name, and all the archive's
+	contents are unpacked into the subfolder.
+
+	With --create, the positional arguments are instead the files and
+	folders to pack into a new archive named by --output.`
+	parser.PositionalCount = clip.OneOrMorePositionals
+	_ = parser.SetPositionalVarName("ARCHIVE")
+	verboseOpt := parser.Flag("verbose", "Show actions.")
+	quietOpt := parser.Flag("quiet",
+		"Only output errors (and when listing, the members' names or "+
+			"details), e.g., when only the exit status matters.")
+	listOpt := parser.Flag("list",
+		"List each archive's contents (don't unpack).")
+	countOpt := parser.Flag("count",
+		"List only each archive's number of (wanted) members, preceded "+
+			"by the archive's name if there's more than one archive "+
+			"(implies --list; with --totals, end with the total).")
+	countOpt.SetShortName(clip.NoShortName)
+	longOpt := parser.Flag("long",
+		"When listing, show each member's permissions, size, and "+
+			"modification time.")
+	longOpt.SetShortName('L')
+	humanOpt := parser.Flag("human",
+		"When listing with --long, show sizes in KiB, MiB, GiB, etc. "+
+			"(powers of 1024) rather than in bytes.")
+	humanOpt.SetShortName(clip.NoShortName)
+	siOpt := parser.Flag("si",
+		"When listing with --long, show sizes in kB, MB, GB, etc. "+
+			"(powers of 1000) rather than in bytes.")
+	siOpt.SetShortName(clip.NoShortName)
+	commentsOpt := parser.Flag("comments",
+		"When listing a zip file, show its comment (if any) after its "+
+			"name, and each member's comment (if any; or with "+
+			"--verbose, even if empty) after the member's name. (Other "+
+			"formats don't have comments.)")
+	commentsOpt.SetShortName(clip.NoShortName)
+	sortKeys := []string{"name", "size", "time"}
+	sortOpt := parser.Choice("sort",
+		"When listing, sort the members by name, size, or time "+
+			"[default: archive order].", sortKeys, "")
+	_ = sortOpt.SetVarName("KEY")
+	reverseOpt := parser.Flag("reverse",
+		"When listing with --sort, sort in reverse order.")
+	reverseOpt.SetShortName('r')
+	totalsOpt := parser.Flag("totals",
+		"When listing, follow each archive's members with their count "+
+			"and total size, and if there's more than one archive, end "+
+			"with a grand total.")
+	formatOpt := parser.Choice("format",
+		"Treat every archive as being in the given format rather than "+
+			"detecting it from its content and name. Formats: "+
+			strings.Join(unz.Formats, " ")+".", unz.Formats, "")
+	_ = formatOpt.SetVarName("FORMAT")
+	colors := []string{"auto", "always", "never"}
+	colorOpt := parser.Choice("color",
+		"Whether to use bold and underline: auto (only for output to a "+
+			"terminal, unless NO_COLOR is set or TERM is dumb), always, "+
+			"or never.", colors, "auto")
+	colorOpt.SetShortName(clip.NoShortName)
+	_ = colorOpt.SetVarName("WHEN")
+	treeOpt := parser.Flag("tree",
+		"When listing, show the members as a tree (like the tree "+
+			"command) with folders first.")
+	treeOpt.SetShortName(clip.NoShortName)
+	listDepthOpt := parser.IntInRange("list_depth",
+		"When listing, show only members with at most N path "+
+			"components; the members of each folder N deep are "+
+			"summarized on one line, e.g., 'src/lib/... (142 more)' "+
+			"[default: 0, show every member].", 0, math.MaxInt16, 0)
+	listDepthOpt.SetShortName(clip.NoShortName)
+	_ = listDepthOpt.SetVarName("N")
+	listFormatOpt := parser.Str("list_format",
+		"When listing, output each member as TEMPLATE with each {field} "+
+			"replaced by the member's value, e.g., "+
+			`"{size}\t{mtime}\t{name}". Fields: name, size (in bytes), `+
+			"csize (compressed size), mtime, mode, method (compression "+
+			"method), crc (CRC-32), and isdir (true or false); csize, "+
+			"method, and crc are - except for zip members. Use \\t, "+
+			"\\n, and \\\\ for a tab, newline, and backslash, and {{ and "+
+			"}} for literal braces [default: {name}].", "")
+	listFormatOpt.SetShortName(clip.NoShortName)
+	_ = listFormatOpt.SetVarName("TEMPLATE")
+	dirsOnlyOpt := parser.Flag("dirs_only",
+		"When listing, only show folders, e.g., to see an archive's "+
+			"layout at a glance.")
+	dirsOnlyOpt.SetShortName(clip.NoShortName)
+	filesOnlyOpt := parser.Flag("files_only",
+		"When listing, only show members that aren't folders (files and "+
+			"links).")
+	filesOnlyOpt.SetShortName(clip.NoShortName)
+	emptyDirsOpt := parser.Flag("empty_dirs",
+		"When listing, only show empty folders (those with no other "+
+			"members inside them), which are unpacked as empty folders.")
+	emptyDirsOpt.SetShortName(clip.NoShortName)
+	markUnsafeOpt := parser.Flag("mark_unsafe",
+		"When listing, follow the paths of members that would be "+
+			"skipped when unpacking (e.g., ../../evil or /etc/passwd) "+
+			"with [unsafe: REASON]. This is the default with --verbose. "+
+			"(Not with --tree or --list-format.)")
+	markUnsafeOpt.SetShortName(clip.NoShortName)
+	stripPrefixOpt := parser.Str("strip_prefix",
+		"When listing, remove PATH/ from the start of members' paths, "+
+			"e.g., to show project-1.2.3/src/main.go as src/main.go (and "+
+			"omit PATH itself).", "")
+	stripPrefixOpt.SetShortName(clip.NoShortName)
+	_ = stripPrefixOpt.SetVarName("PATH")
+	stripCommonOpt := parser.Flag("strip_common",
+		"When listing, like --strip-prefix but remove the longest folder "+
+			"path common to all the listed members (if any).")
+	stripCommonOpt.SetShortName(clip.NoShortName)
+	headersOpt := parser.Flag("verbose_headers",
+		"When listing, follow each member with all its header fields "+
+			"(e.g., a tar member's type, link, owner, device numbers, and "+
+			"times, or a zip member's compression method, CRC-32, and "+
+			"flags).")
+	headersOpt.SetShortName(clip.NoShortName)
+	charsetOpt := parser.Choice("charset",
+		"The character set of zip members' names that aren't flagged "+
+			"as UTF-8 (utf8 means leave them as they are) [default: "+
+			"cp437 for zip files made on DOS or Windows; otherwise utf8]."+
+			" Charsets: "+strings.Join(unz.Charsets, " ")+".",
+		unz.Charsets, "")
+	charsetOpt.SetShortName(clip.NoShortName)
+	_ = charsetOpt.SetVarName("CHARSET")
+	normalizeSeparatorsOpt := parser.Flag("normalize_separators",
+		"Treat \\ in every zip member's name as a path separator, e.g., "+
+			"for zip files made by Windows tools that don't say so. (This "+
+			"is always done for zip files made on DOS or Windows; \\ is "+
+			"otherwise a valid filename character on Unix.)")
+	normalizeSeparatorsOpt.SetShortName(clip.NoShortName)
+	outputOpt := parser.Str("output",
+		"The folder to unpack into (created if necessary) [default: the "+
+			"current folder, or with --chdir, its folder].", "")
+	_ = outputOpt.SetVarName("FOLDER")
+	chdirOpt := parser.Str("chdir",
+		"Unpack relative to FOLDER (which must exist) as if it were the "+
+			"current folder: it becomes the default output folder, and a "+
+			"relative --output is inside it. Archives are still found "+
+			"relative to the current folder.", "")
+	chdirOpt.SetShortName('C')
+	_ = chdirOpt.SetVarName("FOLDER")
+	noPreserveTimesOpt := parser.Flag("no_preserve_times",
+		"Don't set unpacked files' and folders' modification times to "+
+			"those stored in the archive.")
+	noPreserveTimesOpt.SetShortName(clip.NoShortName)
+	noPreservePermsOpt := parser.Flag("no_preserve_perms",
+		"Ignore the permissions stored in the archive and use 0644 for "+
+			"files and 0755 for folders.")
+	noPreservePermsOpt.SetShortName(clip.NoShortName)
+	includeOpt := parser.Strs("include",
+		"Only list or unpack members whose paths (or parent folders' "+
+			"paths) match one of the given glob patterns, e.g., '*.go' "+
+			"or 'docs' (or at any depth, '**/*.go'). Use -- before the "+
+			"archives.")
+	_ = includeOpt.SetVarName("GLOB")
+	excludeOpt := parser.Strs("exclude",
+		"Don't list or unpack members whose paths (or parent folders' "+
+			"paths) match any of the given glob patterns; excludes take "+
+			"precedence over includes. Use -- before the archives.")
+	_ = excludeOpt.SetVarName("GLOB")
+	excludeVCSOpt := parser.Flag("exclude_vcs",
+		"Don't list or unpack version control folders and other junk, "+
+			"i.e., as if given --exclude "+
+			strings.Join(unz.VCSExcludes, " ")+".")
+	excludeVCSOpt.SetShortName(clip.NoShortName)
+	memberOpt := parser.Str("member",
+		"Only list or unpack the member with exactly the given path; "+
+			"repeat for more than one, e.g., -m a.txt -m b/c.txt. They "+
+			"are unpacked directly into the output folder (with their "+
+			"parent folders) and it is an error if any isn't in the "+
+			"archive.", "")
+	_ = memberOpt.SetVarName("NAME")
+	clashes := []string{"error", "rename"}
+	flattenOpt := parser.Choice("flatten",
+		"Unpack files directly into the output folder using their "+
+			"basenames, skipping folders. If two files would have the "+
+			"same name, fail (error, the default if only --flatten is "+
+			"given) or add a numeric suffix to the later ones (rename), "+
+			"e.g., --flatten=rename.", clashes, "error")
+	flattenOpt.AllowImplicit = true
+	flattenOpt.SetShortName(clip.NoShortName)
+	_ = flattenOpt.SetVarName("CLASH")
+	sinceOpt := parser.Str("since",
+		"Only list or unpack members modified at or after TIME, given "+
+			"as RFC 3339 (e.g., 2023-06-30T18:00:00Z), a date (e.g., "+
+			"2023-06-30, at local midnight), or a time ago (e.g., 90m, "+
+			"12h, 7d, or 2w). Members without a usable time are included "+
+			"unless --strict-times is given.", "")
+	sinceOpt.SetShortName(clip.NoShortName)
+	_ = sinceOpt.SetVarName("TIME")
+	beforeOpt := parser.Str("before",
+		"Only list or unpack members modified before TIME (given as for "+
+			"--since).", "")
+	beforeOpt.SetShortName(clip.NoShortName)
+	_ = beforeOpt.SetVarName("TIME")
+	strictTimesOpt := parser.Flag("strict_times",
+		"With --since or --before, exclude members without a usable "+
+			"modification time (e.g., zero or before 1980).")
+	strictTimesOpt.SetShortName(clip.NoShortName)
+	stripOpt := parser.IntInRange("strip_components",
+		"When unpacking, remove the first N path components from each "+
+			"member's path, skipping members that have no more than N.",
+		0, math.MaxInt32, 0)
+	stripOpt.SetShortName(clip.NoShortName)
+	_ = stripOpt.SetVarName("N")
+	renameOpt := parser.Strs("rename",
+		"Rename members (when listing or unpacking) with a sed-style "+
+			"substitution, e.g., 's/^project-[0-9.]+/project/' or "+
+			"'s|\\.TXT$|.txt|i' (& is the match, \\1 to \\9 are "+
+			"groups, and the g flag replaces every match). May be given "+
+			"more than once: they're applied in order after "+
+			"--strip-components. Use -- before the archives.")
+	renameOpt.SetShortName(clip.NoShortName)
+	_ = renameOpt.SetVarName("EXPR")
+	keepWrapperOpt := parser.Flag("keep_wrapper",
+		"Don't strip an archive's single top-level folder.")
+	keepWrapperOpt.SetShortName(clip.NoShortName)
+	preserveOwnerOpt := parser.Flag("preserve_owner",
+		"When run as root, set unpacked tarball members' owner and group "+
+			"to those stored in the archive. (Zip files don't store "+
+			"owners.)")
+	preserveOwnerOpt.SetShortName(clip.NoShortName)
+	ownerMapOpt := parser.Strs("owner_map",
+		"With --preserve-owner, give members owned by the archived user "+
+			"FROM (a uid or user name) the local user TO (a user name or "+
+			"uid) instead, e.g., 1000:alice. May be given more than "+
+			"once; unmapped owners are unchanged. Use -- before the "+
+			"archives.")
+	ownerMapOpt.SetShortName(clip.NoShortName)
+	_ = ownerMapOpt.SetVarName("FROM:TO")
+	groupMapOpt := parser.Strs("group_map",
+		"Like --owner-map but for groups, e.g., 1000:staff.")
+	groupMapOpt.SetShortName(clip.NoShortName)
+	_ = groupMapOpt.SetVarName("FROM:TO")
+	xattrsOpt := parser.Flag("xattrs",
+		"Restore unpacked tarball members' extended attributes (e.g., "+
+			"SELinux labels and file capabilities, which need root) "+
+			"from their PAX records (Linux, macOS, and BSD only).")
+	xattrsOpt.SetShortName(clip.NoShortName)
+	specialOpt := parser.Flag("special",
+		"Create tarball members that are FIFOs, and if run as root, "+
+			"character and block devices (Linux and macOS only); "+
+			"otherwise these are skipped with a warning.")
+	specialOpt.SetShortName(clip.NoShortName)
+	forceOpt := parser.Flag("force",
+		"If a file has the name of a folder that must be created for an "+
+			"archive (e.g., the subfolder named after it), replace the "+
+			"file rather than failing.")
+	forceOpt.SetShortName(clip.NoShortName)
+	atomicOpt := parser.Flag("atomic",
+		"Unpack each archive into a temporary folder inside the output "+
+			"folder and only if that succeeds move what was unpacked into "+
+			"the output folder, so that either everything is unpacked or "+
+			"nothing is. Fails if any of the files or folders to be moved "+
+			"already exists.")
+	atomicOpt.SetShortName(clip.NoShortName)
+	cleanupOpt := parser.Flag("cleanup",
+		"If unpacking an archive into a new subfolder named after it "+
+			"fails part way through, remove the subfolder. (A partly "+
+			"written file is always removed.)")
+	cleanupOpt.SetShortName(clip.NoShortName)
+	stopOnErrorOpt := parser.Flag("stop_on_error",
+		"Stop unpacking an archive at the first member that can't be "+
+			"read, e.g., because the archive is corrupt (the default).")
+	stopOnErrorOpt.SetShortName(clip.NoShortName)
+	keepBrokenOpt := parser.Flag("keep_broken",
+		"Skip members that can't be read (with a warning) and unpack as "+
+			"many of the others as possible; the archive still counts "+
+			"as failed. Zip files' members are independent, but a "+
+			"tarball (or a 7z file's compressed stream) can't be read "+
+			"past a corrupt header (or stream), so only the members "+
+			"before it are unpacked (and kept, even with --cleanup).")
+	keepBrokenOpt.SetShortName(clip.NoShortName)
+	warnDuplicatesOpt := parser.Flag("warn_duplicates",
+		"Warn about each member (other than a folder) with the same path "+
+			"as an earlier one (as --verbose does); when unpacking, the "+
+			"later one replaces the earlier one.")
+	warnDuplicatesOpt.SetShortName(clip.NoShortName)
+	noDuplicatesOpt := parser.Flag("no_duplicates",
+		"Refuse to unpack an archive if any of its (wanted) members "+
+			"other than folders have the same path.")
+	noDuplicatesOpt.SetShortName(clip.NoShortName)
+	noClobberSymlinksOpt := parser.Flag("no_clobber_symlinks",
+		"Skip (with a warning) members whose paths are existing soft "+
+			"links [default: remove such a link and unpack the member in "+
+			"its place; links are never followed].")
+	noClobberSymlinksOpt.SetShortName(clip.NoShortName)
+	derefLinksOpt := parser.Flag("dereference_links",
+		"Unpack each soft link as a copy of the file it points to in "+
+			"the archive (e.g., for filesystems that don't support soft "+
+			"links); links to folders, to missing members, or outside "+
+			"the archive are skipped with a warning.")
+	derefLinksOpt.SetShortName(clip.NoShortName)
+	strictLinksOpt := parser.Flag("strict_links",
+		"With --dereference-links, stop with an error at a soft link "+
+			"that points outside the archive rather than skip it.")
+	strictLinksOpt.SetShortName(clip.NoShortName)
+	overwriteOpt := parser.Flag("overwrite",
+		"Replace existing files when unpacking (the default).")
+	overwriteOpt.SetShortName(clip.NoShortName)
+	keepNewerOpt := parser.Flag("keep_newer",
+		"Only replace existing files that are older than the archive's "+
+			"members.")
+	keepNewerOpt.SetShortName(clip.NoShortName)
+	skipExistingOpt := parser.Flag("skip_existing",
+		"Never replace existing files.")
+	skipExistingOpt.SetShortName(clip.NoShortName)
+	resumeOpt := parser.Flag("resume",
+		"Continue unpacking an archive that was interrupted: skip the "+
+			"existing files that already have their member's size and "+
+			"modification time (so were fully written), and replace any "+
+			"others (e.g., partly written ones). Links and folders are "+
+			"recreated.")
+	resumeOpt.SetShortName(clip.NoShortName)
+	jobsOpt := parser.IntInRange("jobs",
+		"The number of zip members to unpack concurrently [default: "+
+			"the number of CPUs]. Tarballs are always unpacked "+
+			"sequentially.", 0, math.MaxInt16, 0)
+	_ = jobsOpt.SetVarName("N")
+	recursiveOpt := parser.Flag("recursive",
+		"Unpack any archives that are unpacked from an archive, in place, "+
+			"down to --depth levels.")
+	recursiveOpt.SetShortName(clip.NoShortName)
+	depthOpt := parser.IntInRange("depth",
+		"With --recursive, the maximum nesting of archives to unpack.",
+		1, 100, 5)
+	depthOpt.SetShortName(clip.NoShortName)
+	_ = depthOpt.SetVarName("N")
+	removeNestedOpt := parser.Flag("remove_nested",
+		"With --recursive, delete each nested archive once it has been "+
+			"unpacked.")
+	removeNestedOpt.SetShortName(clip.NoShortName)
+	maxSizeOpt := parser.Str("max_size",
+		"Stop unpacking an archive (and delete the partly written file) "+
+			"if more than SIZE bytes in total would be written, e.g., "+
+			"500K, 100M, or 2G [default: unlimited, which risks a "+
+			"\"decompression bomb\" filling the disk].", "")
+	maxSizeOpt.SetShortName(clip.NoShortName)
+	_ = maxSizeOpt.SetVarName("SIZE")
+	maxFileSizeOpt := parser.Str("max_file_size",
+		"Like --max-size but for each unpacked file.", "")
+	maxFileSizeOpt.SetShortName(clip.NoShortName)
+	_ = maxFileSizeOpt.SetVarName("SIZE")
+	bufferSizeOpt := parser.Str("buffer_size",
+		"The number of bytes to copy at a time when writing each "+
+			"unpacked file, e.g., 256K or 4M [default: 1M].", "")
+	bufferSizeOpt.SetShortName(clip.NoShortName)
+	_ = bufferSizeOpt.SetVarName("SIZE")
+	maxNameLengthOpt := parser.IntInRange("max_name_length",
+		"Skip (with a warning) members with a file or folder name longer "+
+			"than N bytes, e.g., 143 for eCryptfs [default: 255, the "+
+			"usual filesystem limit]. Members whose paths are too long for "+
+			"the OS are skipped too.", 16, 1024, unz.DefaultMaxNameLength)
+	maxNameLengthOpt.SetShortName(clip.NoShortName)
+	_ = maxNameLengthOpt.SetVarName("N")
+	truncateNamesOpt := parser.Flag("truncate_names",
+		"Rather than skip members whose names are longer than "+
+			"--max-name-length, shorten the names by replacing their ends "+
+			"(keeping any extension) with ~ and part of a hash of the name, "+
+			"warning of each new name.")
+	truncateNamesOpt.SetShortName(clip.NoShortName)
+	passwordOpt := parser.Str("password",
+		"The password for encrypted zip members (visible to other "+
+			"users via the process list; see --password-stdin).", "")
+	passwordOpt.SetShortName(clip.NoShortName)
+	_ = passwordOpt.SetVarName("PASSWORD")
+	passwordStdinOpt := parser.Flag("password_stdin",
+		"Read the password for encrypted zip members from the first "+
+			"line of stdin.")
+	passwordStdinOpt.SetShortName(clip.NoShortName)
+	verifyOpt := parser.Str("verify",
+		"Verify each archive against the checksums FILE (e.g., "+
+			"SHA256SUMS, with lines of \"hexdigest  filename\"; the "+
+			"algorithm—MD5, SHA-1, SHA-256, or SHA-512—is detected from "+
+			"the digest's length). Archives that fail aren't unpacked "+
+			"(but are listed).", "")
+	verifyOpt.SetShortName(clip.NoShortName)
+	_ = verifyOpt.SetVarName("FILE")
+	timeoutOpt := parser.Str("timeout",
+		"Give up downloading an archive given as an http:// or https:// "+
+			"URL if it takes longer than DURATION, e.g., 30s or 5m "+
+			"[default: no limit].", "")
+	timeoutOpt.SetShortName(clip.NoShortName)
+	_ = timeoutOpt.SetVarName("DURATION")
+	tmpdirOpt := parser.Str("tmpdir",
+		"The folder (which must exist and be writable) to hold the "+
+			"temporary copies of archives read from stdin or downloaded, "+
+			"and of compressed tarballs' decompressed content while "+
+			"unpacking [default: $TMPDIR, or if that's unset, the system's "+
+			"temporary folder, e.g., /tmp].", "")
+	tmpdirOpt.SetShortName(clip.NoShortName)
+	_ = tmpdirOpt.SetVarName("FOLDER")
+	checkOpt := parser.Flag("check",
+		"Check each archive's integrity (don't unpack) by reading every "+
+			"(wanted) member in full, verifying zip and 7z members' "+
+			"CRC-32s and compressed streams' checksums, and report each "+
+			"corrupt member. Tarballs have no per-member checksums, so "+
+			"they're checked by decompressing them to their end.")
+	checkOpt.SetShortName(clip.NoShortName)
+	testOpt := parser.Flag("test",
+		"Like --check but report each member that is read as OK or "+
+			"FAILED and then the archive's overall result (like "+
+			"unzip -t).")
+	testOpt.SetShortName('T')
+	toStdoutOpt := parser.Flag("to_stdout",
+		"Write the content of the (wanted) member to stdout rather than "+
+			"unpack it, e.g., with --member NAME (like tar -O). Only "+
+			"regular files are written, and it is an error if more than "+
+			"one is wanted unless --concatenate is given.")
+	toStdoutOpt.SetShortName('O')
+	concatOpt := parser.Flag("concatenate",
+		"With --to-stdout, write the content of every wanted member, "+
+			"one after another, in archive order.")
+	concatOpt.SetShortName(clip.NoShortName)
+	manifestOpt := parser.Str("manifest",
+		"When unpacking, write the absolute paths of the files, "+
+			"folders, and links that were created (and are still there "+
+			"at the end), with their permissions and sizes, to FILE: one "+
+			"per line, or with --json, as a JSON array. Folders come "+
+			"before their contents, so, e.g., the paths in reverse order "+
+			"can be removed to undo the unpacking.", "")
+	manifestOpt.SetShortName(clip.NoShortName)
+	_ = manifestOpt.SetVarName("FILE")
+	createOpt := parser.Flag("create",
+		"Pack the given files and folders (rather than archives) into "+
+			"the archive named by --output, whose format (zip, tar, "+
+			"tar.gz, or tar.xz) is given by its suffix or --format. "+
+			"Members keep their files' permissions and modification "+
+			"times; --include, --exclude, and --skip-existing apply.")
+	createOpt.SetShortName(clip.NoShortName)
+	completionOpt := parser.Choice("completion",
+		"Write a completion script for the given shell (bash, zsh, or "+
+			"fish) to stdout and quit; see the script's first lines for "+
+			"how to install it.", shells, "")
+	completionOpt.SetShortName(clip.NoShortName)
+	_ = completionOpt.SetVarName("SHELL")
+	completionOpt.Hide()
+	buildInfoOpt := parser.Flag("build_info",
+		"Show unz's version, the Go version and platform it was built "+
+			"with, and the archive formats it supports, and quit.")
+	buildInfoOpt.SetShortName(clip.NoShortName)
+	jsonOpt := parser.Flag("json",
+		"With --build-info, output JSON. Otherwise, write errors and "+
+			"warnings to stderr as JSON objects, one per line, with "+
+			"level (error or warning), archive, member, and message "+
+			"fields (archive and member are \"\" if not known).")
+	jsonOpt.SetShortName(clip.NoShortName)
+	args, members := repeatedOption(normalizedArgs(os.Args[1:]), "member",
+		'm')
+	if hasOption(args, "completion") || hasOption(args, "build_info") {
+		parser.PositionalCount = clip.ZeroOrMorePositionals
+	}
+	err := parser.ParseArgs(args)
+	if err != nil {
+		complain(err.Error())
+		os.Exit(1)
+	}
+	if completionOpt.Given() {
+		writeCompletion(completionOpt.Value(), []completion{
+			{option: verboseOpt}, {option: quietOpt}, {option: listOpt},
+			{option: countOpt},
+			{option: longOpt}, {option: humanOpt}, {option: siOpt},
+			{option: commentsOpt},
+			{option: sortOpt, choices: sortKeys},
+			{option: reverseOpt}, {option: totalsOpt},
+			{option: formatOpt, choices: unz.Formats},
+			{option: colorOpt, choices: colors}, {option: treeOpt},
+			{option: listDepthOpt},
+			{option: listFormatOpt},
+			{option: dirsOnlyOpt}, {option: filesOnlyOpt},
+			{option: emptyDirsOpt}, {option: markUnsafeOpt},
+			{option: stripPrefixOpt}, {option: stripCommonOpt},
+			{option: headersOpt},
+			{option: charsetOpt, choices: unz.Charsets},
+			{option: normalizeSeparatorsOpt},
+			{option: outputOpt, kind: folderValue},
+			{option: chdirOpt, kind: folderValue},
+			{option: noPreserveTimesOpt}, {option: noPreservePermsOpt},
+			{option: includeOpt}, {option: excludeOpt},
+			{option: excludeVCSOpt}, {option: memberOpt},
+			{option: flattenOpt, choices: clashes}, {option: sinceOpt},
+			{option: beforeOpt}, {option: strictTimesOpt},
+			{option: stripOpt}, {option: renameOpt},
+			{option: keepWrapperOpt}, {option: preserveOwnerOpt},
+			{option: ownerMapOpt}, {option: groupMapOpt},
+			{option: xattrsOpt}, {option: specialOpt}, {option: forceOpt},
+			{option: atomicOpt}, {option: cleanupOpt},
+			{option: stopOnErrorOpt},
+			{option: keepBrokenOpt}, {option: warnDuplicatesOpt},
+			{option: noDuplicatesOpt}, {option: overwriteOpt},
+			{option: timeoutOpt}, {option: tmpdirOpt, kind: folderValue},
+			{option: noClobberSymlinksOpt},
+			{option: derefLinksOpt}, {option: strictLinksOpt},
+			{option: keepNewerOpt}, {option: skipExistingOpt},
+			{option: resumeOpt},
+			{option: jobsOpt}, {option: recursiveOpt}, {option: depthOpt},
+			{option: removeNestedOpt}, {option: maxSizeOpt},
+			{option: maxFileSizeOpt}, {option: bufferSizeOpt},
+			{option: maxNameLengthOpt}, {option: truncateNamesOpt},
+			{option: passwordOpt},
+			{option: passwordStdinOpt},
+			{option: verifyOpt, kind: fileValue}, {option: checkOpt},
+			{option: testOpt}, {option: toStdoutOpt}, {option: concatOpt},
+			{option: manifestOpt, kind: fileValue},
+			{option: createOpt}, {option: buildInfoOpt},
+			{option: jsonOpt}})
+		os.Exit(0)
+	}
+	if buildInfoOpt.Value() {
+		if err := writeBuildInfo(jsonOpt.Value()); err != nil {
+			complain(err.Error())
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+	jsonErrors = jsonOpt.Value()
+	policy := unz.Overwrite
+	given := 0
+	for i, opt := range []*clip.FlagOption{overwriteOpt, keepNewerOpt,
+		skipExistingOpt, resumeOpt} {
+		if opt.Value() {
+			policy = unz.OverwritePolicy(i)
+			given++
+		}
+	}
+	switch colorOpt.Value() {
+	case "always":
+		stdoutColor, stderrColor = true, true
+	case "never":
+		stdoutColor, stderrColor = false, false
+	}
+	if (ownerMapOpt.Given() || groupMapOpt.Given()) &&
+		!preserveOwnerOpt.Value() {
+		parser.OnError(errors.New("--owner-map and --group-map need " +
+			"--preserve-owner"))
+	}
+	if strictLinksOpt.Value() && !derefLinksOpt.Value() {
+		parser.OnError(errors.New("--strict-links needs " +
+			"--dereference-links"))
+	}
+	ownerMap, err := parseIDMap(ownerMapOpt.Value(), false,
+		quietOpt.Value())
+	if err != nil {
+		parser.OnError(fmt.Errorf("invalid --owner-map: %w", err))
+	}
+	groupMap, err := parseIDMap(groupMapOpt.Value(), true, quietOpt.Value())
+	if err != nil {
+		parser.OnError(fmt.Errorf("invalid --group-map: %w", err))
+	}
+	renames := make([]unz.Rename, 0, len(renameOpt.Value()))
+	for _, expr := range renameOpt.Value() {
+		rename, err := unz.ParseRename(expr)
+		if err != nil {
+			parser.OnError(fmt.Errorf("invalid --rename: %w", err))
+		}
+		renames = append(renames, rename)
+	}
+	flatten := unz.NoFlatten
+	if flattenOpt.Given() {
+		flatten = unz.Flatten
+		if flattenOpt.Value() == "rename" {
+			flatten = unz.FlattenRename
+		}
+	}
+	if given > 1 {
+		parser.OnError(errors.New("only one of --overwrite, " +
+			"--keep-newer, --skip-existing, and --resume may be given"))
+	}
+	if resumeOpt.Value() && atomicOpt.Value() {
+		parser.OnError(errors.New("--resume can't be used with --atomic " +
+			"(which never replaces existing files)"))
+	}
+	if stopOnErrorOpt.Value() && keepBrokenOpt.Value() {
+		parser.OnError(errors.New("only one of --stop-on-error and " +
+			"--keep-broken may be given"))
+	}
+	if createOpt.Value() {
+		if listOpt.Value() || countOpt.Value() || checkOpt.Value() ||
+			testOpt.Value() {
+			parser.OnError(errors.New("--create can't be used with " +
+				"--list, --count, --check, or --test"))
+		}
+		if !outputOpt.Given() {
+			parser.OnError(errors.New("--create needs --output to name " +
+				"the archive"))
+		}
+	}
+	if checkOpt.Value() && (listOpt.Value() || countOpt.Value()) {
+		parser.OnError(errors.New("--check can't be used with --list " +
+			"or --count"))
+	}
+	if toStdoutOpt.Value() && (listOpt.Value() || countOpt.Value() ||
+		checkOpt.Value() || testOpt.Value() || createOpt.Value()) {
+		parser.OnError(errors.New("--to-stdout can't be used with " +
+			"--list, --count, --check, --test, or --create"))
+	}
+	if manifestOpt.Given() && (listOpt.Value() || countOpt.Value() ||
+		checkOpt.Value() || testOpt.Value() || createOpt.Value() ||
+		toStdoutOpt.Value()) {
+		parser.OnError(errors.New("--manifest can't be used with " +
+			"--list, --count, --check, --test, --create, or --to-stdout"))
+	}
+	if concatOpt.Value() && !toStdoutOpt.Value() {
+		parser.OnError(errors.New("--concatenate needs --to-stdout"))
+	}
+	if testOpt.Value() && (listOpt.Value() || countOpt.Value() ||
+		checkOpt.Value()) {
+		parser.OnError(errors.New("--test can't be used with --list, " +
+			"--count, or --check"))
+	}
+	var format listFormat
+	if listFormatOpt.Given() {
+		if longOpt.Value() || treeOpt.Value() {
+			parser.OnError(errors.New("--list-format can't be used with " +
+				"--long or --tree"))
+		}
+		if format, err = parseListFormat(listFormatOpt.Value()); err != nil {
+			parser.OnError(fmt.Errorf("invalid --list-format: %w", err))
+		}
+	}
+	if (dirsOnlyOpt.Value() && filesOnlyOpt.Value()) ||
+		(emptyDirsOpt.Value() && (dirsOnlyOpt.Value() ||
+			filesOnlyOpt.Value())) {
+		parser.OnError(errors.New("only one of --dirs-only, " +
+			"--files-only, and --empty-dirs may be given"))
+	}
+	if stripPrefixOpt.Given() && stripCommonOpt.Value() {
+		parser.OnError(errors.New("only one of --strip-prefix and " +
+			"--strip-common may be given"))
+	}
+	if verboseOpt.Value() && quietOpt.Value() {
+		parser.OnError(errors.New("only one of --verbose and --quiet " +
+			"may be given"))
+	}
+	if stdinCount(parser.Positionals) > 1 {
+		parser.OnError(errors.New("- (stdin) may only be given once"))
+	}
+	output := outputOpt.Value()
+	if chdirOpt.Given() {
+		if output, err = chdirOutput(chdirOpt.Value(), output); err != nil {
+			parser.OnError(fmt.Errorf("invalid --chdir: %w", err))
+		}
+	}
+	if output == "" {
+		output = cwd()
+	}
+	now := time.Now()
+	since, err := parseTime(sinceOpt.Value(), now)
+	if err != nil {
+		parser.OnError(fmt.Errorf("invalid --since: %w", err))
+	}
+	before, err := parseTime(beforeOpt.Value(), now)
+	if err != nil {
+		parser.OnError(fmt.Errorf("invalid --before: %w", err))
+	}
+	if !since.IsZero() && !before.IsZero() && !since.Before(before) {
+		parser.OnError(errors.New("--since must be earlier than --before"))
+	}
+	maxSize, err := parseSize(maxSizeOpt.Value())
+	if err != nil {
+		parser.OnError(fmt.Errorf("invalid --max-size: %w", err))
+	}
+	maxFileSize, err := parseSize(maxFileSizeOpt.Value())
+	if err != nil {
+		parser.OnError(fmt.Errorf("invalid --max-file-size: %w", err))
+	}
+	bufferSize, err := parseSize(bufferSizeOpt.Value())
+	if err == nil && bufferSize > maxBufferSize {
+		err = errors.New("expected at most 1G")
+	}
+	if err != nil {
+		parser.OnError(fmt.Errorf("invalid --buffer-size: %w", err))
+	}
+	var timeout time.Duration
+	if timeoutOpt.Given() {
+		if timeout, err = time.ParseDuration(timeoutOpt.Value()); err == nil &&
+			timeout <= 0 {
+			err = errors.New("expected a positive duration")
+		}
+		if err != nil {
+			parser.OnError(fmt.Errorf("invalid --timeout: %w", err))
+		}
+	}
+	if tmpdirOpt.Given() || needsTempFolder(parser.Positionals) {
+		if tempFolder, err = checkTempFolder(tmpdirOpt.Value()); err != nil {
+			parser.OnError(fmt.Errorf("invalid --tmpdir or TMPDIR: %w", err))
+		}
+	}
+	password := passwordOpt.Value()
+	if passwordStdinOpt.Value() {
+		if password != "" {
+			parser.OnError(errors.New("only one of --password and " +
+				"--password-stdin may be given"))
+		}
+		for _, archive := range parser.Positionals {
+			if archive == "-" {
+				parser.OnError(errors.New("--password-stdin can't be " +
+					"used when reading an archive from stdin"))
+			}
+		}
+		if password, err = readPassword(); err != nil {
+			parser.OnError(fmt.Errorf("failed to read password: %w", err))
+		}
+	}
+	var sums checksums
+	if verifyOpt.Given() {
+		if sums, err = readChecksums(verifyOpt.Value()); err != nil {
+			parser.OnError(fmt.Errorf("failed to read checksums: %w",
+				err))
+		}
+	}
+	config := &config{
+		verbose:   verboseOpt.Value(),
+		quiet:     quietOpt.Value(),
+		unpack:    !listOpt.Value() && !countOpt.Value(),
+		check:     checkOpt.Value(),
+		test:      testOpt.Value(),
+		toStdout:  toStdoutOpt.Value(),
+		manifest:  manifestOpt.Value(),
+		concat:    concatOpt.Value(),
+		create:    createOpt.Value(),
+		long:      longOpt.Value(),
+		human:     humanOpt.Value(),
+		si:        siOpt.Value(),
+		sortBy:    sortOpt.Value(),
+		totals:    totalsOpt.Value(),
+		headers:   headersOpt.Value(),
+		tree:      treeOpt.Value(),
+		maxDepth:  listDepthOpt.Value(),
+		format:    format,
+		count:     countOpt.Value(),
+		dirsOnly:  dirsOnlyOpt.Value(),
+		filesOnly: filesOnlyOpt.Value(),
+		emptyDirs: emptyDirsOpt.Value(),
+		comments:  commentsOpt.Value(),
+		unsafe:    markUnsafeOpt.Value() || verboseOpt.Value(),
+		prefix:    memberPath(stripPrefixOpt.Value()),
+		common:    stripCommonOpt.Value(),
+		reverse:   reverseOpt.Value(),
+		output:    output,
+		timeout:   timeout,
+		checksums: sums,
+		options: unz.Options{
+			Verbose:         verboseOpt.Value(),
+			Overwrite:       policy,
+			StripComponents: stripOpt.Value(),
+			NoPreserveTimes: noPreserveTimesOpt.Value(),
+			NoPreservePerms: noPreservePermsOpt.Value(),
+			Includes:        includeOpt.Value(),
+			Excludes:        excludeOpt.Value(),
+			Stdout:          writer{os.Stdout},
+			Stderr:          writer{os.Stderr},
+			Jobs:            jobsOpt.Value(),
+			RemoveNested:    removeNestedOpt.Value(),
+			MaxSize:         maxSize,
+			MaxFileSize:     maxFileSize,
+			Password:        password,
+			KeepWrapper:     keepWrapperOpt.Value(),
+			PreserveOwner:   preserveOwnerOpt.Value(),
+			OwnerMap:        ownerMap,
+			GroupMap:        groupMap,
+			Members:         members,
+			TempFolder:      tempFolder,
+			Flatten:         flatten,
+			Format:          formatOpt.Value(),
+			Special:         specialOpt.Value(),
+			Force:           forceOpt.Value(),
+			Atomic:          atomicOpt.Value(),
+			Cleanup:         cleanupOpt.Value(),
+			KeepBroken:      keepBrokenOpt.Value(),
+			Charset:         charsetOpt.Value(),
+			Backslashes:     normalizeSeparatorsOpt.Value(),
+			Since:           since,
+			Before:          before,
+			StrictTimes:     strictTimesOpt.Value(),
+			WarnDuplicates:  warnDuplicatesOpt.Value(),
+			NoDuplicates:    noDuplicatesOpt.Value(),
+			BufferSize:      int(bufferSize),
+			MaxNameLength:   maxNameLengthOpt.Value(),
+			TruncateNames:   truncateNamesOpt.Value(),
+			NoClobberLinks:  noClobberSymlinksOpt.Value(),
+			DerefLinks:      derefLinksOpt.Value(),
+			StrictLinks:     strictLinksOpt.Value(),
+			Xattrs:          xattrsOpt.Value(),
+			Renames:         renames,
+		},
+		archives: expandArchives(parser.Positionals),
+	}
+	if recursiveOpt.Value() {
+		config.options.Depth = depthOpt.Value()
+	}
+	if excludeVCSOpt.Value() {
+		config.options.Excludes = append(config.options.Excludes,
+			unz.VCSExcludes...)
+	}
+	if xattrsOpt.Value() && !unz.XattrsSupported && !config.quiet {
+		diagnostic("--xattrs isn't supported on this platform so is " +
+			"ignored")
+	}
+	if config.create || (config.unpack && !config.check && !config.test) {
+		config.options.Context = interruptContext()
+	}
+	if config.manifest != "" {
+		config.options.Created = func(path string) {
+			config.created = append(config.created, path)
+		}
+	}
+	if config.quiet {
+		config.options.Stderr = io.Discard // warnings aren't errors
+	} else if jsonErrors {
+		config.options.Stderr = &jsonWriter{}
+	}
+	if !config.quiet && config.verbose && isTerminal(os.Stderr) &&
+		!isDumbTerminal() {
+		bar = &progressBar{}
+		config.options.Progress = bar.update
+	}
+	return config
+}
+
+// Returns a context that's canceled by the first SIGINT or SIGTERM, so
+// that unpacking (or creating) stops cleanly, after which the signals'
+// default behavior is restored so that another one terminates unz at once.
+func interruptContext() context.Context {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt,
+		syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+	return ctx
+}
+
+// Returns the context that stops unpacking on a signal, or if there isn't
+// one (e.g., when listing), the background context.
+func (me *config) context() context.Context {
+	if me.options.Context != nil {
+		return me.options.Context
+	}
+	return context.Background()
+}
+
+// Returns true if unpacking (or creating) was stopped by a signal.
+func (me *config) interrupted() bool {
+	return me.options.Context != nil && me.options.Context.Err() != nil
+}
+
+// Returns the args with hyphens in long option names replaced by
+// underscores (since clip only accepts identifier names), so that, e.g.,
+// --no-preserve-times and --no_preserve_times are equivalent. A bare
+// --flatten is given its default value.
+func normalizedArgs(args []string) []string {
+	normalized := make([]string, 0, len(args))
+	for i, arg := range args {
+		if arg == "--" || arg == "-" {
+			return append(normalized, args[i:]...)
+		}
+		if strings.HasPrefix(arg, "--") {
+			name, value, hasValue := strings.Cut(arg[2:], "=")
+			arg = "--" + strings.ReplaceAll(name, "-", "_")
+			if hasValue {
+				arg += "=" + value
+			} else if arg == "--flatten" { // else the next arg is its value
+				arg += "=error"
+			}
+		}
+		normalized = append(normalized, arg)
+	}
+	return normalized
+}
+
+// Returns the args with all but the last of the given option's
+// occurrences removed, and the values of all of them in order. This lets
+// an option that takes exactly one value (so that the archives that
+// follow it aren't taken as values) be given more than once. The option
+// may be given as --name VALUE, --name=VALUE, -s VALUE, or at the end of a
+// group of flags, e.g., -vs VALUE.
+func repeatedOption(args []string, name string, short byte) ([]string,
+	[]string) {
+	type occurrence struct {
+		index   int  // of the arg holding the option
+		grouped bool // the arg is a group of flags ending with short
+		spans   int  // the number of args it uses (1 or 2)
+	}
+	occurrences := []occurrence{}
+	values := []string{}
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			break
+		}
+		if strings.HasPrefix(arg, "--"+name+"=") {
+			occurrences = append(occurrences, occurrence{i, false, 1})
+			values = append(values, arg[len(name)+3:])
+		} else if (arg == "--"+name || isShortGroup(arg, short)) &&
+			i+1 < len(args) {
+			occurrences = append(occurrences, occurrence{i,
+				len(arg) > 2 && arg[1] != '-', 2})
+			values = append(values, args[i+1])
+			i++
+		}
+	}
+	if len(occurrences) < 2 {
+		return args, values
+	}
+	kept := make([]string, 0, len(args))
+	next := 0
+	for _, occurrence := range occurrences[:len(occurrences)-1] {
+		kept = append(kept, args[next:occurrence.index]...)
+		if occurrence.grouped { // keep the other flags
+			arg := args[occurrence.index]
+			kept = append(kept, arg[:len(arg)-1])
+		}
+		next = occurrence.index + occurrence.spans
+	}
+	return append(kept, args[next:]...), values
+}
+
+// Returns true if arg is -s or a group of short flags ending with s.
+func isShortGroup(arg string, short byte) bool {
+	if len(arg) < 2 || arg[0] != '-' || arg[1] == '-' ||
+		arg[len(arg)-1] != short {
+		return false
+	}
+	for i := 1; i < len(arg); i++ {
+		if !isLetter(arg[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isLetter(c byte) bool {
+	return ('a' <= c && c <= 'z') || ('A' <= c && c <= 'Z')
+}
+
+// Returns true if the (normalized) args include the given long option,
+// e.g., when deciding whether archives are needed.
+func hasOption(args []string, name string) bool {
+	for _, arg := range args {
+		if arg == "--" {
+			break
+		}
+		if arg == "--"+name || strings.HasPrefix(arg, "--"+name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+const maxBufferSize = 1 << 30
+
+// Returns the number of bytes for a size such as 4096, 500K, 100M, or 2G
+// (using powers of 1024), or 0 (unlimited) for "".
+func parseSize(size string) (int64, error) {
+	if size == "" {
+		return 0, nil
+	}
+	factor := int64(1)
+	last := strings.ToUpper(size[len(size)-1:])
+	if i := strings.Index("KMGT", last); i > -1 {
+		factor = 1 << (10 * (i + 1))
+		size = size[:len(size)-1]
+	}
+	n, err := strconv.ParseInt(size, 10, 64)
+	if err != nil || n < 1 || n > math.MaxInt64/factor {
+		return 0, errors.New("expected a positive size, e.g., 100M")
+	}
+	return n * factor, nil
+}
+
+// Returns the time for an RFC 3339 time, a date (at local midnight), or a
+// duration ago (e.g., 90m, 12h, 7d, or 2w, counting back from now), or the
+// zero time (unbounded) for "".
+func parseTime(text string, now time.Time) (time.Time, error) {
+	if text == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, text); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", text,
+		time.Local); err == nil {
+		return t, nil
+	}
+	factor := time.Duration(1)
+	switch text[len(text)-1] {
+	case 'd':
+		factor = 24
+	case 'w':
+		factor = 7 * 24
+	}
+	if factor > 1 {
+		text = text[:len(text)-1] + "h"
+	}
+	ago, err := time.ParseDuration(text)
+	if err != nil || ago <= 0 {
+		return time.Time{}, errors.New("expected an RFC 3339 time, a " +
+			"date, or a time ago, e.g., 2023-06-30T18:00:00Z, " +
+			"2023-06-30, or 7d")
+	}
+	return now.Add(-ago * factor), nil
+}
+
+// Returns the map of archived ids (or names) to local ids given by specs
+// such as 1000:alice or bob:1001, or nil if there are none. Each TO is
+// checked against the local users (or groups if group): an unknown name
+// can't be mapped so is ignored with a warning, and an unknown id is used
+// but warned about (unless quiet).
+func parseIDMap(specs []string, group, quiet bool) (map[string]int,
+	error) {
+	if len(specs) == 0 {
+		return nil, nil
+	}
+	kind := "user"
+	if group {
+		kind = "group"
+	}
+	ids := make(map[string]int, len(specs))
+	for _, spec := range specs {
+		from, to, ok := strings.Cut(spec, ":")
+		if !ok || from == "" || to == "" {
+			return nil, fmt.Errorf("expected FROM:TO, e.g., 1000:alice, "+
+				"got %q", spec)
+		}
+		id, err := strconv.Atoi(to)
+		switch {
+		case err != nil:
+			if id, err = lookupID(to, group); err != nil {
+				if !quiet {
+					diagnostic("ignoring %s: no local %s %s", spec, kind, to)
+				}
+				continue
+			}
+		case id < 0:
+			return nil, fmt.Errorf("expected a non-negative id, got %q",
+				spec)
+		case !knownID(to, group) && !quiet:
+			diagnostic("%s: no local %s has id %d", spec, kind, id)
+		}
+		ids[from] = id
+	}
+	return ids, nil
+}
+
+// Returns the uid of the named local user (or if group, the gid of the
+// named local group).
+func lookupID(name string, group bool) (int, error) {
+	if group {
+		g, err := user.LookupGroup(name)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(g.Gid)
+	}
+	u, err := user.Lookup(name)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(u.Uid)
+}
+
+// Returns true if there's a local user (or if group, group) with the id.
+func knownID(id string, group bool) bool {
+	var err error
+	if group {
+		_, err = user.LookupGroupId(id)
+	} else {
+		_, err = user.LookupId(id)
+	}
+	return err == nil
+}
+
+// Returns the first line of stdin without its line ending.
+func readPassword() (string, error) {
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Lists the archive's wanted members (or as many as could be read) and
+// returns their totals, and an error if the archive couldn't be read.
+func listArchive(archive string, config *config) (totals, error) {
+	all, err := unz.ListWith(archive, config.options)
+	if err != nil && len(all) == 0 {
+		return totals{}, err
+	}
+	var empty map[string]bool
+	if config.emptyDirs {
+		empty = emptyFolders(all)
+	}
+	members := make([]unz.Member, 0, len(all))
+	for _, member := range all {
+		if config.options.WantedMember(member) &&
+			(!config.dirsOnly || member.IsDir()) &&
+			(!config.filesOnly || !member.IsDir()) &&
+			(!config.emptyDirs || empty[memberPath(member.Name)]) {
+			members = append(members, member)
+		}
+	}
+	if (config.verbose || config.options.WarnDuplicates) && !config.quiet {
+		for _, name := range unz.Duplicates(members) {
+			diagnostic("duplicate member %s in %s: the later one wins",
+				name, config.displayName(archive))
+		}
+	}
+	if config.common {
+		members = stripPrefix(members, commonFolder(members))
+	} else if config.prefix != "" {
+		members = stripPrefix(members, config.prefix)
+	}
+	members = renamed(members, &config.options)
+	if config.count {
+		if len(config.archives) > 1 {
+			output("%s: ", config.displayName(archive))
+		}
+		output("%s\n", config.number(len(members)))
+		return totals{archives: 1, members: len(members)}, err
+	}
+	sortMembers(members, config.sortBy, config.reverse)
+	if config.unsafe && !config.tree && config.format == nil {
+		markUnsafe(members, &config.options)
+	}
+	if config.comments && !config.tree && config.format == nil {
+		markComments(members, config.verbose)
+	}
+	if config.verbose {
+		output("%s", bold(config.displayName(archive)))
+		n := len(members)
+		output(" (%s member%s)\n", commas(n), s(n))
+	} else if !config.quiet {
+		output("%s\n", config.displayName(archive))
+	}
+	if config.comments {
+		listComment(archive, config)
+	}
+	listed := totals{archives: 1, members: len(members)}
+	for _, member := range members {
+		listed.size += member.Size
+	}
+	if config.maxDepth > 0 {
+		members = limitDepth(members, config.maxDepth)
+	}
+	if config.tree {
+		listTree(members)
+	} else if config.long {
+		listLong(members, config.headers, config.size)
+	} else if config.format != nil {
+		listFormatted(members, config.format, config.headers)
+	} else {
+		for _, member := range members {
+			output("%s\n", member.Name)
+			if config.headers {
+				listHeader(member)
+			}
+		}
+	}
+	if config.totals || (config.long && config.verbose) {
+		listed.print()
+	}
+	return listed, err
+}
+
+// Appends " [unsafe: REASON]" to the names of the members that unpacking
+// would skip as unsafe (see unz.Options.UnsafeReason()).
+func markUnsafe(members []unz.Member, options *unz.Options) {
+	for i, member := range members {
+		if reason := options.UnsafeReason(member.Name); reason != "" {
+			members[i].Name += " [unsafe: " + reason + "]"
+		}
+	}
+}
+
+// Returns the members with at most depth path components, with the
+// members below each folder depth deep replaced by one summary (named,
+// e.g., "src/lib/... (142 more)") in place of the first of them. Each
+// summary's size is the total of the members it stands for, and its
+// time is the latest of theirs.
+func limitDepth(members []unz.Member, depth int) []unz.Member {
+	limited := make([]unz.Member, 0, len(members))
+	summaries := map[string]int{} // folder → index in limited
+	counts := map[string]int{}    // folder → members summarized
+	for _, member := range members {
+		parts := strings.Split(strings.Trim(member.Name, "/"), "/")
+		if len(parts) <= depth {
+			limited = append(limited, member)
+			continue
+		}
+		folder := strings.Join(parts[:depth], "/")
+		i, ok := summaries[folder]
+		if !ok {
+			i = len(limited)
+			summaries[folder] = i
+			limited = append(limited, unz.Member{})
+		}
+		counts[folder]++
+		summary := &limited[i]
+		summary.Size += member.Size
+		if member.ModTime.After(summary.ModTime) {
+			summary.ModTime = member.ModTime
+		}
+	}
+	for folder, i := range summaries {
+		limited[i].Name = fmt.Sprintf("%s/... (%s more)", folder,
+			commas(counts[folder]))
+	}
+	return limited
+}
+
+// Appends ` [comment: "TEXT"]` to the names of the zip members that have
+// a comment (or if all, to every zip member's name).
+func markComments(members []unz.Member, all bool) {
+	for i, member := range members {
+		if header, ok := member.Header.(*zip.FileHeader); ok &&
+			(all || header.Comment != "") {
+			members[i].Name += fmt.Sprintf(" [comment: %q]",
+				header.Comment)
+		}
+	}
+}
+
+// Prints the zip archive's comment (if it has one) as it is.
+func listComment(archive string, config *config) {
+	comment, err := unz.Comment(archive, config.options)
+	if err != nil && !config.quiet {
+		diagnostic("failed to read the comment of %s: %s",
+			config.displayName(archive), err)
+	}
+	if comment != "" {
+		output("%s", comment)
+		if !strings.HasSuffix(comment, "\n") {
+			output("\n")
+		}
+	}
+}
+
+// Returns the members with their names changed by the options' Renames,
+// omitting any renamed to "".
+func renamed(members []unz.Member, options *unz.Options) []unz.Member {
+	if len(options.Renames) == 0 {
+		return members
+	}
+	result := make([]unz.Member, 0, len(members))
+	for _, member := range members {
+		if member.Name = options.Renamed(member.Name); member.Name != "" {
+			result = append(result, member)
+		}
+	}
+	return result
+}
+
+// Returns the members with "prefix/" removed from the start of the names
+// of those inside the prefix folder; the prefix folder itself is dropped.
+func stripPrefix(members []unz.Member, prefix string) []unz.Member {
+	if prefix == "" {
+		return members
+	}
+	stripped := make([]unz.Member, 0, len(members))
+	for _, member := range members {
+		name := memberPath(member.Name)
+		if name == prefix {
+			continue
+		}
+		if strings.HasPrefix(name, prefix+"/") {
+			member.Name = name[len(prefix)+1:]
+			if member.IsDir() {
+				member.Name += "/"
+			}
+		}
+		stripped = append(stripped, member)
+	}
+	return stripped
+}
+
+// Returns the longest folder path that all the members are in (or are),
+// e.g., "project-1.2.3", or "" if there isn't one. (This compares whole
+// path components using "/", so unlike gong.LongestCommonPath it is the
+// same on every platform.)
+func commonFolder(members []unz.Member) string {
+	var common []string
+	for i, member := range members {
+		parts := strings.Split(memberPath(member.Name), "/")
+		if !member.IsDir() {
+			parts = parts[:len(parts)-1]
+		}
+		if i == 0 {
+			common = parts
+			continue
+		}
+		n := 0
+		for n < len(common) && n < len(parts) && common[n] == parts[n] {
+			n++
+		}
+		common = common[:n]
+	}
+	return strings.Join(common, "/")
+}
+
+// Returns the paths (see memberPath()) of the folder members that have no
+// other members inside them. Each is unpacked as an empty folder (whether
+// it's a tar folder entry, a zip name ending in "/", or a 7z folder).
+func emptyFolders(members []unz.Member) map[string]bool {
+	empty := map[string]bool{}
+	for _, member := range members {
+		if member.IsDir() {
+			empty[memberPath(member.Name)] = true
+		}
+	}
+	for _, member := range members {
+		name := memberPath(member.Name)
+		for i := strings.LastIndexByte(name, '/'); i > -1; i = strings.
+			LastIndexByte(name, '/') {
+			name = name[:i]
+			delete(empty, name)
+		}
+	}
+	return empty
+}
+
+// Returns the member's name (or a path) without any leading "./" or
+// trailing "/".
+func memberPath(name string) string {
+	if name = path.Clean(filepath.ToSlash(name)); name == "." {
+		return ""
+	}
+	return name
+}
+
+// Returns n as a plain integer, or with commas if verbose.
+func (me *config) number(n int) string {
+	if me.verbose {
+		return commas(n)
+	}
+	return strconv.Itoa(n)
+}
+
+// Returns the size n in bytes with commas, or if human or si, in the
+// largest unit that keeps it at least 1.
+func (me *config) size(n int64) string {
+	if me.human || me.si {
+		return humanizeBytes(n, me.si)
+	}
+	return commas64(n)
+}
+
+// Sorts the members by the given key ("name", "size", or "time"), leaving
+// them in archive order if the key is "".
+func sortMembers(members []unz.Member, key string, reverse bool) {
+	var less func(a, b unz.Member) bool
+	switch key {
+	case "name":
+		less = func(a, b unz.Member) bool { return a.Name < b.Name }
+	case "size":
+		less = func(a, b unz.Member) bool { return a.Size < b.Size }
+	case "time":
+		less = func(a, b unz.Member) bool {
+			return a.ModTime.Before(b.ModTime)
+		}
+	default:
+		return
+	}
+	sort.SliceStable(members, func(i, j int) bool {
+		if reverse {
+			return less(members[j], members[i])
+		}
+		return less(members[i], members[j])
+	})
+}
+
+// Prints each member's permissions, size, modification time, and name in
+// aligned columns, like ls -l, optionally followed by its header. Sizes
+// are formatted using size().
+func listLong(members []unz.Member, headers bool,
+	size func(int64) string) {
+	width := 0
+	for _, member := range members {
+		if w := len(size(member.Size)); w > width {
+			width = w
+		}
+	}
+	for _, member := range members {
+		output("%s %*s %s %s\n", member.Mode, width,
+			size(member.Size), member.ModTime.Format("2006-01-02 15:04"),
+			member.Name)
+		if headers {
+			listHeader(member)
+		}
+	}
+}
+
+// Returns the archives with each one that isn't "-", a URL, or an
+// existing file, and that contains any of *, ?, or [, replaced by the
+// (sorted) files it matches as a glob pattern (see filepath.Match()), if
+// any. This is for Windows, whose shells don't expand wildcards; on Unix
+// the shell has already expanded them, and a pattern that matched nothing
+// is left as it is (and so fails to open).
+func expandArchives(archives []string) []string {
+	expanded := make([]string, 0, len(archives))
+	for _, archive := range archives {
+		if archive != "-" && !isURL(archive) &&
+			strings.ContainsAny(archive, "*?[") {
+			if _, err := os.Stat(archive); err != nil {
+				if matches, err := filepath.Glob(archive); err == nil &&
+					len(matches) > 0 {
+					expanded = append(expanded, matches...)
+					continue
+				}
+			}
+		}
+		expanded = append(expanded, archive)
+	}
+	return expanded
+}
+
+// The folder for temporary copies of archives read from stdin or
+// downloaded, and for unz.Options.TempFolder ("" means os.TempDir(),
+// i.e., $TMPDIR or the system's).
+var tempFolder string
+
+// Returns how many of the archives are "-"; stdin can only be read once.
+func stdinCount(archives []string) int {
+	count := 0
+	for _, archive := range archives {
+		if archive == "-" {
+			count++
+		}
+	}
+	return count
+}
+
+// Returns true if any of the archives is "-" (stdin) or a URL.
+func needsTempFolder(archives []string) bool {
+	for _, archive := range archives {
+		if archive == "-" || isURL(archive) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the folder (or if "", os.TempDir()) as an absolute path having
+// checked that a file can be created in it, or returns an error.
+func checkTempFolder(folder string) (string, error) {
+	if folder == "" {
+		folder = os.TempDir()
+	}
+	folder, err := filepath.Abs(folder)
+	if err != nil {
+		return "", err
+	}
+	file, err := os.CreateTemp(folder, ".unz-")
+	if err != nil {
+		return "", fmt.Errorf("%s isn't a writable folder: %w", folder,
+			err)
+	}
+	file.Close()
+	os.Remove(file.Name())
+	return folder, nil
+}
+
+// Copies stdin to a temporary file (since zip and format sniffing need
+// random access) and returns the file's name, or an error. The file is
+// called "stdin" so that any subfolder created for it has that name.
+func readStdin() (string, error) {
+	folder, err := os.MkdirTemp(tempFolder, "unz-")
+	if err != nil {
+		return "", fmt.Errorf(
+			"failed to create temporary folder for stdin: %w", err)
+	}
+	archive := filepath.Join(folder, "stdin")
+	file, err := os.Create(archive)
+	if err == nil {
+		_, err = io.Copy(file, os.Stdin)
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	if err != nil {
+		os.RemoveAll(folder)
+		return "", fmt.Errorf("failed to read stdin: %w", err)
+	}
+	return archive, nil
+}
+
+// Returns the archive's name as the user gave it.
+func (me *config) displayName(archive string) string {
+	if archive == me.stdin {
+		return "-"
+	}
+	if url, ok := downloads[archive]; ok {
+		return url
+	}
+	return archive
+}
+
+func s(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+func commas(i int) string {
+	return commas64(int64(i))
+}
+
+func commas64(i int64) string {
+	pos := true
+	s := strconv.FormatInt(i, 10)
+	if s[0] == '-' {
+		pos = false
+		s = s[1:]
+	}
+	n := len(s) - 3
+	for n >= 0 {
+		s = s[:n] + "," + s[n:]
+		n -= 3
+	}
+	if s[0] == ',' {
+		s = s[1:]
+	}
+	if !pos {
+		s = "-" + s
+	}
+	return s
+}
+
+// Returns n bytes as, e.g., "512 B", "1.5 KiB", or "20 MiB" (or if si,
+// "1.5 kB", "20 MB", etc., in powers of 1000), rounded to one decimal
+// place below 10 and to a whole number otherwise.
+func humanizeBytes(n int64, si bool) string {
+	unit, prefixes, suffix := 1024.0, "KMGTPE", "iB"
+	if si {
+		unit, prefixes, suffix = 1000.0, "kMGTPE", "B"
+	}
+	if float64(n) < unit {
+		return strconv.FormatInt(n, 10) + " B"
+	}
+	value := float64(n) / unit
+	i := 0
+	for value >= unit-0.5 && i+1 < len(prefixes) { // would round to unit
+		value /= unit
+		i++
+	}
+	if value < 9.95 {
+		return fmt.Sprintf("%.1f %c%s", value, prefixes[i], suffix)
+	}
+	return fmt.Sprintf("%.0f %c%s", value, prefixes[i], suffix)
+}
+
+// Returns the output folder resolved relative to the (existing) chdir
+// folder, or the chdir folder itself if output is "".
+func chdirOutput(chdir, output string) (string, error) {
+	info, err := os.Stat(chdir)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s isn't a folder", chdir)
+	}
+	if filepath.IsAbs(output) {
+		return output, nil
+	}
+	return filepath.Abs(filepath.Join(chdir, output))
+}
+
+func cwd() string {
+	dir, err := os.Getwd()
+	if err == nil {
+		return dir
+	}
+	dir, err = filepath.Abs(".")
+	if err == nil {
+		return dir
+	}
+	return "."
+}
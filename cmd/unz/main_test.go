@@ -0,0 +1,292 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark-summerfield/unz"
+)
+
+func TestChdirOutput(t *testing.T) {
+	base := t.TempDir()
+	chdir := filepath.Join(base, "work")
+	if err := os.Mkdir(chdir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(base, "file")
+	if err := os.WriteFile(file, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	relChdir, err := filepath.Rel(cwd, chdir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	absOutput := filepath.Join(base, "elsewhere")
+	for _, test := range []struct {
+		chdir  string
+		output string
+		want   string // "" for an error
+	}{
+		{chdir, "", chdir},
+		{chdir, "out", filepath.Join(chdir, "out")},
+		{chdir, "../out", filepath.Join(base, "out")},
+		{chdir, absOutput, absOutput},
+		{relChdir, "", chdir},
+		{relChdir, "out", filepath.Join(chdir, "out")},
+		{filepath.Join(base, "missing"), "out", ""},
+		{file, "out", ""},
+	} {
+		got, err := chdirOutput(test.chdir, test.output)
+		if test.want == "" {
+			if err == nil {
+				t.Errorf("%s %s: expected an error, got %s", test.chdir,
+					test.output, got)
+			}
+		} else if err != nil || got != test.want {
+			t.Errorf("%s %s: expected %s, got %s %v", test.chdir,
+				test.output, test.want, got, err)
+		}
+	}
+}
+
+func TestEmptyFolders(t *testing.T) {
+	for _, test := range []struct {
+		names []string // folders end with "/"
+		want  []string
+	}{
+		{nil, []string{}},
+		{[]string{"p/", "p/a.txt", "p/logs/"}, []string{"p/logs"}},
+		{[]string{"a/", "a/b/c.txt", "d/", "./d/e/"}, []string{"d/e"}},
+		{[]string{"p/", "p/logs"}, []string{}}, // logs is a file
+		{[]string{"empty/"}, []string{"empty"}},
+		{[]string{"x/", "x/y/", "x/y/z/"}, []string{"x/y/z"}},
+	} {
+		members := make([]unz.Member, 0, len(test.names))
+		for _, name := range test.names {
+			members = append(members, unz.Member{Name: name})
+		}
+		got := make([]string, 0, len(test.want))
+		for name := range emptyFolders(members) {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: expected %q, got %q", test.names, test.want, got)
+		}
+	}
+}
+
+func TestHumanizeBytes(t *testing.T) {
+	for _, test := range []struct {
+		n      int64
+		binary string
+		si     string
+	}{
+		{0, "0 B", "0 B"},
+		{999, "999 B", "999 B"},
+		{1000, "1000 B", "1.0 kB"},
+		{1023, "1023 B", "1.0 kB"},
+		{1024, "1.0 KiB", "1.0 kB"},
+		{1536, "1.5 KiB", "1.5 kB"},
+		{10188, "9.9 KiB", "10 kB"},
+		{10190, "10 KiB", "10 kB"},
+		{999_499, "976 KiB", "999 kB"},
+		{999_500, "976 KiB", "1.0 MB"},
+		{1_048_575, "1.0 MiB", "1.0 MB"},
+		{1_536_000, "1.5 MiB", "1.5 MB"},
+		{123_456_789, "118 MiB", "123 MB"},
+		{1 << 40, "1.0 TiB", "1.1 TB"},
+		{1<<63 - 1, "8.0 EiB", "9.2 EB"},
+	} {
+		if got := humanizeBytes(test.n, false); got != test.binary {
+			t.Errorf("%d: expected %q, got %q", test.n, test.binary, got)
+		}
+		if got := humanizeBytes(test.n, true); got != test.si {
+			t.Errorf("%d si: expected %q, got %q", test.n, test.si, got)
+		}
+	}
+}
+
+// Sorting by size uses the byte counts, not how they are shown.
+func TestSortMembersBySize(t *testing.T) {
+	members := []unz.Member{{Name: "a", Size: 10190}, {Name: "b", Size: 900},
+		{Name: "c", Size: 10188}, {Name: "d", Size: 2_000_000},
+		{Name: "e", Size: 10188}}
+	for _, test := range []struct {
+		reverse bool
+		want    string
+	}{
+		{false, "bcead"},
+		{true, "daceb"},
+	} {
+		sorted := append([]unz.Member{}, members...)
+		sortMembers(sorted, "size", test.reverse)
+		var got strings.Builder
+		for _, member := range sorted {
+			got.WriteString(member.Name)
+		}
+		if got.String() != test.want {
+			t.Errorf("reverse %t: expected %s, got %s", test.reverse,
+				test.want, got.String())
+		}
+	}
+}
+
+func TestLimitDepth(t *testing.T) {
+	var members []unz.Member
+	base := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, name := range []string{"p/", "p/README", "p/src/", "p/src/a.go",
+		"p/src/lib/", "p/src/lib/b.go", "p/src/lib/c.go",
+		"p/src/lib/deep/", "p/src/lib/deep/d.go", "p/docs/x.md"} {
+		size := int64(len(name)) // its time is base + size hours
+		members = append(members, unz.Member{Name: name, Size: size,
+			ModTime: base.Add(time.Duration(size) * time.Hour)})
+	}
+	for _, test := range []struct {
+		depth int
+		want  []string // name size hours
+	}{
+		{1, []string{"p/ 2 2", "p/... (9 more) 107 19"}},
+		{2, []string{"p/ 2 2", "p/README 8 8", "p/src/ 6 6",
+			"p/src/... (6 more) 82 19", "p/docs/... (1 more) 11 11"}},
+		{3, []string{"p/ 2 2", "p/README 8 8", "p/src/ 6 6",
+			"p/src/a.go 10 10", "p/src/lib/ 10 10",
+			"p/src/lib/... (4 more) 62 19", "p/docs/x.md 11 11"}},
+		{4, []string{"p/ 2 2", "p/README 8 8", "p/src/ 6 6",
+			"p/src/a.go 10 10", "p/src/lib/ 10 10", "p/src/lib/b.go 14 14",
+			"p/src/lib/c.go 14 14", "p/src/lib/deep/ 15 15",
+			"p/src/lib/deep/... (1 more) 19 19", "p/docs/x.md 11 11"}},
+		{5, []string{"p/ 2 2", "p/README 8 8", "p/src/ 6 6",
+			"p/src/a.go 10 10", "p/src/lib/ 10 10", "p/src/lib/b.go 14 14",
+			"p/src/lib/c.go 14 14", "p/src/lib/deep/ 15 15",
+			"p/src/lib/deep/d.go 19 19", "p/docs/x.md 11 11"}},
+	} {
+		var got []string
+		for _, member := range limitDepth(members, test.depth) {
+			got = append(got, fmt.Sprintf("%s %d %d", member.Name,
+				member.Size, int(member.ModTime.Sub(base).Hours())))
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("depth %d: expected\n%q\ngot\n%q", test.depth,
+				test.want, got)
+		}
+	}
+}
+
+func TestExpandArchives(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.zip", "b.zip", "c.tar.gz", "lit*.zip",
+		"litany.zip"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil,
+			0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	join := func(names string) []string {
+		var paths []string
+		for _, name := range strings.Fields(names) {
+			if name == "-" || strings.Contains(name, "://") {
+				paths = append(paths, name)
+			} else {
+				paths = append(paths, filepath.Join(dir, name))
+			}
+		}
+		return paths
+	}
+	for _, test := range []struct {
+		args string
+		want string
+	}{
+		{"a.zip c.tar.gz", "a.zip c.tar.gz"},
+		{"*.zip", "a.zip b.zip lit*.zip litany.zip"},
+		{"[ab].zip c.*", "a.zip b.zip c.tar.gz"},
+		{"?.zip c.tar.gz", "a.zip b.zip c.tar.gz"},
+		{"lit*.zip", "lit*.zip"}, // an existing file
+		{"lit?ny.zip", "litany.zip"},
+		{"nomatch*.zip", "nomatch*.zip"},
+		{"[.zip", "[.zip"}, // malformed
+		{"- https://example.com/*.zip", "- https://example.com/*.zip"},
+	} {
+		got := expandArchives(join(test.args))
+		if want := join(test.want); !reflect.DeepEqual(got, want) {
+			t.Errorf("%q: expected %q, got %q", test.args, want, got)
+		}
+	}
+}
+
+func TestRepeatedOption(t *testing.T) {
+	for _, test := range []struct {
+		args   string
+		kept   string
+		values []string
+	}{
+		{"-l app.tar.gz", "-l app.tar.gz", []string{}},
+		{"-O --member config.yaml app.tar.gz",
+			"-O --member config.yaml app.tar.gz", []string{"config.yaml"}},
+		{"-m a -m b x.zip", "-m b x.zip", []string{"a", "b"}},
+		{"--member=a --member b -m c x.zip y.zip", "-m c x.zip y.zip",
+			[]string{"a", "b", "c"}},
+		{"-vm a -lm b x.zip", "-v -lm b x.zip", []string{"a", "b"}},
+		{"-m a -- -m b", "-m a -- -m b", []string{"a"}},
+		{"-m2 a", "-m2 a", []string{}},
+	} {
+		kept, values := repeatedOption(strings.Fields(test.args), "member",
+			'm')
+		if got := strings.Join(kept, " "); got != test.kept {
+			t.Errorf("%q: expected args %q, got %q", test.args, test.kept,
+				got)
+		}
+		if !reflect.DeepEqual(values, test.values) {
+			t.Errorf("%q: expected values %q, got %q", test.args,
+				test.values, values)
+		}
+	}
+}
+
+func TestStdinCount(t *testing.T) {
+	for _, test := range []struct {
+		args string
+		want int
+	}{
+		{"a.zip b.zip", 0},
+		{"- a.zip", 1},
+		{"- a.zip -", 2},
+		{"-- a.zip", 0},
+	} {
+		if got := stdinCount(strings.Fields(test.args)); got != test.want {
+			t.Errorf("%q: expected %d, got %d", test.args, test.want, got)
+		}
+	}
+}
+
+func TestDisplayName(t *testing.T) {
+	stdin := filepath.Join(t.TempDir(), "stdin")
+	other := filepath.Join(t.TempDir(), "stdin")
+	config := &config{stdin: stdin}
+	for _, test := range []struct {
+		archive string
+		want    string
+	}{
+		{stdin, "-"},
+		{other, other},
+		{"a.zip", "a.zip"},
+	} {
+		if got := config.displayName(test.archive); got != test.want {
+			t.Errorf("%q: expected %q, got %q", test.archive, test.want,
+				got)
+		}
+	}
+}
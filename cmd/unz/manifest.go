@@ -0,0 +1,79 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// manifestEntry is a path that was unpacked, as --manifest records it.
+type manifestEntry struct {
+	Path string      `json:"path"`
+	Type string      `json:"type"` // "file", "folder", "link", or "special"
+	Mode string      `json:"mode"` // permissions in octal, e.g., "0644"
+	Size int64       `json:"size"` // 0 for folders and special files
+	mode os.FileMode // for the text form
+}
+
+// Writes the paths that were created (in the order they were created,
+// each once) that are still on disk, with their final types, modes, and
+// sizes, to the named file: as a JSON array if asJSON, or else one per
+// line in the form "-rw-r--r-- 1234 /abs/path". Since folders come before
+// their contents, the lines in reverse order can be used to remove what
+// was unpacked.
+func writeManifest(filename string, paths []string, asJSON bool) error {
+	entries := make([]manifestEntry, 0, len(paths))
+	seen := map[string]bool{}
+	for _, path := range paths {
+		if seen[path] {
+			continue // e.g., a file overwritten by a later member
+		}
+		seen[path] = true
+		info, err := os.Lstat(path)
+		if err != nil {
+			continue // e.g., a nested archive removed once unpacked
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		entry := manifestEntry{Path: path, Type: "special",
+			Mode: fmt.Sprintf("%04o", info.Mode().Perm()),
+			Size: info.Size(), mode: info.Mode()}
+		switch mode := info.Mode(); {
+		case mode.IsRegular():
+			entry.Type = "file"
+		case mode.IsDir():
+			entry.Type = "folder"
+		case mode&os.ModeSymlink != 0:
+			entry.Type = "link"
+		}
+		if entry.Type != "file" && entry.Type != "link" {
+			entry.Size = 0
+		}
+		entries = append(entries, entry)
+	}
+	var text []byte
+	if asJSON {
+		data, err := json.MarshalIndent(entries, "", "  ")
+		if err != nil {
+			return err
+		}
+		text = append(data, '\n')
+	} else {
+		var lines strings.Builder
+		for _, entry := range entries {
+			fmt.Fprintf(&lines, "%s %d %s\n", entry.mode, entry.Size,
+				entry.Path)
+		}
+		text = []byte(lines.String())
+	}
+	if err := os.WriteFile(filename, text, 0o644); err != nil {
+		return fmt.Errorf("failed to write manifest %s: %w", filename, err)
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mark-summerfield/gong"
+)
+
+// All unz's output goes through output() or diagnostic() (or the library
+// via writer) so that data (listings, totals, and verification results,
+// and verbose reports) always goes to stdout, and diagnostics (errors,
+// warnings, and progress) always go to stderr.
+
+// Writes listing or result data to stdout.
+func output(format string, args ...any) {
+	bar.clear()
+	fmt.Fprintf(os.Stdout, format, args...)
+}
+
+// Writes a diagnostic line (e.g., a warning) to stderr.
+func diagnostic(format string, args ...any) {
+	if jsonErrors {
+		logJSON(diagnosticRecord{Level: "warning", Archive: currentArchive,
+			Message: fmt.Sprintf(format, args...)})
+		return
+	}
+	bar.clear()
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+// Writes an error line to stderr, underlined if stderr uses color.
+func complain(text string) {
+	if jsonErrors {
+		logJSON(diagnosticRecord{Level: "error", Archive: currentArchive,
+			Message: text})
+		return
+	}
+	diagnostic("%s", underline(text))
+}
+
+// Writes an error about the archive (and if not "", its member) to
+// stderr: with --json, as a record with the given message (unless an
+// identical one was already written); otherwise as the text (see
+// complain()).
+func complainAbout(archive, member, message, text string) {
+	if jsonErrors {
+		record := diagnosticRecord{Level: "error", Archive: archive,
+			Member: member, Message: message}
+		if !loggedErrors[record] { // e.g., once inline and in the summary
+			loggedErrors[record] = true
+			logJSON(record)
+		}
+		return
+	}
+	complain(text)
+}
+
+// The error records already written by complainAbout().
+var loggedErrors = map[diagnosticRecord]bool{}
+
+// If true (--json), diagnostics are written to stderr as JSON objects,
+// one per line, so that they can be parsed by other programs.
+var jsonErrors bool
+
+// The archive being processed, for diagnostics that don't say which
+// archive they're about (e.g., the library's warnings).
+var currentArchive string
+
+// diagnosticRecord is a diagnostic as written to stderr with --json. The
+// archive and member are "" if not known.
+type diagnosticRecord struct {
+	Level   string `json:"level"` // "error" or "warning"
+	Archive string `json:"archive"`
+	Member  string `json:"member"`
+	Message string `json:"message"`
+}
+
+// Writes the record to stderr as a line of JSON.
+func logJSON(record diagnosticRecord) {
+	bar.clear()
+	data, _ := json.Marshal(record) // can't fail for strings
+	os.Stderr.Write(append(data, '\n'))
+}
+
+// jsonWriter is used for the library's Options.Stderr with --json: each
+// line written to it (a warning) is written to stderr as a record.
+type jsonWriter struct {
+	pending []byte // the start of a line that hasn't been ended yet
+}
+
+func (me *jsonWriter) Write(p []byte) (int, error) {
+	me.pending = append(me.pending, p...)
+	for {
+		i := bytes.IndexByte(me.pending, '\n')
+		if i == -1 {
+			return len(p), nil
+		}
+		logJSON(diagnosticRecord{Level: "warning", Archive: currentArchive,
+			Message: string(me.pending[:i])})
+		me.pending = me.pending[i+1:]
+	}
+}
+
+// writer is used for the library's Options.Stdout (verbose reports) and
+// Options.Stderr (warnings) so that it can erase the progress bar first.
+type writer struct {
+	file *os.File
+}
+
+func (me writer) Write(p []byte) (int, error) {
+	bar.clear()
+	return me.file.Write(p)
+}
+
+// Whether to use escape codes for bold and underline on stdout and stderr
+// (--color always or never overrides these).
+var stdoutColor, stderrColor = useColor(os.Stdout), useColor(os.Stderr)
+
+// Returns true if the file is a terminal that understands escape codes and
+// color isn't turned off by a non-empty NO_COLOR (see no-color.org).
+func useColor(file *os.File) bool {
+	return isTerminal(file) && !isDumbTerminal() &&
+		os.Getenv("NO_COLOR") == ""
+}
+
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// Returns true if TERM is dumb, i.e., escape codes (for color or for
+// redrawing the progress bar) aren't understood.
+func isDumbTerminal() bool {
+	return os.Getenv("TERM") == "dumb"
+}
+
+// Returns the text in bold if stdout uses color.
+func bold(text string) string {
+	if stdoutColor {
+		return gong.Bold(text)
+	}
+	return text
+}
+
+// Returns the text underlined if stderr uses color.
+func underline(text string) string {
+	if stderrColor {
+		return gong.Underline(text)
+	}
+	return text
+}
+
+// The progress bar if verbose and stderr is a terminal; otherwise nil.
+var bar *progressBar
+
+// progressBar shows a one line progress bar on stderr for the file being
+// written.
+type progressBar struct {
+	shown   bool
+	percent int64
+}
+
+const barWidth = 30
+
+func (me *progressBar) update(name string, done, total int64) {
+	if total <= 0 {
+		me.draw(fmt.Sprintf("%s bytes %s", commas64(done), name))
+		return
+	}
+	percent := done * 100 / total
+	if me.shown && percent == me.percent {
+		return // only redraw when there's a visible change
+	}
+	me.percent = percent
+	filled := int(done * barWidth / total)
+	me.draw(fmt.Sprintf("[%s%s] %3d%% %s", strings.Repeat("#", filled),
+		strings.Repeat("-", barWidth-filled), percent, name))
+}
+
+func (me *progressBar) draw(line string) {
+	fmt.Fprint(os.Stderr, "\r\x1B[K"+gong.ElideMiddle(line, 79))
+	me.shown = true
+}
+
+// Erases the bar (if shown) so that other output can be written; does
+// nothing if me is nil.
+func (me *progressBar) clear() {
+	if me != nil && me.shown {
+		fmt.Fprint(os.Stderr, "\r\x1B[K")
+		me.shown = false
+	}
+}
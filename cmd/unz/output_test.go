@@ -0,0 +1,62 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The null device stands in for a terminal since it is a character
+// device too.
+func TestUseColor(t *testing.T) {
+	terminal, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer terminal.Close()
+	if !isTerminal(terminal) {
+		t.Skipf("%s isn't a character device", os.DevNull)
+	}
+	file, err := os.Create(filepath.Join(t.TempDir(), "out.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	defer func(stdout, stderr bool) {
+		stdoutColor, stderrColor = stdout, stderr
+	}(stdoutColor, stderrColor)
+	for _, test := range []struct {
+		noColor string
+		term    string
+		file    *os.File
+		want    bool
+	}{
+		{"", "xterm", terminal, true},
+		{"", "", terminal, true},
+		{"1", "xterm", terminal, false},
+		{"0", "xterm", terminal, false}, // any non-empty value
+		{"", "dumb", terminal, false},
+		{"", "xterm", file, false},
+		{"1", "dumb", file, false},
+	} {
+		t.Setenv("NO_COLOR", test.noColor)
+		t.Setenv("TERM", test.term)
+		got := useColor(test.file)
+		if got != test.want {
+			t.Errorf("NO_COLOR=%q TERM=%q %s: expected %t, got %t",
+				test.noColor, test.term, test.file.Name(), test.want, got)
+		}
+		stdoutColor, stderrColor = got, got
+		for _, text := range []string{bold("archive"),
+			underline("warning")} {
+			if plain := !strings.Contains(text, "\x1b["); plain == got {
+				t.Errorf("NO_COLOR=%q TERM=%q %s: got %q", test.noColor,
+					test.term, test.file.Name(), text)
+			}
+		}
+	}
+}
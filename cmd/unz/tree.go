@@ -0,0 +1,78 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/mark-summerfield/unz"
+)
+
+// treeNode is a folder (with children) or a file in a --tree listing.
+type treeNode struct {
+	name     string
+	folder   bool
+	children map[string]*treeNode
+}
+
+// Prints the members as a tree, like the tree command, with each folder's
+// subfolders (in bold if stdout uses color) before its files, and each
+// sorted by name.
+func listTree(members []unz.Member) {
+	root := &treeNode{folder: true}
+	for _, member := range members {
+		name := strings.TrimPrefix(path.Clean(member.Name), "./")
+		if name == "." || name == "/" {
+			continue
+		}
+		node := root
+		parts := strings.Split(strings.Trim(name, "/"), "/")
+		for i, part := range parts {
+			node = node.child(part)
+			if i < len(parts)-1 || member.IsDir() {
+				node.folder = true
+			}
+		}
+	}
+	root.print("")
+}
+
+func (me *treeNode) child(name string) *treeNode {
+	if me.children == nil {
+		me.children = map[string]*treeNode{}
+	}
+	node, ok := me.children[name]
+	if !ok {
+		node = &treeNode{name: name}
+		me.children[name] = node
+	}
+	return node
+}
+
+func (me *treeNode) print(indent string) {
+	children := make([]*treeNode, 0, len(me.children))
+	for _, child := range me.children {
+		children = append(children, child)
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].folder != children[j].folder {
+			return children[i].folder
+		}
+		return children[i].name < children[j].name
+	})
+	for i, child := range children {
+		branch, more := "├── ", "│   "
+		if i == len(children)-1 {
+			branch, more = "└── ", "    "
+		}
+		name := child.name
+		if child.folder {
+			name = bold(name + "/")
+		}
+		output("%s%s%s\n", indent, branch, name)
+		child.print(indent + more)
+	}
+}
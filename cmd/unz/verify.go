@@ -0,0 +1,106 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package main
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checksums maps archive basenames to their lowercase hex digests.
+type checksums map[string]string
+
+// Reads a checksums file such as SHA256SUMS, i.e., lines of the form
+// "hexdigest  filename" (or "hexdigest *filename" for binary mode).
+// Blank lines and lines beginning with # are ignored.
+func readChecksums(filename string) (checksums, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	sums := checksums{}
+	scanner := bufio.NewScanner(file)
+	lino := 0
+	for scanner.Scan() {
+		lino++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		digest, name, ok := strings.Cut(line, " ")
+		name = strings.TrimPrefix(strings.TrimLeft(name, " "), "*")
+		if !ok || name == "" || newHash(digest) == nil {
+			return nil, fmt.Errorf("%s:%d: invalid checksum line", filename,
+				lino)
+		}
+		sums[filepath.Base(name)] = strings.ToLower(digest)
+	}
+	return sums, scanner.Err()
+}
+
+// Returns a hash suitable for the digest based on its length, or nil if
+// the digest isn't a valid hex MD5, SHA-1, SHA-256, or SHA-512 digest.
+func newHash(digest string) hash.Hash {
+	if _, err := hex.DecodeString(digest); err != nil {
+		return nil
+	}
+	switch len(digest) {
+	case 32:
+		return md5.New()
+	case 40:
+		return sha1.New()
+	case 64:
+		return sha256.New()
+	case 128:
+		return sha512.New()
+	}
+	return nil
+}
+
+// Returns the name of the digest's algorithm (which must be valid).
+func hashName(digest string) string {
+	switch len(digest) {
+	case 32:
+		return "MD5"
+	case 40:
+		return "SHA-1"
+	case 64:
+		return "SHA-256"
+	}
+	return "SHA-512"
+}
+
+// Returns nil if the archive's digest matches the one for the given name;
+// otherwise an error explaining why not.
+func (me checksums) verify(archive, name string) error {
+	expected, ok := me[filepath.Base(name)]
+	if !ok {
+		return fmt.Errorf("no checksum for %s", name)
+	}
+	file, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	hash := newHash(expected)
+	if _, err := io.Copy(hash, file); err != nil {
+		return err
+	}
+	if actual := hex.EncodeToString(hash.Sum(nil)); actual != expected {
+		return fmt.Errorf("%s mismatch: expected %s got %s",
+			hashName(expected), expected, actual)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Decompresses a single compressed file (that isn't a tarball) into the
+// dest folder, e.g., foo.txt.gz → foo.txt. The decompressed file is
+// unpacked like an archive's only member, so it is subject to the filters
+// and is counted in the end-of-archive summary.
+func unpackCompressed(archive, dest string, compression compression,
+	options *Options) error {
+	member := Member{Name: compressedName(archive), Mode: 0o644}
+	if info, err := os.Stat(archive); err == nil {
+		member.Mode = info.Mode().Perm()
+		member.ModTime = info.ModTime()
+	}
+	members := []Member{member}
+	if err := options.checkMembers(archive, members); err != nil {
+		return err
+	}
+	if !options.WantedMember(member) {
+		if options.Verbose {
+			fmt.Fprintln(options.Stdout, "no members to unpack")
+		}
+		return nil
+	}
+	reader, closer, err := openDecompressed(archive, compression)
+	if err != nil {
+		return err
+	}
+	defer closer()
+	unpacker, err := newArchiveUnpacker(archive, dest, members, options)
+	if err != nil {
+		return err
+	}
+	defer unpacker.finish()
+	if err := unpacker.unpackCompressedMember(member, reader); err != nil {
+		return unpacker.failed(err)
+	}
+	return unpacker.brokenErr()
+}
+
+func (me *unpacker) unpackCompressedMember(member Member,
+	reader io.Reader) error {
+	name, ok := me.stripped(member.Name)
+	if !ok {
+		return nil
+	}
+	name, ok = me.memberPath(name, false)
+	if !ok || !me.shouldWrite(name, member.ModTime) {
+		return nil
+	}
+	if err := makeParent(name); err != nil {
+		return err
+	}
+	mode := me.fileMode(member.Mode)
+	if err := me.writeFile(reader, name, mode, -1); err != nil {
+		if me.keptBroken(member.Name, err) {
+			return nil
+		}
+		return fmt.Errorf("failed to decompress %s: %w", me.archive, err)
+	}
+	me.setMode(name, mode)
+	me.setTime(name, member.ModTime)
+	me.report("created file %s", name)
+	me.recordCreated(name)
+	return nil
+}
+
+// Returns the single member of a compressed file (that isn't a tarball).
+// Its size is computed by decompressing.
+func compressedMembers(archive string, compression compression) ([]Member,
+	error) {
+	only := Member{Name: compressedName(archive), Mode: 0o644}
+	if info, err := os.Stat(archive); err == nil {
+		only.Mode = info.Mode().Perm()
+		only.ModTime = info.ModTime()
+	}
+	reader, closer, err := openDecompressed(archive, compression)
+	if err != nil {
+		return []Member{}, err
+	}
+	defer closer()
+	size, err := io.Copy(io.Discard, reader)
+	only.Size = size
+	if err != nil {
+		return []Member{only}, fmt.Errorf("failed to read %s: %w", archive,
+			err)
+	}
+	return []Member{only}, nil
+}
+
+// Returns the archive's basename with its compression suffix removed, or
+// with .out appended if it doesn't have a recognized suffix.
+func compressedName(archive string) string {
+	name := filepath.Base(archive)
+	for _, suffix := range []string{".GZ", ".BZ2", ".XZ", ".ZST", ".LZ4",
+		".BR", ".Z"} {
+		if hasSuffixFold(name, suffix) && len(name) > len(suffix) {
+			return name[:len(name)-len(suffix)]
+		}
+	}
+	return name + ".out"
+}
@@ -0,0 +1,330 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/ulikunitz/xz"
+)
+
+// CreateFormats are the names of the formats that Create() can write (a
+// subset of Formats).
+var CreateFormats = []string{"zip", "tar", "tar.gz", "tar.xz"}
+
+// Create writes a new archive containing the sources: files, folders (with
+// all their contents), and symlinks (stored as links). Each source is added
+// under its basename (e.g., ~/proj becomes proj/...), except for "." whose
+// contents are added directly. Members keep their files' permissions and
+// modification times. The format is opts.Format (one of CreateFormats) or
+// if that's "", the one the archive's name indicates (e.g., .zip, .tar.gz,
+// or .tgz). Only the members that opts.Wanted() are added, and if
+// opts.Verbose, each is reported to opts.Stdout. Anything that isn't a
+// file, folder, or symlink (e.g., a FIFO) is skipped with a warning written
+// to opts.Stderr. The archive is written to a temporary file which only
+// replaces the archive if everything succeeds (and is removed on SIGINT or
+// SIGTERM, or if opts.Context is given, once that is done). An existing
+// archive is replaced unless opts.Overwrite is KeepNewer or SkipExisting,
+// in which case an ErrExists error is returned.
+func Create(archive string, sources []string, opts Options) error {
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
+	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+	form, err := createFormat(archive, opts.Format)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(archive); err == nil && opts.Overwrite !=
+		Overwrite {
+		return fmt.Errorf("failed to create %s: %w", archive, ErrExists)
+	}
+	temp, err := os.CreateTemp(filepath.Dir(archive), ".unz-*")
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", archive, err)
+	}
+	if opts.Context == nil { // else canceling it stops writing
+		stop := onSignal(func() { _ = os.Remove(temp.Name()) })
+		defer stop()
+	}
+	err = writeArchive(temp, archive, form, sources, &opts)
+	if cerr := temp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		if err = os.Chmod(temp.Name(), 0o644); err == nil {
+			err = os.Rename(temp.Name(), archive)
+		}
+		if err != nil {
+			err = fmt.Errorf("failed to create %s: %w", archive, err)
+		}
+	}
+	if err != nil {
+		_ = os.Remove(temp.Name())
+	}
+	return err
+}
+
+// Returns the named format (one of CreateFormats), or if name is "", the
+// format indicated by the archive's name (its content, if any, is
+// ignored since it is to be replaced).
+func createFormat(archive, name string) (format, error) {
+	var form format
+	switch {
+	case name != "":
+		var err error
+		if form, err = archiveFormat(archive, name); err != nil {
+			return format{}, err
+		}
+	case isTarball(archive):
+		form = format{tarKind, suffixCompression(archive)}
+	case hasSuffixFold(archive, ".ZIP"):
+		form = format{zipKind, uncompressed}
+	default:
+		return format{}, fmt.Errorf("failed to create %s: its name "+
+			"doesn't indicate a format: %w", archive, ErrFormat)
+	}
+	if form.kind == zipKind || (form.kind == tarKind &&
+		(form.compression == uncompressed || form.compression == gzipped ||
+			form.compression == xzipped)) {
+		return form, nil
+	}
+	return format{}, fmt.Errorf("failed to create %s: only %v can be "+
+		"created: %w", archive, CreateFormats, ErrUnsupported)
+}
+
+// archiveWriter adds members to a new tarball or zip file.
+type archiveWriter interface {
+	add(name, path string, info fs.FileInfo) error
+	Close() error
+}
+
+// Writes the sources as an archive in the given format to file, which
+// stands in for archive.
+func writeArchive(file *os.File, archive string, form format,
+	sources []string, opts *Options) error {
+	var sink io.Writer = file
+	if opts.Context != nil {
+		sink = contextWriter{writer: file, ctx: opts.Context}
+	}
+	buffered := bufio.NewWriterSize(sink, bufferSize)
+	var writer archiveWriter
+	if form.kind == zipKind {
+		writer = &zipWriter{writer: zip.NewWriter(buffered)}
+	} else {
+		var err error
+		if writer, err = newTarWriter(buffered, form.compression); err != nil {
+			return fmt.Errorf("failed to create %s: %w", archive, err)
+		}
+	}
+	skip := map[string]bool{absolute(archive): true,
+		absolute(file.Name()): true}
+	for _, source := range sources {
+		if err := addSource(writer, source, skip, opts); err != nil {
+			_ = writer.Close()
+			return fmt.Errorf("failed to create %s: %w", archive, err)
+		}
+	}
+	err := writer.Close()
+	if ferr := buffered.Flush(); err == nil {
+		err = ferr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", archive, err)
+	}
+	return nil
+}
+
+// Adds the source (and if it's a folder, everything in it) to the writer,
+// except for the paths to skip (i.e., the archive being written).
+func addSource(writer archiveWriter, source string, skip map[string]bool,
+	opts *Options) error {
+	prefix := ""
+	if clean := filepath.Clean(source); clean != "." {
+		prefix = filepath.Base(absolute(clean))
+		if prefix == string(filepath.Separator) {
+			prefix = ""
+		}
+	}
+	return filepath.WalkDir(source, func(path string, entry fs.DirEntry,
+		err error) error {
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if err := opts.canceled(); err != nil {
+			return err
+		}
+		if skip[absolute(path)] {
+			return nil
+		}
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(filepath.Join(prefix, rel))
+		if name == "." || !opts.Wanted(name) {
+			return nil // a folder's unwanted contents may still be wanted
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() &&
+			info.Mode()&fs.ModeSymlink == 0 {
+			fmt.Fprintf(opts.Stderr, "skipping %s: not a file, folder, or "+
+				"link\n", path)
+			return nil
+		}
+		if err := writer.add(name, path, info); err != nil {
+			return fmt.Errorf("failed to add %s: %w", path, err)
+		}
+		if opts.Verbose {
+			fmt.Fprintf(opts.Stdout, "added %s\n", name)
+		}
+		return nil
+	})
+}
+
+// Returns the path made absolute, or as it is if that isn't possible.
+func absolute(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return path
+}
+
+type tarWriter struct {
+	writer     *tar.Writer
+	compressor io.WriteCloser // nil for an uncompressed tarball
+}
+
+func newTarWriter(writer io.Writer, compression compression) (*tarWriter,
+	error) {
+	var compressor io.WriteCloser
+	switch compression {
+	case gzipped:
+		compressor = gzip.NewWriter(writer)
+	case xzipped:
+		xwriter, err := xz.NewWriter(writer)
+		if err != nil {
+			return nil, err
+		}
+		compressor = xwriter
+	}
+	if compressor != nil {
+		writer = compressor
+	}
+	return &tarWriter{writer: tar.NewWriter(writer), compressor: compressor},
+		nil
+}
+
+func (me *tarWriter) add(name, path string, info fs.FileInfo) error {
+	link, err := readLink(path, info)
+	if err != nil {
+		return err
+	}
+	header, err := tar.FileInfoHeader(info, link)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if err := me.writer.WriteHeader(header); err != nil {
+		return err
+	}
+	if info.Mode().IsRegular() {
+		return copyFile(me.writer, path)
+	}
+	return nil
+}
+
+func (me *tarWriter) Close() error {
+	err := me.writer.Close()
+	if me.compressor != nil {
+		if cerr := me.compressor.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+type zipWriter struct {
+	writer *zip.Writer
+}
+
+// Folders are stored (with names ending in "/"), files are deflated, and
+// symlinks' content is their target (as Info-ZIP's zip -y does).
+func (me *zipWriter) add(name, path string, info fs.FileInfo) error {
+	link, err := readLink(path, info)
+	if err != nil {
+		return err
+	}
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	} else {
+		header.Method = zip.Deflate
+	}
+	writer, err := me.writer.CreateHeader(header)
+	if err != nil {
+		return err
+	}
+	switch {
+	case link != "":
+		_, err = io.WriteString(writer, link)
+	case info.Mode().IsRegular():
+		err = copyFile(writer, path)
+	}
+	return err
+}
+
+func (me *zipWriter) Close() error { return me.writer.Close() }
+
+// contextWriter fails with its context's error once the context is done.
+type contextWriter struct {
+	writer io.Writer
+	ctx    context.Context
+}
+
+func (me contextWriter) Write(p []byte) (int, error) {
+	if err := me.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return me.writer.Write(p)
+}
+
+// Returns the symlink's target, or "" if it isn't a symlink.
+func readLink(path string, info fs.FileInfo) (string, error) {
+	if info.Mode()&fs.ModeSymlink == 0 {
+		return "", nil
+	}
+	return os.Readlink(path)
+}
+
+func copyFile(writer io.Writer, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = io.Copy(writer, file)
+	return err
+}
@@ -0,0 +1,435 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+
+	"github.com/mark-summerfield/unz/internal/ar"
+	"github.com/mark-summerfield/unz/internal/brotli"
+	"github.com/mark-summerfield/unz/internal/cpio"
+	"github.com/mark-summerfield/unz/internal/lz4"
+	"github.com/mark-summerfield/unz/internal/ncompress"
+	"github.com/mark-summerfield/unz/internal/sevenzip"
+	"github.com/mark-summerfield/unz/internal/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+type kind uint8
+
+const (
+	zipKind kind = iota
+	tarKind
+	compressedKind // a single compressed file that isn't a tarball
+	sevenZipKind
+	rarKind  // recognized but not supported
+	cpioKind // newc or odc, read as a tarball (see headerReader)
+	arKind   // e.g., .a or .deb, read as a tarball (see headerReader)
+)
+
+// Returns true for the kinds that are read with a headerReader.
+func (me kind) readsAsTarball() bool {
+	return me == tarKind || me == cpioKind || me == arKind
+}
+
+// format is an archive's kind and (for tarballs and single compressed
+// files) its compression.
+type format struct {
+	kind        kind
+	compression compression
+}
+
+// Formats are the names of the formats that may be given to ListFormat()
+// or as Options.Format to override format detection.
+var Formats = []string{"zip", "7z", "tar", "tar.gz", "tar.bz2", "tar.xz",
+	"tar.zst", "tar.lz4", "tar.br", "tar.Z", "cpio", "cpio.gz", "cpio.bz2",
+	"cpio.xz", "cpio.zst", "cpio.lz4", "cpio.br", "cpio.Z", "ar", "gz",
+	"bz2", "xz", "zst", "lz4", "br", "Z"}
+
+var formats = map[string]format{
+	"zip":      {zipKind, uncompressed},
+	"7z":       {sevenZipKind, uncompressed},
+	"tar":      {tarKind, uncompressed},
+	"tar.gz":   {tarKind, gzipped},
+	"tar.bz2":  {tarKind, bzipped},
+	"tar.xz":   {tarKind, xzipped},
+	"tar.zst":  {tarKind, zstded},
+	"tar.lz4":  {tarKind, lz4ed},
+	"tar.br":   {tarKind, brotlied},
+	"tar.Z":    {tarKind, lzwed},
+	"cpio":     {cpioKind, uncompressed},
+	"cpio.gz":  {cpioKind, gzipped},
+	"cpio.bz2": {cpioKind, bzipped},
+	"cpio.xz":  {cpioKind, xzipped},
+	"cpio.zst": {cpioKind, zstded},
+	"cpio.lz4": {cpioKind, lz4ed},
+	"cpio.br":  {cpioKind, brotlied},
+	"cpio.Z":   {cpioKind, lzwed},
+	"ar":       {arKind, uncompressed},
+	"gz":       {compressedKind, gzipped},
+	"bz2":      {compressedKind, bzipped},
+	"xz":       {compressedKind, xzipped},
+	"zst":      {compressedKind, zstded},
+	"lz4":      {compressedKind, lz4ed},
+	"br":       {compressedKind, brotlied},
+	"Z":        {compressedKind, lzwed},
+}
+
+// Returns the named format (one of Formats), or if name is "", the
+// archive's detected format.
+func archiveFormat(archive, name string) (format, error) {
+	if name == "" {
+		return format{archiveKind(archive), archiveCompression(archive)},
+			nil
+	}
+	if form, ok := formats[name]; ok {
+		return form, nil
+	}
+	return format{}, fmt.Errorf("failed to open %s: %q: %w", archive, name,
+		ErrFormat)
+}
+
+// Returns the archive's kind based on its content, or failing that on its
+// name.
+func archiveKind(archive string) kind {
+	magic := readMagic(archive)
+	switch {
+	case bytes.HasPrefix(magic, zipMagic):
+		return zipKind
+	case bytes.HasPrefix(magic, sevenzip.Magic):
+		return sevenZipKind
+	case bytes.HasPrefix(magic, rarMagic):
+		return rarKind
+	case isUstar(magic):
+		return tarKind
+	case cpio.IsMagic(magic):
+		return cpioKind
+	case bytes.HasPrefix(magic, ar.Magic):
+		return arKind
+	}
+	if compression := archiveCompression(archive); compression !=
+		uncompressed {
+		magic := readDecompressedMagic(archive, compression)
+		switch {
+		case isUstar(magic) || isTarball(archive):
+			return tarKind
+		case cpio.IsMagic(magic) || isCpio(archive):
+			return cpioKind
+		}
+		return compressedKind
+	}
+	if isTarball(archive) {
+		return tarKind
+	}
+	if isCpio(archive) {
+		return cpioKind
+	}
+	if isAr(archive) {
+		return arKind
+	}
+	if hasSuffixFold(archive, ".7Z") {
+		return sevenZipKind
+	}
+	return zipKind
+}
+
+// Returns true if the archive's name indicates that it is a tarball, i.e.,
+// if it ends with one of tarballSuffixes. (So, e.g., "x.tar.backup.zip"
+// isn't a tarball.)
+func isTarball(archive string) bool {
+	for _, suffix := range tarballSuffixes {
+		if hasSuffixFold(archive, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+var tarballSuffixes = []string{".TAR", ".TGZ", ".TZST", ".TLZ4", ".TBR",
+	".TAZ", ".TAR.GZ", ".TAR.BZ2", ".TAR.XZ", ".TAR.ZST", ".TAR.LZ4",
+	".TAR.BR", ".TAR.Z"}
+
+// Returns true if the archive's name indicates that it is a cpio archive
+// (e.g., initrd.cpio or payload.cpio.xz).
+func isCpio(archive string) bool {
+	for _, suffix := range []string{".CPIO", ".CPIO.GZ", ".CPIO.BZ2",
+		".CPIO.XZ", ".CPIO.ZST", ".CPIO.LZ4", ".CPIO.BR", ".CPIO.Z"} {
+		if hasSuffixFold(archive, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if the archive's name indicates that it is an ar archive,
+// i.e., a static library or a Debian package.
+func isAr(archive string) bool {
+	for _, suffix := range []string{".A", ".AR", ".DEB", ".UDEB"} {
+		if hasSuffixFold(archive, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns true if name ends with suffix (which must be uppercase ASCII,
+// e.g., ".TAR.GZ") ignoring ASCII case. Unlike comparing with
+// strings.ToUpper(name), only ASCII letters are folded (some non-ASCII
+// letters uppercase to ASCII, e.g., "ſ" → "S"), so the suffix is always
+// exactly the last len(suffix) bytes of name.
+func hasSuffixFold(name, suffix string) bool {
+	if len(name) < len(suffix) {
+		return false
+	}
+	tail := name[len(name)-len(suffix):]
+	for i := 0; i < len(suffix); i++ {
+		c := tail[i]
+		if 'a' <= c && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		if c != suffix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if the name indicates that it is an archive or a compressed
+// file.
+func isArchiveName(name string) bool {
+	if isTarball(name) || isCpio(name) || isAr(name) {
+		return true
+	}
+	for _, suffix := range []string{".ZIP", ".7Z", ".GZ", ".BZ2", ".XZ",
+		".ZST", ".LZ4", ".BR", ".Z"} {
+		if hasSuffixFold(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+type compression uint8
+
+const (
+	uncompressed compression = iota
+	gzipped
+	bzipped
+	xzipped
+	zstded
+	lz4ed
+	brotlied // Brotli has no magic so is only recognized by suffix
+	lzwed    // compress (.Z)
+)
+
+var (
+	gzipMagic  = []byte{0x1F, 0x8B}
+	bzip2Magic = []byte{0x42, 0x5A, 0x68}
+	xzMagic    = []byte{0xFD, 0x37, 0x7A, 0x58, 0x5A}
+	zstdMagic  = []byte{0x28, 0xB5, 0x2F, 0xFD}
+	lz4Magic   = []byte{0x04, 0x22, 0x4D, 0x18}
+	lz4Legacy  = []byte{0x02, 0x21, 0x4C, 0x18} // lz4 -l
+	lzwMagic   = []byte{0x1F, 0x9D}
+	zipMagic   = []byte{0x50, 0x4B, 0x03, 0x04}
+	rarMagic   = []byte("Rar!\x1A\x07") // RAR 1.5-4 and RAR 5
+	ustarMagic = []byte("ustar")
+)
+
+const ustarOffset = 257
+
+// Returns the archive's compression based on its content, or failing that
+// on its name.
+func archiveCompression(archive string) compression {
+	magic := readMagic(archive)
+	if compression := sniffCompression(magic); compression != uncompressed ||
+		isUstar(magic) || cpio.IsMagic(magic) ||
+		bytes.HasPrefix(magic, ar.Magic) {
+		return compression
+	}
+	return suffixCompression(archive)
+}
+
+// Returns the compression indicated by the archive's name.
+func suffixCompression(archive string) compression {
+	switch {
+	case hasSuffixFold(archive, ".GZ") || hasSuffixFold(archive, ".TGZ"):
+		return gzipped
+	case hasSuffixFold(archive, ".BZ2"):
+		return bzipped
+	case hasSuffixFold(archive, ".XZ"):
+		return xzipped
+	case hasSuffixFold(archive, ".ZST") || hasSuffixFold(archive, ".TZST"):
+		return zstded
+	case hasSuffixFold(archive, ".LZ4") || hasSuffixFold(archive, ".TLZ4"):
+		return lz4ed
+	case hasSuffixFold(archive, ".BR") || hasSuffixFold(archive, ".TBR"):
+		return brotlied
+	case hasSuffixFold(archive, ".Z") || hasSuffixFold(archive, ".TAZ"):
+		return lzwed
+	}
+	return uncompressed
+}
+
+func sniffCompression(magic []byte) compression {
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzipped
+	case bytes.HasPrefix(magic, bzip2Magic):
+		return bzipped
+	case bytes.HasPrefix(magic, xzMagic):
+		return xzipped
+	case bytes.HasPrefix(magic, zstdMagic):
+		return zstded
+	case bytes.HasPrefix(magic, lz4Magic), bytes.HasPrefix(magic, lz4Legacy):
+		return lz4ed
+	case bytes.HasPrefix(magic, lzwMagic):
+		return lzwed
+	}
+	return uncompressed
+}
+
+func isUstar(magic []byte) bool {
+	return len(magic) >= ustarOffset+len(ustarMagic) &&
+		bytes.Equal(magic[ustarOffset:ustarOffset+len(ustarMagic)],
+			ustarMagic)
+}
+
+// Returns the first bytes of the archive—enough to identify its format—or
+// as many as it has (which could be none).
+func readMagic(archive string) []byte {
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	magic := make([]byte, ustarOffset+len(ustarMagic))
+	n, _ := io.ReadFull(file, magic)
+	return magic[:n]
+}
+
+// Like readMagic but for the archive's decompressed content.
+func readDecompressedMagic(archive string, compression compression) []byte {
+	reader, closer, err := decompressed(archive, compression)
+	if err != nil {
+		return nil
+	}
+	defer closer()
+	magic := make([]byte, ustarOffset+len(ustarMagic))
+	n, _ := io.ReadFull(reader, magic)
+	return magic[:n]
+}
+
+type closer func()
+
+const bufferSize = 1 << 16
+
+// Returns a reader of the archive's decompressed content and its closer.
+// On multicore machines xz files with more than one block are decompressed
+// in parallel (see newParallelXZReader()).
+func openDecompressed(archive string, compression compression) (io.Reader,
+	closer, error) {
+	if jobs := runtime.GOMAXPROCS(0); compression == xzipped && jobs > 1 {
+		if reader, closer := parallelXZ(archive, jobs); reader != nil {
+			return reader, closer, nil
+		}
+	}
+	reader, closer, err := decompressed(archive, compression)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", archive, err)
+	}
+	return reader, closer, nil
+}
+
+// Returns a parallel reader of the xz archive and its closer, or nil if
+// it must be read sequentially.
+func parallelXZ(archive string, jobs int) (io.Reader, closer) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, nil
+	}
+	reader := newParallelXZReader(file, jobs)
+	if reader == nil {
+		file.Close()
+		return nil, nil
+	}
+	return reader, func() {
+		reader.Close()
+		file.Close()
+	}
+}
+
+// Concatenated compressed streams (e.g., made by cat a.gz b.gz) are read
+// as one for gzip, bzip2, xz, zstd, and lz4 (whose decoders read frame
+// after frame); Brotli and compress (.Z) have no such framing.
+func decompressed(archive string, compression compression) (io.Reader,
+	closer, error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, nil, err
+	}
+	var reader io.Reader
+	var closer closer
+	// Decompressors do many small reads so buffer them.
+	buffered := bufio.NewReaderSize(file, bufferSize)
+	switch compression {
+	case gzipped:
+		ufile, err := gzip.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		ufile.Multistream(true) // the default, made explicit
+		closer = func() {
+			ufile.Close()
+			file.Close()
+		}
+		reader = ufile
+	case bzipped:
+		reader = bzip2.NewReader(buffered)
+	case xzipped:
+		ufile, err := xz.ReaderConfig{SingleStream: false}.NewReader(
+			buffered)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		reader = ufile
+	case zstded: // zstd.Reader has no Close(); closing file is sufficient
+		reader = zstd.NewReader(buffered)
+	case lz4ed:
+		ufile, err := lz4.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		reader = ufile
+	case brotlied:
+		ufile, err := brotli.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		reader = ufile
+	case lzwed:
+		ufile, err := ncompress.NewReader(buffered)
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		reader = ufile
+	default:
+		reader = file
+	}
+	if closer == nil {
+		closer = func() { file.Close() }
+	}
+	return reader, closer, nil
+}
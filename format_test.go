@@ -0,0 +1,338 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The testdata/tree.* tarballs all hold tree/a.txt, tree/sub/b.txt, and
+// tree/sub/c.txt (empty), made by compressing tree.tar with each format's
+// reference tool; tree.cpio (newc) and tree.cpio.gz (odc) hold the same
+// files, converted from tree.tar by bsdtar; and the split.tar.* tarballs
+// hold tree.tar split after tree/a.txt with each part compressed
+// separately and then concatenated (e.g., by gzip -c p1; gzip -c p2), so
+// they must be read across the boundary between streams. (Folders' names
+// are compared without any trailing /.)
+var treeMembers = []string{"tree", "tree/a.txt", "tree/sub",
+	"tree/sub/b.txt", "tree/sub/c.txt"}
+
+func TestFixtureTarballs(t *testing.T) {
+	for _, archive := range []string{"tree.tar", "tree.tar.br",
+		"tree.tar.lz4", "tree.tar.Z", "tree.tar.zst", "tree.cpio",
+		"tree.cpio.gz", "split.tar.gz", "split.tar.bz2", "split.tar.xz",
+		"split.tar.zst", "split.tar.lz4"} {
+		t.Run(archive, func(t *testing.T) {
+			archive := filepath.Join("testdata", archive)
+			members, err := List(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			names := memberNames(members)
+			for i, name := range names {
+				names[i] = strings.TrimSuffix(name, "/")
+			}
+			if !equalStrs(names, treeMembers) {
+				t.Errorf("expected %q, got %q", treeMembers, names)
+			}
+			dest := t.TempDir()
+			if err := Unpack(archive, dest, quiet()); err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range map[string]string{
+				"a.txt":     strings.Repeat("alpha\n", 50),
+				"sub/b.txt": "beta\n", "sub/c.txt": ""} {
+				if got := readFile(t, filepath.Join(dest,
+					filepath.FromSlash(name))); got != want {
+					t.Errorf("%s: expected %q, got %q", name, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestFixtureCompressedFiles(t *testing.T) {
+	for _, test := range []struct {
+		archive string
+		name    string
+		content string
+	}{
+		{"hello.txt.br", "hello.txt", "hello brotli\n"},
+		{"hello.txt.lz4", "hello.txt", "hello lz4\n"},
+		{"hello.txt.Z", "hello.txt", "hello compress\n"},
+		{"hello.txt.zst", "hello.txt", "hello zstd\n"},
+	} {
+		t.Run(test.archive, func(t *testing.T) {
+			archive := filepath.Join("testdata", test.archive)
+			members, err := List(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := memberNames(members); !equalStrs(names,
+				[]string{test.name}) {
+				t.Errorf("expected %q, got %q", test.name, names)
+			}
+			dest := t.TempDir()
+			if err := Unpack(archive, dest, quiet()); err != nil {
+				t.Fatal(err)
+			}
+			if got := readFile(t, filepath.Join(dest,
+				test.name)); got != test.content {
+				t.Errorf("expected %q, got %q", test.content, got)
+			}
+		})
+	}
+}
+
+// A single compressed file's one member is filtered like any other, and
+// what is written is passed to Created (e.g., for --manifest).
+func TestUnpackCompressedFiltered(t *testing.T) {
+	archive := filepath.Join("testdata", "hello.txt.Z")
+	for _, test := range []struct {
+		name    string
+		options Options
+		want    []string
+	}{
+		{"all", Options{}, []string{"hello.txt"}},
+		{"included", Options{Includes: []string{"*.txt"}},
+			[]string{"hello.txt"}},
+		{"excluded", Options{Excludes: []string{"*.txt"}}, []string{}},
+		{"not included", Options{Includes: []string{"*.go"}}, []string{}},
+		{"member", Options{Members: []string{"hello.txt"}},
+			[]string{"hello.txt"}},
+		{"too old", Options{Since: time.Now().AddDate(100, 0, 0)},
+			[]string{}},
+		{"stripped", Options{StripComponents: 1}, []string{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dest := t.TempDir()
+			options := test.options
+			options.Stdout, options.Stderr = io.Discard, io.Discard
+			created := []string{}
+			options.Created = func(name string) {
+				created = append(created, filepath.Base(name))
+			}
+			if err := Unpack(archive, dest, options); err != nil {
+				t.Fatal(err)
+			}
+			if got := treePaths(t, dest); !equalStrs(got, test.want) {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+			if !equalStrs(created, test.want) {
+				t.Errorf("expected %q to be created, got %q", test.want,
+					created)
+			}
+		})
+	}
+}
+
+// A corrupt compressed file is skipped as a broken member with KeepBroken.
+func TestUnpackCompressedBroken(t *testing.T) {
+	dir := t.TempDir()
+	var data bytes.Buffer
+	compressor := gzip.NewWriter(&data)
+	_, _ = compressor.Write([]byte(strings.Repeat("hello\n", 1000)))
+	if err := compressor.Close(); err != nil {
+		t.Fatal(err)
+	}
+	archive := filepath.Join(dir, "hello.txt.gz")
+	if err := os.WriteFile(archive, data.Bytes()[:data.Len()/2],
+		0o644); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "out")
+	options := quiet()
+	options.KeepBroken = true
+	if err := Unpack(archive, dest, options); !errors.Is(err,
+		ErrBroken) {
+		t.Errorf("expected %v, got %v", ErrBroken, err)
+	}
+	if got := treePaths(t, dest); len(got) != 0 {
+		t.Errorf("expected nothing to be unpacked, got %q", got)
+	}
+}
+
+// Whole tarballs that have been concatenated (e.g., by cat a.tar.gz
+// b.tar.gz) yield only the first tarball's members, since its
+// end-of-archive marker ends the tarball.
+func TestConcatenatedTarballs(t *testing.T) {
+	dir := t.TempDir()
+	other := filepath.Join(dir, "other.tar")
+	writeTar(t, other, []tarEntry{{name: "tree/other.txt",
+		content: "other"}})
+	var data bytes.Buffer
+	for _, name := range []string{filepath.Join("testdata", "tree.tar"),
+		other} {
+		tarball, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		compressor := gzip.NewWriter(&data)
+		_, _ = compressor.Write(tarball)
+		if err := compressor.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	archive := filepath.Join(dir, "both.tar.gz")
+	if err := os.WriteFile(archive, data.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	members, err := List(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := memberNames(members)
+	for i, name := range names {
+		names[i] = strings.TrimSuffix(name, "/")
+	}
+	if !equalStrs(names, treeMembers) {
+		t.Errorf("expected %q, got %q", treeMembers, names)
+	}
+}
+
+func TestUnsupportedRar(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "x.rar")
+	if err := os.WriteFile(archive, []byte("Rar!\x1A\x07\x01\x00"),
+		0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := List(archive); !errors.Is(err, ErrUnsupported) {
+		t.Errorf("List: expected %v, got %v", ErrUnsupported, err)
+	}
+	dest := filepath.Join(dir, "out")
+	if err := Unpack(archive, dest, quiet()); !errors.Is(err,
+		ErrUnsupported) {
+		t.Errorf("Unpack: expected %v, got %v", ErrUnsupported, err)
+	}
+	if _, err := os.Stat(dest); !os.IsNotExist(err) {
+		t.Errorf("expected %s not to be created", dest)
+	}
+}
+
+func TestHasSuffixFold(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		suffix string
+		want   bool
+	}{
+		{"a.tar.gz", ".TAR.GZ", true},
+		{"a.TaR.Gz", ".TAR.GZ", true},
+		{"a.gz", ".TAR.GZ", false},
+		{"gz", ".GZ", false},
+		{"a.tar.gſ", ".TAR.GS", false}, // ſ uppercases to S
+		{"Résumé.zip", ".ZIP", true},
+		{"", ".ZIP", false},
+	} {
+		if got := hasSuffixFold(test.name, test.suffix); got != test.want {
+			t.Errorf("%q %q: expected %t, got %t", test.name, test.suffix,
+				test.want, got)
+		}
+	}
+}
+
+// The long-utf8 archives hold one file whose 200 character (428 byte)
+// path mixes Greek, Japanese, Cyrillic, and emoji, made by Python's
+// tarfile (in PAX and GNU formats) and zipfile (with the UTF-8 flag).
+func TestLongUTF8Names(t *testing.T) {
+	want := strings.Join([]string{"Ελληνικά-κείμενο", "日本語のフォルダ名前",
+		"Кириллица-и-ещё", "emoji-😀-🎉-ünïcödé", "日本語のフォルダ名前",
+		"Ελληνικά-κείμενο", "日本語のフォルダ名前", "Ελληνικά-κείμενο",
+		"日本語のフォルダ名前", "Ελληνικά-κείμενο", "日本語のフォルダ名前",
+		"Ελληνικά-κείμενο", "日本語のフォルダ名前", "Ελληνικά-κε.txt"}, "/")
+	for _, archive := range []string{"long-utf8-pax.tar.gz",
+		"long-utf8-gnu.tar.gz", "long-utf8.zip"} {
+		t.Run(archive, func(t *testing.T) {
+			archive := filepath.Join("testdata", archive)
+			members, err := List(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := memberNames(members); !equalStrs(names,
+				[]string{want}) {
+				t.Errorf("expected %q, got %q", want, names)
+			}
+			dest := t.TempDir()
+			if err := Unpack(archive, dest, quiet()); err != nil {
+				t.Fatal(err)
+			}
+			// The single top-level folder is the wrapper that's stripped.
+			name := want[strings.IndexByte(want, '/')+1:]
+			if got := readFile(t, filepath.Join(dest,
+				filepath.FromSlash(name))); got != "long utf-8\n" {
+				t.Errorf("expected %q, got %q", "long utf-8\n", got)
+			}
+		})
+	}
+}
+
+func TestIsTarball(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want bool
+	}{
+		{"a.tar", true},
+		{"a.TAR", true},
+		{"a.tgz", true},
+		{"a.TGZ", true},
+		{"a.tar.gz", true},
+		{"a.Tar.Gz", true},
+		{"a.tar.bz2", true},
+		{"a.tar.xz", true},
+		{"a.tar.zst", true},
+		{"a.tzst", true},
+		{"a.tar.lz4", true},
+		{"a.tlz4", true},
+		{"a.tar.br", true},
+		{"a.tbr", true},
+		{"a.tar.Z", true},
+		{"a.taz", true},
+		{"dir.tar/a.tar.gz", true},
+		{"photos.tar.backup.zip", false},
+		{"a.tar.gz.zip", false},
+		{"a.tar.gz.bak", false},
+		{"a.tar.7z", false},
+		{"a.gz", false},
+		{"a.tar.zip", false},
+		{"a.tarball", false},
+		{"a.star", false}, // not .tar but ends with "tar"
+		{"tar", false},
+		{"dir.tar/a.zip", false},
+		{"a.cpio.gz", false},
+	} {
+		if got := isTarball(test.name); got != test.want {
+			t.Errorf("%s: expected %t, got %t", test.name, test.want, got)
+		}
+	}
+}
+
+// Files that don't exist (or are empty) have no magic, so their kinds go
+// by their names alone.
+func TestArchiveKindByName(t *testing.T) {
+	dir := t.TempDir()
+	for _, test := range []struct {
+		name string
+		want kind
+	}{
+		{"photos.tar.backup.zip", zipKind},
+		{"a.tar.gz", tarKind},
+		{"a.TGZ", tarKind},
+		{"a.tar", tarKind},
+		{"a.7z", sevenZipKind},
+		{"a.tar.7z", sevenZipKind},
+	} {
+		if got := archiveKind(filepath.Join(dir,
+			test.name)); got != test.want {
+			t.Errorf("%s: expected %d, got %d", test.name, test.want, got)
+		}
+	}
+}
@@ -6,10 +6,10 @@ require (
 	github.com/mark-summerfield/clip v0.8.0
 	github.com/mark-summerfield/gong v0.9.2
 	github.com/ulikunitz/xz v0.5.11
+	golang.org/x/sys v0.4.0
 )
 
 require (
 	github.com/kopoli/go-terminal-size v0.0.0-20170219200355-5c97524c8b54 // indirect
 	github.com/mattn/go-isatty v0.0.17 // indirect
-	golang.org/x/sys v0.4.0 // indirect
 )
@@ -0,0 +1,197 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+// Package ar provides a reader for Unix ar archives (e.g., static
+// libraries and Debian .deb packages) in the common, GNU, and BSD
+// variants. Entries are returned as *tar.Header values so that ar
+// archives can be unpacked just like tarballs. The symbol tables that
+// ranlib adds aren't files so are skipped.
+package ar
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrFormat is returned for data that isn't a valid ar archive.
+var ErrFormat = errors.New("ar: not a valid ar archive")
+
+// Magic is the signature at the start of every ar archive.
+var Magic = []byte("!<arch>\n")
+
+const (
+	headerLen   = 60
+	bsdPrefix   = "#1/"
+	maxNameSize = 1 << 16
+)
+
+// Reader reads an ar archive's entries in order: call Next() to get each
+// entry's header and then Read() to read its data.
+type Reader struct {
+	reader    *bufio.Reader
+	started   bool   // true once the magic has been read
+	remaining int64  // bytes of the current entry's data not yet read
+	pad       int64  // padding after the current entry's data
+	names     []byte // GNU's table of long names (the "//" entry)
+	err       error  // sticky
+}
+
+// NewReader returns a Reader that reads the ar archive from reader.
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReaderSize(reader, 1<<16)}
+}
+
+// Next skips the rest of the current entry's data and returns the next
+// entry's header, or io.EOF at the end of the archive.
+func (me *Reader) Next() (*tar.Header, error) {
+	if me.err != nil {
+		return nil, me.err
+	}
+	if !me.started {
+		magic := make([]byte, len(Magic))
+		if _, err := io.ReadFull(me.reader, magic); err != nil ||
+			!bytes.Equal(magic, Magic) {
+			return nil, me.fail(ErrFormat)
+		}
+		me.started = true
+	}
+	for {
+		if _, err := io.CopyN(io.Discard, me.reader,
+			me.remaining+me.pad); err != nil {
+			return nil, me.fail(err)
+		}
+		me.remaining, me.pad = 0, 0
+		if _, err := me.reader.Peek(1); err == io.EOF {
+			me.err = io.EOF
+			return nil, io.EOF
+		}
+		header, err := me.readHeader()
+		if err != nil {
+			return nil, me.fail(err)
+		}
+		if header != nil {
+			return header, nil
+		}
+	}
+}
+
+// Read reads from the current entry's data, returning io.EOF at its end.
+func (me *Reader) Read(p []byte) (int, error) {
+	if me.err != nil {
+		return 0, me.err
+	}
+	if me.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > me.remaining {
+		p = p[:me.remaining]
+	}
+	n, err := me.reader.Read(p)
+	me.remaining -= int64(n)
+	if err != nil && (err != io.EOF || me.remaining > 0) {
+		return n, me.fail(err)
+	}
+	return n, nil
+}
+
+func (me *Reader) fail(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	me.err = err
+	return err
+}
+
+// Reads the next entry's header (and a BSD long name) leaving the reader
+// at the start of the entry's data, or returns nil for a symbol table or
+// GNU's long names table (which is kept in me.names).
+func (me *Reader) readHeader() (*tar.Header, error) {
+	raw := make([]byte, headerLen)
+	if _, err := io.ReadFull(me.reader, raw); err != nil {
+		return nil, err
+	}
+	if raw[58] != '`' || raw[59] != '\n' {
+		return nil, fmt.Errorf("%w: bad header", ErrFormat)
+	}
+	field := func(start, end int) string {
+		return strings.TrimRight(string(raw[start:end]), " ")
+	}
+	size, err := strconv.ParseInt(field(48, 58), 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("%w: bad size", ErrFormat)
+	}
+	me.remaining, me.pad = size, size%2
+	name := field(0, 16)
+	switch {
+	case name == "/" || name == "/SYM64/":
+		return nil, nil // GNU symbol table
+	case name == "//":
+		if size > maxNameSize {
+			return nil, fmt.Errorf("%w: names size %d", ErrFormat, size)
+		}
+		me.names = make([]byte, size)
+		if _, err := io.ReadFull(me.reader, me.names); err != nil {
+			return nil, err
+		}
+		me.remaining = 0
+		return nil, nil
+	case strings.HasPrefix(name, bsdPrefix):
+		nameSize, err := strconv.ParseInt(name[len(bsdPrefix):], 10, 64)
+		if err != nil || nameSize < 0 || nameSize > size ||
+			nameSize > maxNameSize {
+			return nil, fmt.Errorf("%w: bad name size", ErrFormat)
+		}
+		long := make([]byte, nameSize)
+		if _, err := io.ReadFull(me.reader, long); err != nil {
+			return nil, err
+		}
+		me.remaining -= nameSize
+		name = string(bytes.TrimRight(long, "\x00"))
+		if strings.HasPrefix(name, "__.SYMDEF") {
+			return nil, nil // BSD symbol table
+		}
+	case strings.HasPrefix(name, "/"):
+		if name, err = me.longName(name[1:]); err != nil {
+			return nil, err
+		}
+	case strings.HasPrefix(name, "__.SYMDEF"):
+		return nil, nil // BSD symbol table
+	default:
+		name = strings.TrimSuffix(name, "/") // GNU
+	}
+	if name == "" {
+		return nil, fmt.Errorf("%w: empty name", ErrFormat)
+	}
+	// Some archivers leave these fields blank
+	mtime, _ := strconv.ParseInt(field(16, 28), 10, 64)
+	uid, _ := strconv.Atoi(field(28, 34))
+	gid, _ := strconv.Atoi(field(34, 40))
+	mode, _ := strconv.ParseInt(field(40, 48), 8, 64)
+	if mode&0o777 == 0 {
+		mode = 0o644
+	}
+	return &tar.Header{Typeflag: tar.TypeReg, Name: name,
+		Size: me.remaining, Mode: mode & 0o7777, Uid: uid, Gid: gid,
+		ModTime: time.Unix(mtime, 0)}, nil
+}
+
+// Returns the GNU long name at the given offset in the long names table.
+func (me *Reader) longName(offset string) (string, error) {
+	start, err := strconv.Atoi(offset)
+	if err != nil || start < 0 || start >= len(me.names) {
+		return "", fmt.Errorf("%w: bad long name offset %q", ErrFormat,
+			offset)
+	}
+	name := me.names[start:]
+	if end := bytes.IndexByte(name, '\n'); end > -1 {
+		name = name[:end]
+	}
+	return strings.TrimSuffix(string(name), "/"), nil
+}
@@ -0,0 +1,667 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+// Package brotli provides a decompressor for the Brotli format (RFC 7932,
+// i.e., .br files).
+//
+// dictionary.bin is RFC 7932's static dictionary.
+package brotli
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"math/bits"
+)
+
+var (
+	ErrHeader  = errors.New("brotli: invalid header")
+	ErrCorrupt = errors.New("brotli: corrupt data")
+)
+
+const (
+	maxBlockCount      = 1 << 24 // for categories with one block type
+	literalAlphabet    = 256
+	commandAlphabet    = 704
+	blockCountAlphabet = 26
+)
+
+// Reader implements io.Reader to read a Brotli stream.
+type Reader struct {
+	bits       bitReader
+	windowSize int    // the maximum backward distance
+	window     []byte // recent output then the current meta-block's
+	dropped    int64  // the number of bytes output before window[0]
+	distances  [4]int // the last four distances, last first
+	pending    []byte // decoded bytes not yet returned by Read
+	last       bool   // if true the last meta-block has been decoded
+	err        error
+}
+
+// blockSwitch holds a category's (literal, command, or distance) block
+// types and the codes for switching between them.
+type blockSwitch struct {
+	types     int // the number of block types
+	kind      int // the current block type
+	previous  int // the previous block type
+	count     int // the number of symbols left in the current block
+	typeCode  prefixCode
+	countCode prefixCode
+}
+
+// NewReader returns a Reader that decompresses from r or an error if r
+// doesn't begin with a valid Brotli stream header.
+func NewReader(r io.Reader) (*Reader, error) {
+	me := &Reader{bits: bitReader{reader: bufio.NewReader(r)},
+		distances: [4]int{4, 11, 15, 16}}
+	if err := me.readWindowBits(); err != nil {
+		return nil, err
+	}
+	return me, nil
+}
+
+// Read reads up to len(p) decompressed bytes into p.
+func (me *Reader) Read(p []byte) (int, error) {
+	for len(me.pending) == 0 && me.err == nil {
+		if me.last {
+			me.err = io.EOF
+		} else {
+			me.err = me.decodeMetaBlock()
+		}
+	}
+	if len(me.pending) > 0 {
+		n := copy(p, me.pending)
+		me.pending = me.pending[n:]
+		return n, nil
+	}
+	return 0, me.err
+}
+
+func (me *Reader) readWindowBits() error {
+	br := &me.bits
+	wbits := 16
+	if br.read(1) == 1 {
+		if n := br.read(3); n != 0 {
+			wbits = 17 + n
+		} else if n = br.read(3); n == 1 {
+			return ErrHeader // large windows aren't part of RFC 7932
+		} else if n != 0 {
+			wbits = 8 + n
+		} else {
+			wbits = 17
+		}
+	}
+	if br.err() != nil {
+		return ErrHeader
+	}
+	me.windowSize = 1<<wbits - 16
+	return nil
+}
+
+// Decodes the next meta-block into pending (which may be empty, e.g., for
+// metadata).
+func (me *Reader) decodeMetaBlock() error {
+	br := &me.bits
+	me.keepWindow()
+	if br.read(1) == 1 { // ISLAST
+		me.last = true
+		if br.read(1) == 1 { // ISLASTEMPTY
+			return me.finish()
+		}
+	}
+	nibbles := br.read(2) + 4
+	if nibbles == 7 {
+		if err := me.skipMetadata(); err != nil {
+			return err
+		}
+		if me.last {
+			return me.finish()
+		}
+		return nil
+	}
+	size := br.read(uint(4*nibbles)) + 1
+	if nibbles > 4 && (size-1)>>(4*(nibbles-1)) == 0 {
+		return ErrCorrupt // the last nibble must be nonzero
+	}
+	if !me.last && br.read(1) == 1 { // ISUNCOMPRESSED
+		if !br.align() {
+			return ErrCorrupt
+		}
+		start := len(me.window)
+		me.window = append(me.window, make([]byte, size)...)
+		if err := br.readBytes(me.window[start:]); err != nil {
+			return err
+		}
+		me.pending = me.window[start:]
+		return nil
+	}
+	if err := br.err(); err != nil {
+		return err
+	}
+	start := len(me.window)
+	if err := me.decodeCompressed(start + size); err != nil {
+		return err
+	}
+	me.pending = me.window[start:]
+	if me.last {
+		return me.finish()
+	}
+	return nil
+}
+
+// Discards all but the last windowSize bytes of output once the window
+// has grown to twice that.
+func (me *Reader) keepWindow() {
+	if len(me.window) > 2*me.windowSize {
+		n := copy(me.window, me.window[len(me.window)-me.windowSize:])
+		me.dropped += int64(len(me.window) - n)
+		me.window = me.window[:n]
+	}
+}
+
+func (me *Reader) skipMetadata() error {
+	br := &me.bits
+	if br.read(1) != 0 { // reserved
+		return ErrCorrupt
+	}
+	nbytes := br.read(2)
+	size := 0
+	for i := 0; i < nbytes; i++ {
+		b := br.read(8)
+		if i == nbytes-1 && nbytes > 1 && b == 0 {
+			return ErrCorrupt
+		}
+		size |= b << (8 * i)
+	}
+	if nbytes > 0 {
+		size++
+	}
+	if !br.align() {
+		return ErrCorrupt
+	}
+	return br.skipBytes(size)
+}
+
+// Checks that the last meta-block is followed only by zero padding bits.
+func (me *Reader) finish() error {
+	if !me.bits.align() {
+		return ErrCorrupt
+	}
+	return me.bits.err()
+}
+
+// Decodes a compressed meta-block's header and then its commands until the
+// window ends at end.
+func (me *Reader) decodeCompressed(end int) error {
+	br := &me.bits
+	var literals, commands, distances blockSwitch
+	for _, category := range []*blockSwitch{&literals, &commands,
+		&distances} {
+		if err := me.readBlockSwitch(category); err != nil {
+			return err
+		}
+	}
+	postfix := uint(br.read(2))
+	direct := br.read(4) << postfix
+	modes := make([]uint8, literals.types)
+	for i := range modes {
+		modes[i] = uint8(br.read(2))
+	}
+	literalMap, literalTrees, err := me.readContextMap(64 * literals.types)
+	if err != nil {
+		return err
+	}
+	distanceMap, distanceTrees, err := me.readContextMap(4 *
+		distances.types)
+	if err != nil {
+		return err
+	}
+	literalCodes, err := me.readPrefixCodes(literalTrees,
+		literalAlphabet)
+	if err != nil {
+		return err
+	}
+	commandCodes, err := me.readPrefixCodes(commands.types,
+		commandAlphabet)
+	if err != nil {
+		return err
+	}
+	distanceCodes, err := me.readPrefixCodes(distanceTrees,
+		16+direct+48<<postfix)
+	if err != nil {
+		return err
+	}
+	for len(me.window) < end {
+		if err := br.err(); err != nil {
+			return err
+		}
+		if commands.count == 0 {
+			me.switchBlock(&commands)
+		}
+		commands.count--
+		insert, copyLength, implicit := me.readCommand(
+			commandCodes[commands.kind].decode(br))
+		if len(me.window)+insert > end {
+			return ErrCorrupt
+		}
+		for ; insert > 0; insert-- {
+			if literals.count == 0 {
+				me.switchBlock(&literals)
+			}
+			literals.count--
+			context := literalContext(modes[literals.kind], me.window)
+			code := &literalCodes[literalMap[64*literals.kind+context]]
+			me.window = append(me.window, byte(code.decode(br)))
+		}
+		if len(me.window) == end {
+			break // the copy length is unused
+		}
+		code := 0
+		if !implicit {
+			if distances.count == 0 {
+				me.switchBlock(&distances)
+			}
+			distances.count--
+			context := 3
+			if copyLength <= 4 {
+				context = copyLength - 2
+			}
+			code = distanceCodes[distanceMap[4*distances.kind+
+				context]].decode(br)
+		}
+		distance, ok := me.distance(code, postfix, direct)
+		if !ok {
+			return ErrCorrupt
+		}
+		if err := me.copyMatch(distance, code, copyLength,
+			end); err != nil {
+			return err
+		}
+	}
+	return br.err()
+}
+
+// Copies copyLength bytes from distance bytes back or, if distance is
+// beyond the window, the dictionary word it refers to.
+func (me *Reader) copyMatch(distance, code, copyLength, end int) error {
+	maxDistance := me.windowSize
+	if total := me.dropped + int64(len(me.window)); total <
+		int64(maxDistance) {
+		maxDistance = int(total)
+	}
+	if distance > maxDistance {
+		if copyLength < 4 || copyLength >= len(sizeBits) {
+			return ErrCorrupt
+		}
+		id := distance - maxDistance - 1
+		nbits := sizeBits[copyLength]
+		index := id & (1<<nbits - 1)
+		kind := id >> nbits
+		if kind >= len(transforms) {
+			return ErrCorrupt
+		}
+		offset := offsets[copyLength] + index*copyLength
+		me.window = transforms[kind].apply(me.window,
+			dictionary[offset:offset+copyLength])
+		if len(me.window) > end {
+			return ErrCorrupt
+		}
+		return nil
+	}
+	if code != 0 {
+		copy(me.distances[1:], me.distances[:3])
+		me.distances[0] = distance
+	}
+	if len(me.window)+copyLength > end {
+		return ErrCorrupt
+	}
+	start := len(me.window) - distance
+	for copyLength > 0 { // the copy may overlap what it appends
+		n := copyLength
+		if n > distance {
+			n = distance
+		}
+		me.window = append(me.window, me.window[start:start+n]...)
+		start += n
+		copyLength -= n
+	}
+	return nil
+}
+
+// Returns the distance for the given distance code (reading its extra
+// bits if any) and true, or false if the distance is invalid.
+func (me *Reader) distance(code int, postfix uint, direct int) (int,
+	bool) {
+	switch {
+	case code < 4:
+		return me.distances[code], true
+	case code < 16:
+		base := me.distances[0]
+		if code >= 10 {
+			base = me.distances[1]
+		}
+		distance := base + shortCodeOffsets[code-4]
+		return distance, distance > 0
+	case code < 16+direct:
+		return code - 15, true
+	}
+	code -= direct + 16
+	nbits := uint(1 + code>>(postfix+1))
+	offset := (2+(code>>postfix)&1)<<nbits - 4
+	extra := me.bits.read(nbits)
+	return (offset+extra)<<postfix + code&(1<<postfix-1) + direct + 1, true
+}
+
+var shortCodeOffsets = [12]int{-1, 1, -2, 2, -3, 3, -1, 1, -2, 2, -3, 3}
+
+// Returns the insert and copy lengths for the given insert-and-copy
+// command (reading their extra bits), and true if the command implicitly
+// reuses the last distance.
+func (me *Reader) readCommand(command int) (int, int, bool) {
+	cell := commandCells[command>>6]
+	insert := insertLengths[cell.insert+command>>3&7]
+	copyLength := copyLengths[cell.copy+command&7]
+	return insert.base + me.bits.read(insert.extra),
+		copyLength.base + me.bits.read(copyLength.extra), command < 128
+}
+
+var commandCells = [11]struct{ insert, copy int }{{0, 0}, {0, 8}, {0, 0},
+	{0, 8}, {8, 0}, {8, 8}, {0, 16}, {16, 0}, {8, 16}, {16, 8}, {16, 16}}
+
+type lengthCode struct {
+	base  int
+	extra uint
+}
+
+var insertLengths = [24]lengthCode{{0, 0}, {1, 0}, {2, 0}, {3, 0}, {4, 0},
+	{5, 0}, {6, 1}, {8, 1}, {10, 2}, {14, 2}, {18, 3}, {26, 3}, {34, 4},
+	{50, 4}, {66, 5}, {98, 5}, {130, 6}, {194, 7}, {322, 8}, {578, 9},
+	{1090, 10}, {2114, 12}, {6210, 14}, {22594, 24}}
+
+var copyLengths = [24]lengthCode{{2, 0}, {3, 0}, {4, 0}, {5, 0}, {6, 0},
+	{7, 0}, {8, 0}, {9, 0}, {10, 1}, {12, 1}, {14, 2}, {18, 2}, {22, 3},
+	{30, 3}, {38, 4}, {54, 4}, {70, 5}, {102, 5}, {134, 6}, {198, 7},
+	{326, 8}, {582, 9}, {1094, 10}, {2118, 24}}
+
+var blockCountCodes = [blockCountAlphabet]lengthCode{{1, 2}, {5, 2}, {9, 2},
+	{13, 2}, {17, 3}, {25, 3}, {33, 3}, {41, 3}, {49, 4}, {65, 4}, {81, 4},
+	{97, 4}, {113, 5}, {145, 5}, {177, 5}, {209, 5}, {241, 6}, {305, 6},
+	{369, 7}, {497, 8}, {753, 9}, {1265, 10}, {2289, 11}, {4337, 12},
+	{8433, 13}, {16625, 24}}
+
+// Returns the literal context ID for the given context mode based on the
+// last two bytes of output.
+func literalContext(mode uint8, window []byte) int {
+	var p1, p2 byte
+	if n := len(window); n > 1 {
+		p1, p2 = window[n-1], window[n-2]
+	} else if n == 1 {
+		p1 = window[0]
+	}
+	switch mode {
+	case 0: // LSB6
+		return int(p1 & 0x3F)
+	case 1: // MSB6
+		return int(p1 >> 2)
+	case 2: // UTF8
+		return int(utf8Lut0[p1] | utf8Lut1[p2])
+	}
+	return int(signedLut[p1]<<3 | signedLut[p2]) // signed
+}
+
+func (me *Reader) readBlockSwitch(category *blockSwitch) error {
+	category.types = me.readCount()
+	category.kind, category.previous = 0, 1
+	category.count = maxBlockCount
+	if category.types > 1 {
+		if err := me.readPrefixCode(&category.typeCode,
+			category.types+2); err != nil {
+			return err
+		}
+		if err := me.readPrefixCode(&category.countCode,
+			blockCountAlphabet); err != nil {
+			return err
+		}
+		category.count = me.readBlockCount(category)
+	}
+	return me.bits.err()
+}
+
+func (me *Reader) switchBlock(category *blockSwitch) {
+	kind := category.typeCode.decode(&me.bits)
+	switch kind {
+	case 0:
+		kind = category.previous
+	case 1:
+		kind = category.kind + 1
+	default:
+		kind -= 2
+	}
+	if kind >= category.types {
+		kind -= category.types
+	}
+	category.previous, category.kind = category.kind, kind
+	category.count = me.readBlockCount(category)
+}
+
+func (me *Reader) readBlockCount(category *blockSwitch) int {
+	code := blockCountCodes[category.countCode.decode(&me.bits)]
+	return code.base + me.bits.read(code.extra)
+}
+
+// Reads a number from 1 to 256 (e.g., NBLTYPES or NTREES).
+func (me *Reader) readCount() int {
+	if me.bits.read(1) == 0 {
+		return 1
+	}
+	n := uint(me.bits.read(3))
+	return 1<<n + me.bits.read(n) + 1
+}
+
+// Reads a context map of the given size and returns it and the number of
+// prefix codes (trees) it refers to.
+func (me *Reader) readContextMap(size int) ([]uint8, int, error) {
+	br := &me.bits
+	trees := me.readCount()
+	contextMap := make([]uint8, size)
+	if trees < 2 {
+		return contextMap, trees, br.err()
+	}
+	runMax := 0
+	if br.read(1) == 1 {
+		runMax = br.read(4) + 1
+	}
+	var code prefixCode
+	if err := me.readPrefixCode(&code, trees+runMax); err != nil {
+		return nil, 0, err
+	}
+	for i := 0; i < size; {
+		if err := br.err(); err != nil {
+			return nil, 0, err
+		}
+		symbol := code.decode(br)
+		switch {
+		case symbol == 0:
+			i++
+		case symbol <= runMax: // a run of zeros
+			i += 1<<symbol + br.read(uint(symbol))
+			if i > size {
+				return nil, 0, ErrCorrupt
+			}
+		default:
+			contextMap[i] = uint8(symbol - runMax)
+			i++
+		}
+	}
+	if br.read(1) == 1 { // inverse move-to-front transform
+		var mtf [256]uint8
+		for i := range mtf {
+			mtf[i] = uint8(i)
+		}
+		for i, index := range contextMap {
+			value := mtf[index]
+			contextMap[i] = value
+			copy(mtf[1:index+1], mtf[:index])
+			mtf[0] = value
+		}
+	}
+	return contextMap, trees, br.err()
+}
+
+func (me *Reader) readPrefixCodes(count, alphabetSize int) ([]prefixCode,
+	error) {
+	codes := make([]prefixCode, count)
+	for i := range codes {
+		if err := me.readPrefixCode(&codes[i], alphabetSize); err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+func (me *Reader) readPrefixCode(code *prefixCode, alphabetSize int) error {
+	br := &me.bits
+	skip := br.read(2)
+	if skip == 1 {
+		return me.readSimplePrefixCode(code, alphabetSize)
+	}
+	var lengths [len(codeLengthOrder)]uint8
+	space, count := 32, 0
+	for _, symbol := range codeLengthOrder[skip:] {
+		br.fill(4)
+		peeked := br.bits & 0xF
+		br.drop(uint(codeLengthBits[peeked]))
+		length := codeLengthValues[peeked]
+		lengths[symbol] = length
+		if length != 0 {
+			space -= 32 >> length
+			count++
+			if space <= 0 {
+				break
+			}
+		}
+	}
+	if count != 1 && space != 0 {
+		return ErrCorrupt
+	}
+	var lengthCode prefixCode
+	if count == 1 {
+		for symbol, length := range lengths {
+			if length != 0 {
+				lengthCode.setSingle(symbol)
+			}
+		}
+	} else if err := lengthCode.build(lengths[:]); err != nil {
+		return err
+	}
+	if err := br.err(); err != nil {
+		return err
+	}
+	return me.readCodeLengths(code, &lengthCode, alphabetSize)
+}
+
+var codeLengthOrder = [18]uint8{1, 2, 3, 4, 0, 5, 17, 6, 16, 7, 8, 9, 10,
+	11, 12, 13, 14, 15}
+
+// The code for code lengths (indexed by the next 4 bits).
+var (
+	codeLengthBits   = [16]uint8{2, 2, 2, 3, 2, 2, 2, 4, 2, 2, 2, 3, 2, 2, 2, 4}
+	codeLengthValues = [16]uint8{0, 4, 3, 2, 0, 4, 3, 1, 0, 4, 3, 2, 0, 4, 3, 5}
+)
+
+// Reads the code lengths of a complex prefix code (RFC 7932 section 3.5).
+func (me *Reader) readCodeLengths(code, lengthCode *prefixCode,
+	alphabetSize int) error {
+	br := &me.bits
+	lengths := make([]uint8, alphabetSize)
+	symbol, previous := 0, uint8(8)
+	repeat, repeatLength := 0, uint8(0)
+	space := 1 << maxLength
+	for symbol < alphabetSize && space > 0 {
+		if err := br.err(); err != nil {
+			return err
+		}
+		length := uint8(lengthCode.decode(br))
+		if length < 16 {
+			repeat = 0
+			lengths[symbol] = length
+			symbol++
+			if length != 0 {
+				previous = length
+				space -= 1 << maxLength >> length
+			}
+			continue
+		}
+		extra, newLength := uint(2), previous
+		if length == 17 {
+			extra, newLength = 3, 0
+		}
+		if repeatLength != newLength {
+			repeat, repeatLength = 0, newLength
+		}
+		old := repeat
+		if repeat > 0 {
+			repeat = (repeat - 2) << extra
+		}
+		repeat += br.read(extra) + 3
+		delta := repeat - old
+		if symbol+delta > alphabetSize {
+			return ErrCorrupt
+		}
+		for end := symbol + delta; symbol < end; symbol++ {
+			lengths[symbol] = repeatLength
+		}
+		if repeatLength != 0 {
+			space -= delta << (maxLength - repeatLength)
+		}
+	}
+	if space != 0 {
+		return ErrCorrupt
+	}
+	return code.build(lengths)
+}
+
+// Reads a simple prefix code of 1 to 4 symbols (RFC 7932 section 3.4).
+func (me *Reader) readSimplePrefixCode(code *prefixCode,
+	alphabetSize int) error {
+	br := &me.bits
+	count := br.read(2) + 1
+	nbits := uint(bits.Len(uint(alphabetSize - 1)))
+	var symbols [4]int
+	for i := 0; i < count; i++ {
+		symbols[i] = br.read(nbits)
+		if symbols[i] >= alphabetSize {
+			return ErrCorrupt
+		}
+		for j := 0; j < i; j++ {
+			if symbols[j] == symbols[i] {
+				return ErrCorrupt
+			}
+		}
+	}
+	if err := br.err(); err != nil {
+		return err
+	}
+	if count == 1 {
+		code.setSingle(symbols[0])
+		return nil
+	}
+	lengths := make([]uint8, alphabetSize)
+	switch count {
+	case 2:
+		lengths[symbols[0]], lengths[symbols[1]] = 1, 1
+	case 3:
+		lengths[symbols[0]], lengths[symbols[1]], lengths[symbols[2]] = 1, 2,
+			2
+	case 4:
+		if br.read(1) == 0 {
+			for _, symbol := range symbols {
+				lengths[symbol] = 2
+			}
+		} else {
+			lengths[symbols[0]], lengths[symbols[1]] = 1, 2
+			lengths[symbols[2]], lengths[symbols[3]] = 3, 3
+		}
+	}
+	return code.build(lengths)
+}
@@ -0,0 +1,106 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package brotli
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+)
+
+// The testdata files were compressed by the reference encoder (Google's
+// C library, via brotlicffi) with the quality (q) and window (w) given in
+// their names; each name.br decompresses to name.
+var fixtures = []string{"empty", "x", "zeros", "text-q0", "text-q5",
+	"text-q11", "text-w10", "words-q11", "random", "mixed", "utf8-text"}
+
+func readFixture(t *testing.T, name string) ([]byte, []byte) {
+	t.Helper()
+	want, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressed, err := os.ReadFile(filepath.Join("testdata", name+".br"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return want, compressed
+}
+
+func decompress(compressed []byte, oneByte bool) ([]byte, error) {
+	var source io.Reader = bytes.NewReader(compressed)
+	if oneByte {
+		source = iotest.OneByteReader(source)
+	}
+	reader, err := NewReader(source)
+	if err != nil {
+		return nil, err
+	}
+	if oneByte {
+		return io.ReadAll(iotest.OneByteReader(reader))
+	}
+	return io.ReadAll(reader)
+}
+
+func TestFixtures(t *testing.T) {
+	for _, name := range fixtures {
+		want, compressed := readFixture(t, name)
+		for _, oneByte := range []bool{false, true} {
+			got, err := decompress(compressed, oneByte)
+			if err != nil {
+				t.Errorf("%s: %s", name, err)
+			} else if !bytes.Equal(got, want) {
+				t.Errorf("%s: got %d bytes, expected %d", name, len(got),
+					len(want))
+			}
+		}
+	}
+}
+
+func TestVectors(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		compressed []byte
+		want       string
+	}{
+		{"empty, 16-bit window", []byte{0x06}, ""},
+		{"empty, 22-bit window", []byte{0x3B}, ""},
+		{"one byte", []byte{0x0B, 0x00, 0x80, 0x58, 0x03}, "X"},
+	} {
+		got, err := decompress(test.compressed, false)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if string(got) != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	for _, name := range []string{"text-q11", "words-q11", "mixed"} {
+		_, compressed := readFixture(t, name)
+		for _, size := range []int{1, len(compressed) / 2,
+			len(compressed) - 1} {
+			if _, err := decompress(compressed[:size], false); err == nil {
+				t.Errorf("%s truncated to %d bytes: expected an error",
+					name, size)
+			}
+		}
+		// Brotli has no checksum so not all corruption can be detected,
+		// but it mustn't cause a panic or an unexpected error.
+		corrupt := append([]byte{}, compressed...)
+		for i := 10; i < len(corrupt); i += 97 {
+			corrupt[i] ^= 0xA5
+		}
+		if _, err := decompress(corrupt, false); err != nil &&
+			!errors.Is(err, ErrCorrupt) &&
+			!errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: unexpected error %s", name, err)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package brotli
+
+import _ "embed"
+
+// dictionary is RFC 7932's static dictionary (Appendix A) of words of 4 to
+// 24 bytes, grouped by length.
+//
+//go:embed dictionary.bin
+var dictionary []byte
+
+// sizeBits are the log2 of the number of dictionary words of each length.
+var sizeBits = [25]uint8{0, 0, 0, 0, 10, 10, 11, 11, 10, 10, 10, 10, 10, 9,
+	9, 8, 7, 7, 8, 7, 7, 6, 6, 5, 5}
+
+// offsets are where the dictionary words of each length start.
+var offsets [25]int
+
+func init() {
+	for length := 4; length < len(offsets)-1; length++ {
+		offsets[length+1] = offsets[length] + length<<sizeBits[length]
+	}
+}
+
+const (
+	identity = iota
+	omitLast1
+	omitLast2
+	omitLast3
+	omitLast4
+	omitLast5
+	omitLast6
+	omitLast7
+	omitLast8
+	omitLast9
+	upperFirst
+	upperAll
+	omitFirst1
+	omitFirst2
+	omitFirst3
+	omitFirst4
+	omitFirst5
+	omitFirst6
+	omitFirst7
+	omitFirst8
+	omitFirst9
+)
+
+// transform is one of the ways a dictionary word may be changed when
+// referred to (RFC 7932 Appendix B).
+type transform struct {
+	prefix string
+	kind   int
+	suffix string
+}
+
+var transforms = []transform{
+	{"", identity, ""}, {"", identity, " "}, {" ", identity, " "},
+	{"", omitFirst1, ""}, {"", upperFirst, " "}, {"", identity, " the "},
+	{" ", identity, ""}, {"s ", identity, " "}, {"", identity, " of "},
+	{"", upperFirst, ""}, {"", identity, " and "}, {"", omitFirst2, ""},
+	{"", omitLast1, ""}, {", ", identity, " "}, {"", identity, ", "},
+	{" ", upperFirst, " "}, {"", identity, " in "}, {"", identity, " to "},
+	{"e ", identity, " "}, {"", identity, "\""}, {"", identity, "."},
+	{"", identity, "\">"}, {"", identity, "\n"}, {"", omitLast3, ""},
+	{"", identity, "]"}, {"", identity, " for "}, {"", omitFirst3, ""},
+	{"", omitLast2, ""}, {"", identity, " a "}, {"", identity, " that "},
+	{" ", upperFirst, ""}, {"", identity, ". "}, {".", identity, ""},
+	{" ", identity, ", "}, {"", omitFirst4, ""}, {"", identity, " with "},
+	{"", identity, "'"}, {"", identity, " from "}, {"", identity, " by "},
+	{"", omitFirst5, ""}, {"", omitFirst6, ""}, {" the ", identity, ""},
+	{"", omitLast4, ""}, {"", identity, ". The "}, {"", upperAll, ""},
+	{"", identity, " on "}, {"", identity, " as "}, {"", identity, " is "},
+	{"", omitLast7, ""}, {"", omitLast1, "ing "}, {"", identity, "\n\t"},
+	{"", identity, ":"}, {" ", identity, ". "}, {"", identity, "ed "},
+	{"", omitFirst9, ""}, {"", omitFirst7, ""}, {"", omitLast6, ""},
+	{"", identity, "("}, {"", upperFirst, ", "}, {"", omitLast8, ""},
+	{"", identity, " at "}, {"", identity, "ly "},
+	{" the ", identity, " of "}, {"", omitLast5, ""}, {"", omitLast9, ""},
+	{" ", upperFirst, ", "}, {"", upperFirst, "\""}, {".", identity, "("},
+	{"", upperAll, " "}, {"", upperFirst, "\">"}, {"", identity, "=\""},
+	{" ", identity, "."}, {".com/", identity, ""},
+	{" the ", identity, " of the "}, {"", upperFirst, "'"},
+	{"", identity, ". This "}, {"", identity, ","}, {".", identity, " "},
+	{"", upperFirst, "("}, {"", upperFirst, "."}, {"", identity, " not "},
+	{" ", identity, "=\""}, {"", identity, "er "}, {" ", upperAll, " "},
+	{"", identity, "al "}, {" ", upperAll, ""}, {"", identity, "='"},
+	{"", upperAll, "\""}, {"", upperFirst, ". "}, {" ", identity, "("},
+	{"", identity, "ful "}, {" ", upperFirst, ". "},
+	{"", identity, "ive "}, {"", identity, "less "}, {"", upperAll, "'"},
+	{"", identity, "est "}, {" ", upperFirst, "."}, {"", upperAll, "\">"},
+	{" ", identity, "='"}, {"", upperFirst, ","}, {"", identity, "ize "},
+	{"", upperAll, "."}, {"\u00a0", identity, ""},
+	{" ", identity, ","}, {"", upperFirst, "=\""}, {"", upperAll, "=\""},
+	{"", identity, "ous "}, {"", upperAll, ", "}, {"", upperFirst, "='"},
+	{" ", upperFirst, ","}, {" ", upperAll, "=\""}, {" ", upperAll, ", "},
+	{"", upperAll, ","}, {"", upperAll, "("}, {"", upperAll, ". "},
+	{" ", upperAll, "."}, {"", upperAll, "='"}, {" ", upperAll, ". "},
+	{" ", upperFirst, "=\""}, {" ", upperAll, "='"},
+	{" ", upperFirst, "='"},
+}
+
+// Appends the transformed word to dst and returns dst.
+func (me transform) apply(dst, word []byte) []byte {
+	dst = append(dst, me.prefix...)
+	switch {
+	case me.kind >= omitFirst1:
+		n := me.kind - omitFirst1 + 1
+		if n > len(word) {
+			n = len(word)
+		}
+		word = word[n:]
+	case me.kind >= omitLast1 && me.kind <= omitLast9:
+		n := me.kind - omitLast1 + 1
+		if n > len(word) {
+			n = len(word)
+		}
+		word = word[:len(word)-n]
+	}
+	start := len(dst)
+	dst = append(dst, word...)
+	if len(word) > 0 {
+		switch me.kind {
+		case upperFirst:
+			toUpper(dst[start:])
+		case upperAll:
+			for i := start; i < len(dst); {
+				i += toUpper(dst[i:])
+			}
+		}
+	}
+	return append(dst, me.suffix...)
+}
+
+// Uppercases the first (UTF-8) character of p the way RFC 7932 specifies
+// (which is only correct for ASCII and some Latin letters) and returns the
+// character's length.
+func toUpper(p []byte) int {
+	switch {
+	case p[0] < 0xC0:
+		if 'a' <= p[0] && p[0] <= 'z' {
+			p[0] ^= 32
+		}
+		return 1
+	case p[0] < 0xE0:
+		if len(p) > 1 {
+			p[1] ^= 32
+		}
+		return 2
+	}
+	if len(p) > 2 {
+		p[2] ^= 5
+	}
+	return 3
+}
+
+// Literal context lookup tables (RFC 7932 section 7.1).
+
+var utf8Lut0 = [256]uint8{
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 4, 4, 0, 0, 4, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	8, 12, 16, 12, 12, 20, 12, 16, 24, 28, 12, 12, 32, 12, 36, 12,
+	44, 44, 44, 44, 44, 44, 44, 44, 44, 44, 32, 32, 24, 40, 28, 12,
+	12, 48, 52, 52, 52, 48, 52, 52, 52, 48, 52, 52, 52, 52, 52, 48,
+	52, 52, 52, 52, 52, 48, 52, 52, 52, 52, 52, 24, 12, 28, 12, 12,
+	12, 56, 60, 60, 60, 56, 60, 60, 60, 56, 60, 60, 60, 60, 60, 56,
+	60, 60, 60, 60, 60, 56, 60, 60, 60, 60, 60, 24, 12, 28, 12, 0,
+	0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1,
+	0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1,
+	0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1,
+	0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1, 0, 1,
+	2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3,
+	2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3,
+	2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3,
+	2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3, 2, 3,
+}
+
+var utf8Lut1 = [256]uint8{
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+	2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1,
+	1, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2,
+	2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1,
+	1, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 1, 1, 1, 1, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+	2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2,
+	2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2,
+}
+
+var signedLut = [256]uint8{
+	0, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+	2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2,
+	2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2,
+	2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4,
+	4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4,
+	4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4,
+	4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4, 4,
+	5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5,
+	5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5,
+	5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5, 5,
+	6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 6, 7,
+}
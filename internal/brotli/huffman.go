@@ -0,0 +1,194 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package brotli
+
+import (
+	"bufio"
+	"io"
+	"math/bits"
+)
+
+// bitReader reads bits least significant first. Once its input is
+// exhausted it supplies zero bits so that prefix codes can always be
+// peeked; consuming any of these is an error (see err()).
+type bitReader struct {
+	reader *bufio.Reader
+	bits   uint64
+	nbits  uint
+	fake   uint  // the number of zero bits supplied after the input's end
+	ioErr  error // the first input error
+}
+
+// Ensures that there are at least n (<= 56) bits available.
+func (me *bitReader) fill(n uint) {
+	for me.nbits < n {
+		b, err := me.reader.ReadByte()
+		if err != nil {
+			if me.ioErr == nil {
+				me.ioErr = err
+			}
+			me.fake += 8
+		}
+		me.bits |= uint64(b) << me.nbits
+		me.nbits += 8
+	}
+}
+
+func (me *bitReader) read(n uint) int {
+	me.fill(n)
+	value := int(me.bits & (1<<n - 1))
+	me.drop(n)
+	return value
+}
+
+func (me *bitReader) drop(n uint) {
+	me.bits >>= n
+	me.nbits -= n
+}
+
+// Skips to the next byte boundary and returns true if the skipped bits
+// were all zero (as they must be).
+func (me *bitReader) align() bool {
+	return me.read(me.nbits%8) == 0
+}
+
+// Reads len(p) bytes; must only be called at a byte boundary.
+func (me *bitReader) readBytes(p []byte) error {
+	i := 0
+	for ; me.nbits >= 8 && i < len(p); i++ {
+		p[i] = byte(me.read(8))
+	}
+	if err := me.err(); err != nil {
+		return err
+	}
+	if _, err := io.ReadFull(me.reader, p[i:]); err != nil {
+		return unexpected(err)
+	}
+	return nil
+}
+
+// Skips n bytes; must only be called at a byte boundary.
+func (me *bitReader) skipBytes(n int) error {
+	for ; me.nbits >= 8 && n > 0; n-- {
+		me.drop(8)
+	}
+	if err := me.err(); err != nil {
+		return err
+	}
+	if _, err := me.reader.Discard(n); err != nil {
+		return unexpected(err)
+	}
+	return nil
+}
+
+// Returns an error if bits beyond the end of the input have been consumed.
+func (me *bitReader) err() error {
+	if me.nbits < me.fake {
+		return unexpected(me.ioErr)
+	}
+	return nil
+}
+
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+const (
+	maxLength = 15 // the longest code
+	fastBits  = 8  // codes up to this long are decoded by table lookup
+)
+
+// prefixCode is a canonical prefix (Huffman) code.
+type prefixCode struct {
+	fast    [1 << fastBits]uint16 // symbol<<4 | length (0 if longer)
+	counts  [maxLength + 1]uint16 // the number of codes of each length
+	symbols []uint16              // ordered by code
+	single  bool                  // if true symbols[0] has a 0-bit code
+}
+
+// Sets the code from the given code lengths (one per symbol, 0 meaning
+// unused). Returns ErrCorrupt unless the code is complete.
+func (me *prefixCode) build(lengths []uint8) error {
+	me.single = false
+	me.counts = [maxLength + 1]uint16{}
+	for _, length := range lengths {
+		me.counts[length]++
+	}
+	me.counts[0] = 0
+	left := 1
+	var next [maxLength + 2]int // the first index (then code) per length
+	for length := 1; length <= maxLength; length++ {
+		left = left<<1 - int(me.counts[length])
+		if left < 0 {
+			return ErrCorrupt
+		}
+		next[length+1] = next[length] + int(me.counts[length])
+	}
+	if left != 0 {
+		return ErrCorrupt
+	}
+	me.symbols = make([]uint16, next[maxLength+1])
+	for symbol, length := range lengths {
+		if length != 0 {
+			me.symbols[next[length]] = uint16(symbol)
+			next[length]++
+		}
+	}
+	me.fast = [1 << fastBits]uint16{}
+	code := 0
+	for length := 1; length <= fastBits; length++ {
+		code = (code + int(me.counts[length-1])) << 1
+		next[length] = code
+	}
+	for symbol, length := range lengths {
+		if length == 0 || length > fastBits {
+			continue
+		}
+		code := next[length]
+		next[length]++
+		entry := uint16(symbol)<<4 | uint16(length)
+		reversed := int(bits.Reverse16(uint16(code)) >> (16 - length))
+		for i := reversed; i < len(me.fast); i += 1 << length {
+			me.fast[i] = entry
+		}
+	}
+	return nil
+}
+
+// Sets the code to have just the one symbol (which takes no bits).
+func (me *prefixCode) setSingle(symbol int) {
+	me.single = true
+	me.symbols = []uint16{uint16(symbol)}
+}
+
+// Reads and returns the next symbol.
+func (me *prefixCode) decode(br *bitReader) int {
+	if me.single {
+		return int(me.symbols[0])
+	}
+	br.fill(maxLength)
+	if entry := me.fast[br.bits&(1<<fastBits-1)]; entry != 0 {
+		br.drop(uint(entry & 0xF))
+		return int(entry >> 4)
+	}
+	// Slow path (as in zlib's puff.c) for codes longer than fastBits
+	code, first, index := 0, 0, 0
+	input := br.bits
+	for length := 1; length <= maxLength; length++ {
+		code |= int(input & 1)
+		input >>= 1
+		count := int(me.counts[length])
+		if code-count < first {
+			br.drop(uint(length))
+			return int(me.symbols[index+code-first])
+		}
+		index += count
+		first = (first + count) << 1
+		code <<= 1
+	}
+	return 0 // unreachable since build() only accepts complete codes
+}
@@ -0,0 +1,354 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+// Package cpio provides a reader for cpio archives in the "newc" (SVR4,
+// with or without a checksum) and "odc" (POSIX.1 portable ASCII) formats,
+// as used by Linux initramfs images and RPM payloads. Entries are returned
+// as *tar.Header values (with cpio's mode bits, which are the same as
+// tar's) so that cpio archives can be unpacked just like tarballs.
+//
+// In cpio archives hard links are entries with the same inode. In the odc
+// format the first such entry holds the data, but in the newc format only
+// the last one does, so the Reader holds back earlier empty entries until
+// the one with the data has been returned, and then returns them as
+// tar.TypeLink headers that link to it.
+package cpio
+
+import (
+	"archive/tar"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrFormat   = errors.New("cpio: not a valid cpio archive")
+	ErrChecksum = errors.New("cpio: checksum error")
+)
+
+const (
+	newcMagic    = "070701"
+	newcCRCMagic = "070702"
+	odcMagic     = "070707"
+	newcHeadLen  = 110
+	odcHeadLen   = 76
+	trailer      = "TRAILER!!!"
+	maxNameSize  = 1 << 16
+	maxLinkSize  = 1 << 16
+)
+
+// TypeSocket is the Typeflag given to sockets (which tar can't store).
+const TypeSocket = 's'
+
+// IsMagic returns true if magic begins with a newc or odc cpio signature.
+func IsMagic(magic []byte) bool {
+	if len(magic) < len(newcMagic) {
+		return false
+	}
+	switch string(magic[:len(newcMagic)]) {
+	case newcMagic, newcCRCMagic, odcMagic:
+		return true
+	}
+	return false
+}
+
+// Reader reads a cpio archive's entries in order: call Next() to get each
+// entry's header and then Read() to read its data.
+type Reader struct {
+	reader    *bufio.Reader
+	remaining int64 // bytes of the current entry's data not yet read
+	pad       int64 // padding after the current entry's data
+	check     bool  // if true compare sum with want at the end of the data
+	sum       uint32
+	want      uint32
+	links     map[inode]string   // the name of each linked inode's data
+	held      map[inode][]*entry // newc links waiting for their data
+	order     []inode            // the held inodes in archive order
+	queue     []*tar.Header      // headers to return before reading on
+	err       error              // sticky
+}
+
+// inode identifies the entries that are hard links to the same file.
+type inode struct {
+	devMajor, devMinor, ino int64
+}
+
+type entry struct {
+	header *tar.Header
+	key    inode
+	nlink  int64
+	newc   bool
+	check  bool // newc with a checksum (070702)
+	want   uint32
+}
+
+// NewReader returns a Reader that reads the cpio archive from reader.
+func NewReader(reader io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReaderSize(reader, 1<<16),
+		links: map[inode]string{}, held: map[inode][]*entry{}}
+}
+
+// Next skips the rest of the current entry's data and returns the next
+// entry's header, or io.EOF at the end of the archive.
+func (me *Reader) Next() (*tar.Header, error) {
+	if me.err != nil {
+		return nil, me.err
+	}
+	if _, err := io.CopyN(io.Discard, me.reader,
+		me.remaining+me.pad); err != nil {
+		return nil, me.fail(err)
+	}
+	me.remaining, me.pad, me.check = 0, 0, false
+	for len(me.queue) == 0 {
+		entry, err := me.readEntry()
+		if err != nil {
+			return nil, me.fail(err)
+		}
+		if entry == nil { // the trailer
+			me.releaseHeld()
+			if len(me.queue) == 0 {
+				me.err = io.EOF
+				return nil, io.EOF
+			}
+			break
+		}
+		me.add(entry)
+	}
+	header := me.queue[0]
+	me.queue = me.queue[1:]
+	return header, nil
+}
+
+// Adds the entry's header to the (empty) queue followed by the headers of
+// any held back links to it, or holds it back if it may be an empty newc
+// hard link whose data comes later. If the entry has data it is next to
+// be read.
+func (me *Reader) add(entry *entry) {
+	header := entry.header
+	if header.Typeflag == tar.TypeReg && entry.nlink > 1 {
+		if name, ok := me.links[entry.key]; ok && header.Size == 0 {
+			header.Typeflag, header.Linkname = tar.TypeLink, name
+		} else if header.Size == 0 && entry.newc {
+			if _, ok := me.held[entry.key]; !ok {
+				me.order = append(me.order, entry.key)
+			}
+			me.held[entry.key] = append(me.held[entry.key], entry)
+			return
+		} else if !ok {
+			me.links[entry.key] = header.Name
+		}
+	}
+	me.queue = append(me.queue, header)
+	if header.Typeflag == tar.TypeReg {
+		me.remaining = header.Size
+		me.check, me.sum, me.want = entry.check, 0, entry.want
+		for _, held := range me.held[entry.key] {
+			held.header.Typeflag = tar.TypeLink
+			held.header.Linkname = header.Name
+			me.queue = append(me.queue, held.header)
+		}
+		delete(me.held, entry.key)
+	}
+}
+
+// At the end of the archive, queues any held back links whose data never
+// came (i.e., that really are empty): the first as an empty file and the
+// others as links to it.
+func (me *Reader) releaseHeld() {
+	for _, key := range me.order {
+		entries, ok := me.held[key]
+		if !ok {
+			continue
+		}
+		first := entries[0].header
+		me.queue = append(me.queue, first)
+		for _, entry := range entries[1:] {
+			entry.header.Typeflag = tar.TypeLink
+			entry.header.Linkname = first.Name
+			me.queue = append(me.queue, entry.header)
+		}
+	}
+	me.held, me.order = map[inode][]*entry{}, nil
+}
+
+// Read reads from the current entry's data, returning io.EOF at its end
+// (or ErrChecksum if the newc checksum doesn't match).
+func (me *Reader) Read(p []byte) (int, error) {
+	if me.err != nil {
+		return 0, me.err
+	}
+	if me.remaining == 0 {
+		return 0, io.EOF
+	}
+	if int64(len(p)) > me.remaining {
+		p = p[:me.remaining]
+	}
+	n, err := me.reader.Read(p)
+	me.remaining -= int64(n)
+	if me.check {
+		for _, b := range p[:n] {
+			me.sum += uint32(b)
+		}
+	}
+	if err != nil && (err != io.EOF || me.remaining > 0) {
+		return n, me.fail(err)
+	}
+	if me.remaining == 0 && me.check && me.sum != me.want {
+		return n, ErrChecksum
+	}
+	return n, nil
+}
+
+func (me *Reader) fail(err error) error {
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	me.err = err
+	return err
+}
+
+// Reads the next entry's header and name (and a symlink's target) leaving
+// the reader at the start of the entry's data (whose padding is me.pad),
+// or returns nil at the trailer.
+func (me *Reader) readEntry() (*entry, error) {
+	magic, err := me.reader.Peek(len(newcMagic))
+	if err != nil {
+		return nil, err
+	}
+	var entry *entry
+	var nameSize, size int64
+	switch string(magic) {
+	case newcMagic, newcCRCMagic:
+		entry, nameSize, size, err = me.readNewc()
+	case odcMagic:
+		entry, nameSize, size, err = me.readOdc()
+	default:
+		return nil, ErrFormat
+	}
+	if err != nil {
+		return nil, err
+	}
+	if nameSize < 2 || nameSize > maxNameSize {
+		return nil, fmt.Errorf("%w: name size %d", ErrFormat, nameSize)
+	}
+	name := make([]byte, nameSize)
+	if _, err := io.ReadFull(me.reader, name); err != nil {
+		return nil, err
+	}
+	if name[nameSize-1] != 0 {
+		return nil, fmt.Errorf("%w: unterminated name", ErrFormat)
+	}
+	if entry.newc { // the header and name are padded to 4 bytes
+		pad := (4 - (newcHeadLen+nameSize)%4) % 4
+		if _, err := io.CopyN(io.Discard, me.reader, pad); err != nil {
+			return nil, err
+		}
+		me.pad = (4 - size%4) % 4
+	}
+	header := entry.header
+	header.Name = string(name[:nameSize-1])
+	if header.Name == trailer {
+		return nil, nil
+	}
+	if header.Typeflag == tar.TypeDir && header.Name != "." &&
+		header.Name[len(header.Name)-1] != '/' {
+		header.Name += "/"
+	}
+	switch header.Typeflag {
+	case tar.TypeReg:
+		header.Size = size
+	case tar.TypeSymlink:
+		if size > maxLinkSize {
+			return nil, fmt.Errorf("%w: link target size %d", ErrFormat,
+				size)
+		}
+		target := make([]byte, size)
+		if _, err := io.ReadFull(me.reader, target); err != nil {
+			return nil, err
+		}
+		header.Linkname = string(target)
+	default:
+		if _, err := io.CopyN(io.Discard, me.reader, size); err != nil {
+			return nil, err
+		}
+	}
+	return entry, nil
+}
+
+func (me *Reader) readNewc() (*entry, int64, int64, error) {
+	raw := make([]byte, newcHeadLen)
+	if _, err := io.ReadFull(me.reader, raw); err != nil {
+		return nil, 0, 0, err
+	}
+	fields := make([]int64, 13)
+	for i := range fields {
+		value, err := strconv.ParseUint(string(raw[6+i*8:14+i*8]), 16, 32)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("%w: bad header", ErrFormat)
+		}
+		fields[i] = int64(value)
+	}
+	ino, mode, uid, gid, nlink, mtime, size := fields[0], fields[1],
+		fields[2], fields[3], fields[4], fields[5], fields[6]
+	entry := newEntry(mode, uid, gid, mtime, fields[9], fields[10])
+	entry.key = inode{fields[7], fields[8], ino}
+	entry.nlink = nlink
+	entry.newc = true
+	entry.check = string(raw[:6]) == newcCRCMagic
+	entry.want = uint32(fields[12])
+	return entry, fields[11], size, nil
+}
+
+func (me *Reader) readOdc() (*entry, int64, int64, error) {
+	raw := make([]byte, odcHeadLen)
+	if _, err := io.ReadFull(me.reader, raw); err != nil {
+		return nil, 0, 0, err
+	}
+	widths := []int{6, 6, 6, 6, 6, 6, 6, 11, 6, 11}
+	fields := make([]int64, len(widths))
+	offset := 6
+	for i, width := range widths {
+		value, err := strconv.ParseUint(string(raw[offset:offset+width]),
+			8, 64)
+		if err != nil {
+			return nil, 0, 0, fmt.Errorf("%w: bad header", ErrFormat)
+		}
+		fields[i] = int64(value)
+		offset += width
+	}
+	dev, ino, mode, uid, gid, nlink, rdev, mtime := fields[0], fields[1],
+		fields[2], fields[3], fields[4], fields[5], fields[6], fields[7]
+	// odc's dev and rdev are single numbers: assume Linux's old encoding
+	entry := newEntry(mode, uid, gid, mtime, rdev>>8, rdev&0xFF)
+	entry.key = inode{dev >> 8, dev & 0xFF, ino}
+	entry.nlink = nlink
+	return entry, fields[8], fields[9], nil
+}
+
+func newEntry(mode, uid, gid, mtime, rdevMajor, rdevMinor int64) *entry {
+	header := &tar.Header{Mode: mode, Uid: int(uid), Gid: int(gid),
+		ModTime: time.Unix(mtime, 0)}
+	switch mode & 0o170000 {
+	case 0o040000:
+		header.Typeflag = tar.TypeDir
+	case 0o120000:
+		header.Typeflag = tar.TypeSymlink
+	case 0o020000:
+		header.Typeflag = tar.TypeChar
+	case 0o060000:
+		header.Typeflag = tar.TypeBlock
+	case 0o010000:
+		header.Typeflag = tar.TypeFifo
+	case 0o140000:
+		header.Typeflag = TypeSocket
+	default:
+		header.Typeflag = tar.TypeReg
+	}
+	if header.Typeflag == tar.TypeChar || header.Typeflag == tar.TypeBlock {
+		header.Devmajor, header.Devminor = rdevMajor, rdevMinor
+	}
+	return &entry{header: header}
+}
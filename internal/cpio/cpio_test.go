@@ -0,0 +1,154 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package cpio
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+type member struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+func readMembers(t *testing.T, reader *Reader) ([]member, error) {
+	t.Helper()
+	var members []member
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return members, nil
+		}
+		if err != nil {
+			return members, err
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return members, err
+		}
+		members = append(members, member{header.Name, header.Typeflag,
+			header.Linkname, string(content)})
+	}
+}
+
+// The fixtures were made by bsdtar (3.7.7) from the same tree, e.g.,
+// bsdtar -cnf tree-newc.cpio --format newc tree tree/a.txt ...; in both
+// sub/b.txt and sub/hard.txt are hard links to the same file, but newc
+// stores the data with the last link only, whereas bsdtar's odc stores it
+// with every link (so they're just regular files).
+func TestFixtures(t *testing.T) {
+	alpha := strings.Repeat("alpha\n", 50)
+	for _, test := range []struct {
+		name string
+		want []member
+	}{
+		{"tree-newc.cpio", []member{
+			{"tree/", tar.TypeDir, "", ""},
+			{"tree/a.txt", tar.TypeReg, "", alpha},
+			{"tree/sub/", tar.TypeDir, "", ""},
+			{"tree/sub/hard.txt", tar.TypeReg, "", "beta\n"},
+			{"tree/sub/b.txt", tar.TypeLink, "tree/sub/hard.txt", ""},
+			{"tree/link", tar.TypeSymlink, "a.txt", ""},
+		}},
+		{"tree-odc.cpio", []member{
+			{"tree/", tar.TypeDir, "", ""},
+			{"tree/a.txt", tar.TypeReg, "", alpha},
+			{"tree/sub/", tar.TypeDir, "", ""},
+			{"tree/sub/b.txt", tar.TypeReg, "", "beta\n"},
+			{"tree/sub/hard.txt", tar.TypeReg, "", "beta\n"},
+			{"tree/link", tar.TypeSymlink, "a.txt", ""},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			data, err := os.ReadFile(filepath.Join("testdata", test.name))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsMagic(data) {
+				t.Error("IsMagic: expected true")
+			}
+			reader := NewReader(bytes.NewReader(data))
+			got, err := readMembers(t, reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%q", got) != fmt.Sprintf("%q",
+				test.want) {
+				t.Errorf("expected\n%q\ngot\n%q", test.want, got)
+			}
+		})
+	}
+}
+
+// Returns a newc entry with a checksum (070702) whose data's checksum is
+// given as sum.
+func newcCRCEntry(name, data string, sum uint32) string {
+	header := fmt.Sprintf("070702%08X%08X%08X%08X%08X%08X%08X%08X%08X"+
+		"%08X%08X%08X%08X", 1, 0o100644, 0, 0, 1,
+		time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC).Unix(), len(data),
+		0, 0, 0, 0, len(name)+1, sum)
+	entry := header + name + "\x00"
+	entry += strings.Repeat("\x00", (4-len(entry)%4)%4) + data
+	return entry + strings.Repeat("\x00", (4-len(data)%4)%4)
+}
+
+func TestChecksum(t *testing.T) {
+	const data = "checked\n"
+	var sum uint32
+	for _, b := range []byte(data) {
+		sum += uint32(b)
+	}
+	for _, test := range []struct {
+		sum  uint32
+		want error
+	}{
+		{sum, nil},
+		{sum + 1, ErrChecksum},
+	} {
+		archive := newcCRCEntry("a.txt", data, test.sum) +
+			newcCRCEntry(trailer, "", 0)
+		members, err := readMembers(t,
+			NewReader(strings.NewReader(archive)))
+		if !errors.Is(err, test.want) {
+			t.Errorf("sum %d: expected %v, got %v", test.sum, test.want,
+				err)
+		} else if err == nil && (len(members) != 1 ||
+			members[0].content != data) {
+			t.Errorf("sum %d: got %v", test.sum, members)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	valid := newcCRCEntry("a.txt", "abc", 294) +
+		newcCRCEntry(trailer, "", 0)
+	for _, test := range []struct {
+		name    string
+		archive string
+		want    error
+	}{
+		{"not cpio", "070708" + strings.Repeat("0", 200), ErrFormat},
+		{"bad hex", "070701" + strings.Repeat("Z", 200), ErrFormat},
+		{"no name", newcCRCEntry("", "", 0), ErrFormat},
+		{"truncated data", valid[:len(valid)/2], io.ErrUnexpectedEOF},
+		{"no trailer", valid[:len(valid)-len(newcCRCEntry(trailer, "",
+			0))], io.ErrUnexpectedEOF},
+	} {
+		_, err := readMembers(t, NewReader(strings.NewReader(test.archive)))
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, err)
+		}
+	}
+}
@@ -0,0 +1,306 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+// Package lz4 provides a decompressor for the LZ4 frame format (as written
+// by the lz4 program, i.e., .lz4 files), including concatenated and
+// skippable frames and the legacy frame format (lz4 -l).
+package lz4
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var (
+	ErrHeader   = errors.New("lz4: invalid header")
+	ErrCorrupt  = errors.New("lz4: corrupt data")
+	ErrChecksum = errors.New("lz4: checksum error")
+)
+
+const (
+	frameMagic      = 0x184D2204
+	legacyMagic     = 0x184C2102
+	skippableMagic  = 0x184D2A50 // to 0x184D2A5F
+	skippableMask   = 0xFFFFFFF0
+	legacyBlockSize = 8 << 20
+	windowSize      = 64 << 10 // the maximum match offset
+	minMatch        = 4
+)
+
+// Reader implements io.Reader to read an LZ4 stream.
+type Reader struct {
+	reader      *bufio.Reader
+	legacy      bool
+	blockSize   int
+	independent bool // if true matches never refer to earlier blocks
+	blockSums   bool // if true each block is followed by its checksum
+	contentSum  bool // if true the frame ends with a content checksum
+	digest      xxh32
+	block       []byte // compressed data
+	window      []byte // recent output (for dependent blocks) then this block's
+	pending     []byte // decoded bytes not yet returned by Read
+	err         error
+}
+
+// NewReader returns a Reader that decompresses from r or an error if r
+// doesn't begin with a valid LZ4 frame header.
+func NewReader(r io.Reader) (*Reader, error) {
+	me := &Reader{reader: bufio.NewReader(r)}
+	if err := me.readHeader(true); err != nil {
+		return nil, err
+	}
+	return me, nil
+}
+
+// Read reads up to len(p) decompressed bytes into p.
+func (me *Reader) Read(p []byte) (int, error) {
+	for len(me.pending) == 0 && me.err == nil {
+		me.err = me.decodeBlock()
+	}
+	if len(me.pending) > 0 {
+		n := copy(p, me.pending)
+		me.pending = me.pending[n:]
+		return n, nil
+	}
+	return 0, me.err
+}
+
+// Reads the next frame's header, skipping any skippable frames. Returns
+// io.EOF if there are no more frames (unless first).
+func (me *Reader) readHeader(first bool) error {
+	var buf [4]byte
+	if _, err := io.ReadFull(me.reader, buf[:]); err != nil {
+		if err == io.EOF && !first {
+			return io.EOF
+		}
+		return ErrHeader
+	}
+	return me.startFrame(binary.LittleEndian.Uint32(buf[:]))
+}
+
+func (me *Reader) startFrame(magic uint32) error {
+	switch {
+	case magic == frameMagic:
+		return me.readFrameDescriptor()
+	case magic == legacyMagic:
+		me.legacy = true
+		me.independent = true
+		me.blockSums, me.contentSum = false, false
+		me.blockSize = legacyBlockSize
+		return nil
+	case magic&skippableMask == skippableMagic:
+		var buf [4]byte
+		if _, err := io.ReadFull(me.reader, buf[:]); err != nil {
+			return ErrHeader
+		}
+		size := int64(binary.LittleEndian.Uint32(buf[:]))
+		if _, err := io.CopyN(io.Discard, me.reader, size); err != nil {
+			return ErrHeader
+		}
+		return me.readHeader(false)
+	}
+	return ErrHeader
+}
+
+// Returns true if a legacy frame's block size is actually the start of
+// another frame (since legacy frames have no end mark).
+func isMagic(size uint32) bool {
+	return size == frameMagic || size == legacyMagic ||
+		size&skippableMask == skippableMagic
+}
+
+func (me *Reader) readFrameDescriptor() error {
+	var desc [2]byte
+	if _, err := io.ReadFull(me.reader, desc[:]); err != nil {
+		return ErrHeader
+	}
+	flags, bd := desc[0], desc[1]
+	if flags>>6 != 1 || flags&0x02 != 0 || bd&0x8F != 0 {
+		return ErrHeader // wrong version or reserved bits set
+	}
+	sizeCode := bd >> 4 & 0x07
+	if sizeCode < 4 {
+		return ErrHeader
+	}
+	me.legacy = false
+	me.independent = flags&0x20 != 0
+	me.blockSums = flags&0x10 != 0
+	me.contentSum = flags&0x04 != 0
+	me.blockSize = 1 << (2*uint(sizeCode) + 8)
+	header := desc[:]
+	if flags&0x08 != 0 { // content size
+		var size [8]byte
+		if _, err := io.ReadFull(me.reader, size[:]); err != nil {
+			return ErrHeader
+		}
+		header = append(header, size[:]...)
+	}
+	if flags&0x01 != 0 { // dictionary ID
+		return errors.New("lz4: dictionaries are unsupported")
+	}
+	check, err := me.reader.ReadByte()
+	if err != nil {
+		return ErrHeader
+	}
+	if byte(xxh32Sum(header)>>8) != check {
+		return ErrHeader
+	}
+	me.digest.reset()
+	me.window = me.window[:0]
+	return nil
+}
+
+// Reads and decodes the next block into pending (which may be empty if
+// a frame has just ended).
+func (me *Reader) decodeBlock() error {
+	var buf [4]byte
+	if _, err := io.ReadFull(me.reader, buf[:]); err != nil {
+		if err == io.EOF && me.legacy {
+			return io.EOF
+		}
+		return ErrCorrupt
+	}
+	size := binary.LittleEndian.Uint32(buf[:])
+	if me.legacy && isMagic(size) {
+		return me.startFrame(size)
+	}
+	if size == 0 && !me.legacy { // end mark
+		return me.endFrame()
+	}
+	stored := !me.legacy && size&0x80000000 != 0
+	size &= 0x7FFFFFFF
+	if int(size) > me.blockSize+me.blockSize/255+16 {
+		return ErrCorrupt
+	}
+	if cap(me.block) < int(size) {
+		me.block = make([]byte, size)
+	}
+	me.block = me.block[:size]
+	if _, err := io.ReadFull(me.reader, me.block); err != nil {
+		return ErrCorrupt
+	}
+	if me.blockSums {
+		if _, err := io.ReadFull(me.reader, buf[:]); err != nil {
+			return ErrCorrupt
+		}
+		if xxh32Sum(me.block) != binary.LittleEndian.Uint32(buf[:]) {
+			return ErrChecksum
+		}
+	}
+	me.keepWindow()
+	start := len(me.window)
+	if stored {
+		me.window = append(me.window, me.block...)
+	} else {
+		var err error
+		if me.window, err = decompress(me.block, me.window,
+			me.blockSize); err != nil {
+			return err
+		}
+	}
+	me.pending = me.window[start:]
+	if me.contentSum {
+		me.digest.write(me.pending)
+	}
+	return nil
+}
+
+// Discards all but the last windowSize bytes of the previous block if
+// the blocks are dependent; otherwise discards everything.
+func (me *Reader) keepWindow() {
+	if me.independent {
+		me.window = me.window[:0]
+	} else if len(me.window) > windowSize {
+		n := copy(me.window, me.window[len(me.window)-windowSize:])
+		me.window = me.window[:n]
+	}
+}
+
+func (me *Reader) endFrame() error {
+	if me.contentSum {
+		var buf [4]byte
+		if _, err := io.ReadFull(me.reader, buf[:]); err != nil {
+			return ErrCorrupt
+		}
+		if me.digest.sum() != binary.LittleEndian.Uint32(buf[:]) {
+			return ErrChecksum
+		}
+	}
+	return me.readHeader(false)
+}
+
+// Decompresses the block, appending to dst (whose existing content may be
+// referred to by matches) and returns dst.
+func decompress(block, dst []byte, maxSize int) ([]byte, error) {
+	limit := len(dst) + maxSize
+	i := 0
+	for i < len(block) {
+		token := block[i]
+		i++
+		literals := int(token >> 4)
+		if literals == 15 {
+			n, ok := readLength(block, &i)
+			if !ok {
+				return dst, ErrCorrupt
+			}
+			literals += n
+		}
+		if i+literals > len(block) || len(dst)+literals > limit {
+			return dst, ErrCorrupt
+		}
+		dst = append(dst, block[i:i+literals]...)
+		i += literals
+		if i == len(block) {
+			break // the last sequence has only literals
+		}
+		if i+2 > len(block) {
+			return dst, ErrCorrupt
+		}
+		offset := int(binary.LittleEndian.Uint16(block[i:]))
+		i += 2
+		if offset == 0 || offset > len(dst) {
+			return dst, ErrCorrupt
+		}
+		length := int(token & 0x0F)
+		if length == 15 {
+			n, ok := readLength(block, &i)
+			if !ok {
+				return dst, ErrCorrupt
+			}
+			length += n
+		}
+		length += minMatch
+		if len(dst)+length > limit {
+			return dst, ErrCorrupt
+		}
+		start := len(dst) - offset
+		for length > 0 { // the match may overlap what it appends
+			n := length
+			if n > offset {
+				n = offset
+			}
+			dst = append(dst, dst[start:start+n]...)
+			start += n
+			length -= n
+		}
+	}
+	return dst, nil
+}
+
+// Reads the extra bytes of a literal or match length.
+func readLength(block []byte, i *int) (int, bool) {
+	n := 0
+	for {
+		if *i >= len(block) {
+			return 0, false
+		}
+		b := block[*i]
+		*i++
+		n += int(b)
+		if b != 255 {
+			return n, true
+		}
+	}
+}
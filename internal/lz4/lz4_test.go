@@ -0,0 +1,121 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package lz4
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+)
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readAllFrom(compressed []byte, oneByte bool) ([]byte, error) {
+	var source io.Reader = bytes.NewReader(compressed)
+	if oneByte {
+		source = iotest.OneByteReader(source)
+	}
+	reader, err := NewReader(source)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+// The .lz4 files were made by the lz4 program (v1.9.4) from text (which
+// spans three 64K blocks) and random, e.g., lz4 -9 -B4 -BD text
+// text-dependent.lz4; skippable.lz4 is text-dependent.lz4 and random.lz4
+// with skippable frames before, between, and after them.
+func TestFixtures(t *testing.T) {
+	text := readTestdata(t, "text")
+	random := readTestdata(t, "random")
+	for _, test := range []struct {
+		name string
+		want []byte
+	}{
+		{"empty.lz4", []byte{}},
+		{"random.lz4", random},         // incompressible blocks
+		{"text-fast.lz4", text},        // -B4 -BD
+		{"text-dependent.lz4", text},   // -9 -B4 -BD
+		{"text-independent.lz4", text}, // -9 -B4 -BI
+		{"text-nocrc.lz4", text},       // no content checksum
+		{"text-blockcrc.lz4", text},    // -BX block checksums
+		{"text-size.lz4", text},        // --content-size
+		{"text-legacy.lz4", text},      // -l
+		{"skippable.lz4", append(append([]byte{}, text...), random...)},
+	} {
+		compressed := readTestdata(t, test.name)
+		for _, oneByte := range []bool{false, true} {
+			got, err := readAllFrom(compressed, oneByte)
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			} else if !bytes.Equal(got, test.want) {
+				t.Errorf("%s: got %d bytes, expected %d", test.name,
+					len(got), len(test.want))
+			}
+		}
+	}
+}
+
+func TestConcatenated(t *testing.T) {
+	text := readTestdata(t, "text")
+	compressed := append(readTestdata(t, "text-dependent.lz4"),
+		readTestdata(t, "text-legacy.lz4")...)
+	got, err := readAllFrom(compressed, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(append([]byte{}, text...), text...); !bytes.Equal(
+		got, want) {
+		t.Errorf("got %d bytes, expected %d", len(got), len(want))
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		file   string
+		offset int // of the byte to change (from the end if negative)
+		want   error
+	}{
+		{"magic", "text-dependent.lz4", 0, ErrHeader},
+		{"header checksum", "text-dependent.lz4", 6, ErrHeader},
+		{"content checksum", "text-dependent.lz4", -1, ErrChecksum},
+		{"block checksum", "text-blockcrc.lz4", -9, ErrChecksum},
+		{"block data", "text-nocrc.lz4", 2000, nil},
+	} {
+		compressed := readTestdata(t, test.file)
+		offset := test.offset
+		if offset < 0 {
+			offset += len(compressed)
+		}
+		compressed[offset] ^= 0x5A
+		_, err := readAllFrom(compressed, false)
+		if err == nil {
+			// Without checksums, changed data may still decode.
+			if test.want != nil {
+				t.Errorf("%s: expected %s", test.name, test.want)
+			}
+		} else if test.want != nil && !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %s, got %s", test.name, test.want, err)
+		}
+	}
+	text := readTestdata(t, "text-dependent.lz4")
+	for _, size := range []int{3, 10, len(text) / 2, len(text) - 2} {
+		if _, err := readAllFrom(text[:size], false); err == nil {
+			t.Errorf("truncated to %d bytes: expected an error", size)
+		}
+	}
+}
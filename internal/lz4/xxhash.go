@@ -0,0 +1,97 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package lz4
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+const (
+	prime32a = 2654435761
+	prime32b = 2246822519
+	prime32c = 3266489917
+	prime32d = 668265263
+	prime32e = 374761393
+)
+
+// xxh32 is the state of an xxHash-32 checksum with a seed of 0 (which is
+// what LZ4 uses).
+type xxh32 struct {
+	length uint64
+	v      [4]uint32
+	buf    [16]byte
+	count  int // the number of bytes in buf
+}
+
+func (me *xxh32) reset() {
+	me.length = 0
+	me.v = [4]uint32{prime32a, prime32b, 0, 0}
+	me.v[0] += prime32b // separately to avoid constant overflow
+	me.v[3] -= prime32a
+	me.count = 0
+}
+
+func (me *xxh32) write(data []byte) {
+	me.length += uint64(len(data))
+	if me.count > 0 {
+		n := copy(me.buf[me.count:], data)
+		me.count += n
+		data = data[n:]
+		if me.count < len(me.buf) {
+			return
+		}
+		me.stripe(me.buf[:])
+		me.count = 0
+	}
+	for len(data) >= 16 {
+		me.stripe(data)
+		data = data[16:]
+	}
+	me.count = copy(me.buf[:], data)
+}
+
+func (me *xxh32) stripe(data []byte) {
+	for i := range me.v {
+		me.v[i] = round(me.v[i], binary.LittleEndian.Uint32(data[4*i:]))
+	}
+}
+
+func (me *xxh32) sum() uint32 {
+	var h uint32
+	if me.length >= 16 {
+		h = bits.RotateLeft32(me.v[0], 1) + bits.RotateLeft32(me.v[1], 7) +
+			bits.RotateLeft32(me.v[2], 12) + bits.RotateLeft32(me.v[3], 18)
+	} else {
+		h = prime32e
+	}
+	h += uint32(me.length)
+	data := me.buf[:me.count]
+	for ; len(data) >= 4; data = data[4:] {
+		h += binary.LittleEndian.Uint32(data) * prime32c
+		h = bits.RotateLeft32(h, 17) * prime32d
+	}
+	for _, b := range data {
+		h += uint32(b) * prime32e
+		h = bits.RotateLeft32(h, 11) * prime32a
+	}
+	h ^= h >> 15
+	h *= prime32b
+	h ^= h >> 13
+	h *= prime32c
+	h ^= h >> 16
+	return h
+}
+
+func round(acc, input uint32) uint32 {
+	return bits.RotateLeft32(acc+input*prime32b, 13) * prime32a
+}
+
+// Returns the xxHash-32 checksum of data.
+func xxh32Sum(data []byte) uint32 {
+	var digest xxh32
+	digest.reset()
+	digest.write(data)
+	return digest.sum()
+}
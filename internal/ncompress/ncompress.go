@@ -0,0 +1,174 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+// Package ncompress provides a decompressor for the LZW format used by the
+// classic Unix compress program (i.e., .Z files).
+//
+// The standard library's compress/lzw can't be used because compress
+// varies the code width and pads each run of codes to a multiple of eight.
+package ncompress
+
+import (
+	"bufio"
+	"errors"
+	"io"
+)
+
+var (
+	ErrHeader  = errors.New("ncompress: invalid header")
+	ErrCorrupt = errors.New("ncompress: corrupt data")
+)
+
+const (
+	initBits  = 9
+	clearCode = 256
+	first     = 257 // The first free code in block mode
+)
+
+// Reader implements io.Reader to read a compress (.Z) stream.
+type Reader struct {
+	reader    *bufio.Reader
+	bits      uint32 // bits read but not yet used (lowest bits first)
+	nbits     uint   // the number of valid bits in bits
+	width     uint   // the current code width
+	maxBits   uint   // the maximum code width
+	blockMode bool   // if true clearCode resets the table
+	count     int    // codes read since the width last changed
+	maxCode   int    // when nextCode exceeds this the width increases
+	nextCode  int    // the next free code
+	oldCode   int    // the previous code (-1 if none)
+	finChar   byte   // the first byte of the previous code's string
+	prefix    []uint16
+	suffix    []byte
+	stack     []byte
+	pending   []byte // decoded bytes not yet returned by Read
+	err       error
+}
+
+// NewReader returns a Reader that decompresses from r or an error if r
+// doesn't begin with a valid compress header.
+func NewReader(r io.Reader) (*Reader, error) {
+	reader := bufio.NewReader(r)
+	var header [3]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		return nil, ErrHeader
+	}
+	maxBits := uint(header[2] & 0x1F)
+	if header[0] != 0x1F || header[1] != 0x9D || maxBits < initBits ||
+		maxBits > 16 {
+		return nil, ErrHeader
+	}
+	me := &Reader{reader: reader, width: initBits, maxBits: maxBits,
+		blockMode: header[2]&0x80 != 0, maxCode: 1<<initBits - 1,
+		nextCode: 256, oldCode: -1, prefix: make([]uint16, 1<<maxBits),
+		suffix: make([]byte, 1<<maxBits)}
+	if me.blockMode {
+		me.nextCode = first
+	}
+	for i := 0; i < 256; i++ {
+		me.suffix[i] = byte(i)
+	}
+	return me, nil
+}
+
+// Read reads up to len(p) decompressed bytes into p.
+func (me *Reader) Read(p []byte) (int, error) {
+	for len(me.pending) == 0 && me.err == nil {
+		me.err = me.decode()
+	}
+	if len(me.pending) > 0 {
+		n := copy(p, me.pending)
+		me.pending = me.pending[n:]
+		return n, nil
+	}
+	return 0, me.err
+}
+
+// Decodes one code and appends its string to pending.
+func (me *Reader) decode() error {
+	if me.nextCode > me.maxCode {
+		me.skipToGroupEnd()
+		me.width++
+		if me.width == me.maxBits {
+			me.maxCode = 1 << me.maxBits // i.e., never increase again
+		} else {
+			me.maxCode = 1<<me.width - 1
+		}
+	}
+	code, err := me.readCode()
+	if err != nil {
+		return err
+	}
+	if me.oldCode == -1 {
+		if code >= 256 {
+			return ErrCorrupt
+		}
+		me.oldCode = code
+		me.finChar = byte(code)
+		me.pending = append(me.pending, me.finChar)
+		return nil
+	}
+	if code == clearCode && me.blockMode {
+		me.skipToGroupEnd()
+		me.width = initBits
+		me.maxCode = 1<<initBits - 1
+		me.nextCode = first - 1
+		return nil
+	}
+	inCode := code
+	me.stack = me.stack[:0]
+	if code >= me.nextCode { // the KwKwK case
+		if code > me.nextCode {
+			return ErrCorrupt
+		}
+		me.stack = append(me.stack, me.finChar)
+		code = me.oldCode
+	}
+	for code >= 256 {
+		me.stack = append(me.stack, me.suffix[code])
+		code = int(me.prefix[code])
+	}
+	me.finChar = me.suffix[code]
+	me.stack = append(me.stack, me.finChar)
+	for i := len(me.stack) - 1; i >= 0; i-- {
+		me.pending = append(me.pending, me.stack[i])
+	}
+	if me.nextCode < 1<<me.maxBits {
+		me.prefix[me.nextCode] = uint16(me.oldCode)
+		me.suffix[me.nextCode] = me.finChar
+		me.nextCode++
+	}
+	me.oldCode = inCode
+	return nil
+}
+
+// Returns the next code or io.EOF if there are too few bits left for one.
+func (me *Reader) readCode() (int, error) {
+	for me.nbits < me.width {
+		b, err := me.reader.ReadByte()
+		if err != nil {
+			if err == io.EOF {
+				return 0, io.EOF
+			}
+			return 0, err
+		}
+		me.bits |= uint32(b) << me.nbits
+		me.nbits += 8
+	}
+	code := int(me.bits & (1<<me.width - 1))
+	me.bits >>= me.width
+	me.nbits -= me.width
+	me.count++
+	return code, nil
+}
+
+// compress writes codes in groups of eight; whenever the width changes the
+// rest of the current group is padding.
+func (me *Reader) skipToGroupEnd() {
+	for me.count%8 != 0 {
+		if _, err := me.readCode(); err != nil {
+			break
+		}
+	}
+	me.count = 0
+}
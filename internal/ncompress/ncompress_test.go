@@ -0,0 +1,124 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package ncompress
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+)
+
+func readAll(compressed []byte, oneByte bool) ([]byte, error) {
+	var source io.Reader = bytes.NewReader(compressed)
+	if oneByte {
+		source = iotest.OneByteReader(source)
+	}
+	reader, err := NewReader(source)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(reader)
+}
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Returns the content of testdata/clear.Z: 60000 bytes from an alphabet of
+// eight letters, then 80000 random bytes, then 3000 bytes from "xyz\n". The
+// random bytes fill the table (taking the code width from 9 to 16 bits)
+// and then make the compression ratio fall, so compress emits a CLEAR
+// code, after which the width starts again at 9 bits.
+func clearContent() []byte {
+	rng := rand.New(rand.NewSource(15))
+	data := make([]byte, 0, 143000)
+	for i := 0; i < 60000; i++ {
+		data = append(data, "abcdefgh"[rng.Intn(8)])
+	}
+	for i := 0; i < 80000; i++ {
+		data = append(data, byte(rng.Intn(256)))
+	}
+	for i := 0; i < 3000; i++ {
+		data = append(data, "xyz\n"[rng.Intn(4)])
+	}
+	return data
+}
+
+// The .Z files were made by libarchive's compress writer (bsdtar 3.7.7,
+// e.g., bsdtar -cf text.Z --format raw -Z text), which like compress uses
+// block mode with up to 16 bits.
+func TestFixtures(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want []byte
+	}{
+		{"one.Z", []byte("a")},
+		{"text.Z", readTestdata(t, "text")},
+		{"clear.Z", clearContent()},
+	} {
+		compressed := readTestdata(t, test.name)
+		for _, oneByte := range []bool{false, true} {
+			got, err := readAll(compressed, oneByte)
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			} else if !bytes.Equal(got, test.want) {
+				t.Errorf("%s: got %d bytes, expected %d", test.name,
+					len(got), len(test.want))
+			}
+		}
+	}
+}
+
+func TestVectors(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		compressed []byte
+		want       string
+	}{
+		{"empty", []byte{0x1F, 0x9D, 0x90}, ""},
+		{"not block mode", []byte{0x1F, 0x9D, 0x10, 0x61, 0x00}, "a"},
+		{"12 bits", []byte{0x1F, 0x9D, 0x8C, 0x61, 0x00}, "a"},
+		// "aaa": a, then the KwKwK case (code 257 before it's defined)
+		{"KwKwK", []byte{0x1F, 0x9D, 0x90, 0x61, 0x02, 0x02}, "aaa"},
+	} {
+		got, err := readAll(test.compressed, false)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+		} else if string(got) != test.want {
+			t.Errorf("%s: expected %q, got %q", test.name, test.want, got)
+		}
+	}
+}
+
+func TestErrors(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		compressed []byte
+		want       error
+	}{
+		{"short header", []byte{0x1F, 0x9D}, ErrHeader},
+		{"bad magic", []byte{0x1F, 0x8B, 0x90}, ErrHeader},
+		{"too many bits", []byte{0x1F, 0x9D, 0x91}, ErrHeader},
+		{"too few bits", []byte{0x1F, 0x9D, 0x88}, ErrHeader},
+		{"first code too big", []byte{0x1F, 0x9D, 0x90, 0x01, 0x03},
+			ErrCorrupt},
+		{"undefined code", []byte{0x1F, 0x9D, 0x90, 0x61, 0x08, 0x03},
+			ErrCorrupt},
+	} {
+		_, err := readAll(test.compressed, false)
+		if !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, err)
+		}
+	}
+}
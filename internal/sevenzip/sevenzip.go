@@ -0,0 +1,865 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+// Package sevenzip provides a reader for 7-Zip (.7z) archives.
+//
+// Only the commonly used coders are supported: copy, LZMA, LZMA2, Deflate,
+// and BZip2, each of which may be chained (e.g., with the same coder
+// twice), but not multi-stream coders such as BCJ2, nor filters such as
+// BCJ, nor encryption. Since 7z archives are usually "solid" (many files
+// compressed as one stream), files are read by walking the archive in
+// order rather than by random access.
+package sevenzip
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+var (
+	ErrFormat    = errors.New("sevenzip: not a valid 7z archive")
+	ErrChecksum  = errors.New("sevenzip: checksum error")
+	ErrEncrypted = errors.New("sevenzip: encrypted archives aren't " +
+		"supported")
+)
+
+// Magic is the signature that every 7z archive begins with.
+var Magic = []byte{'7', 'z', 0xBC, 0xAF, 0x27, 0x1C}
+
+const signatureHeaderLen = 32
+
+// Property IDs
+const (
+	kEnd = iota
+	kHeader
+	kArchiveProperties
+	kAdditionalStreamsInfo
+	kMainStreamsInfo
+	kFilesInfo
+	kPackInfo
+	kUnpackInfo
+	kSubStreamsInfo
+	kSize
+	kCRC
+	kFolder
+	kCodersUnpackSize
+	kNumUnpackStream
+	kEmptyStream
+	kEmptyFile
+	kAnti
+	kName
+	kCTime
+	kATime
+	kMTime
+	kWinAttributes
+	kComment
+	kEncodedHeader
+)
+
+// File holds the metadata of one archive member.
+type File struct {
+	Name     string
+	Size     int64
+	Mode     os.FileMode // includes os.ModeDir or os.ModeSymlink if apt
+	Modified time.Time
+	crc      uint32
+	hasCRC   bool
+	isStream bool // false for folders and empty files
+}
+
+// Reader reads a 7z archive.
+type Reader struct {
+	File    []*File
+	file    io.ReaderAt
+	streams *streams
+}
+
+type streams struct {
+	packPos   int64
+	packSizes []int64
+	folders   []*folder
+	sizes     []int64 // of each substream (i.e., each non-empty file)
+	crcs      []uint32
+	hasCRCs   []bool
+}
+
+type folder struct {
+	coders      []coder
+	bindPairs   []bindPair
+	packed      []int   // the in stream indexes read from pack streams
+	unpackSizes []int64 // one per out stream
+	crc         uint32
+	hasCRC      bool
+	firstPack   int // the index of this folder's first pack stream
+	substreams  int
+}
+
+type coder struct {
+	id     []byte
+	props  []byte
+	numIn  int
+	numOut int
+}
+
+type bindPair struct {
+	in  int
+	out int
+}
+
+// NewReader returns a Reader for the 7z archive in r which has the given
+// size.
+func NewReader(r io.ReaderAt, size int64) (*Reader, error) {
+	header := make([]byte, signatureHeaderLen)
+	if _, err := r.ReadAt(header, 0); err != nil {
+		return nil, ErrFormat
+	}
+	if !bytes.HasPrefix(header, Magic) ||
+		crc32.ChecksumIEEE(header[12:]) !=
+			binary.LittleEndian.Uint32(header[8:]) {
+		return nil, ErrFormat
+	}
+	offset := binary.LittleEndian.Uint64(header[12:])
+	length := binary.LittleEndian.Uint64(header[20:])
+	if offset > uint64(size) || length > uint64(size)-offset {
+		return nil, ErrFormat
+	}
+	me := &Reader{file: r}
+	if length == 0 { // empty archive
+		return me, nil
+	}
+	data := make([]byte, length)
+	if _, err := r.ReadAt(data,
+		signatureHeaderLen+int64(offset)); err != nil {
+		return nil, err
+	}
+	if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(header[28:]) {
+		return nil, ErrChecksum
+	}
+	if err := me.readHeader(data); err != nil {
+		return nil, err
+	}
+	return me, nil
+}
+
+func (me *Reader) readHeader(data []byte) error {
+	for {
+		buf := &buffer{data: data}
+		switch buf.byte() {
+		case kHeader:
+			return me.parseHeader(buf)
+		case kEncodedHeader: // the real header is compressed
+			streams, err := parseStreams(buf)
+			if err != nil {
+				return err
+			}
+			if len(streams.folders) == 0 {
+				return ErrFormat
+			}
+			reader, err := me.folderReader(streams, 0)
+			if err != nil {
+				return err
+			}
+			if data, err = io.ReadAll(reader); err != nil {
+				return err
+			}
+		default:
+			return ErrFormat
+		}
+	}
+}
+
+func (me *Reader) parseHeader(buf *buffer) error {
+	id := buf.byte()
+	if id == kArchiveProperties {
+		for buf.byte() != kEnd {
+			buf.bytes(int(buf.number()))
+		}
+		id = buf.byte()
+	}
+	if id == kAdditionalStreamsInfo {
+		if _, err := parseStreams(buf); err != nil {
+			return err
+		}
+		id = buf.byte()
+	}
+	me.streams = &streams{}
+	if id == kMainStreamsInfo {
+		streams, err := parseStreams(buf)
+		if err != nil {
+			return err
+		}
+		me.streams = streams
+		id = buf.byte()
+	}
+	if id == kFilesInfo {
+		if err := me.parseFiles(buf); err != nil {
+			return err
+		}
+		id = buf.byte()
+	}
+	if id != kEnd || buf.err != nil {
+		return ErrFormat
+	}
+	return nil
+}
+
+func parseStreams(buf *buffer) (*streams, error) {
+	me := &streams{}
+	subStreams := false
+	for {
+		switch buf.byte() {
+		case kEnd:
+			if buf.err != nil {
+				return nil, ErrFormat
+			}
+			if !subStreams { // one substream per folder
+				for _, folder := range me.folders {
+					folder.substreams = 1
+					me.sizes = append(me.sizes, folder.unpackSize())
+					me.crcs = append(me.crcs, folder.crc)
+					me.hasCRCs = append(me.hasCRCs, folder.hasCRC)
+				}
+			}
+			return me, nil
+		case kPackInfo:
+			me.parsePackInfo(buf)
+		case kUnpackInfo:
+			if err := me.parseUnpackInfo(buf); err != nil {
+				return nil, err
+			}
+		case kSubStreamsInfo:
+			me.parseSubStreams(buf)
+			subStreams = true
+		default:
+			return nil, ErrFormat
+		}
+		if buf.err != nil {
+			return nil, ErrFormat
+		}
+	}
+}
+
+func (me *streams) parsePackInfo(buf *buffer) {
+	me.packPos = int64(buf.number())
+	count := int(buf.number())
+	for {
+		switch buf.byte() {
+		case kSize:
+			me.packSizes = make([]int64, count)
+			for i := range me.packSizes {
+				me.packSizes[i] = int64(buf.number())
+			}
+		case kCRC:
+			buf.digests(count) // not needed
+		default: // kEnd or invalid
+			return
+		}
+	}
+}
+
+func (me *streams) parseUnpackInfo(buf *buffer) error {
+	if buf.byte() != kFolder {
+		return ErrFormat
+	}
+	count := int(buf.number())
+	if buf.byte() != 0 { // external
+		return ErrFormat
+	}
+	packIndex := 0
+	for i := 0; i < count && buf.err == nil; i++ {
+		folder, err := parseFolder(buf)
+		if err != nil {
+			return err
+		}
+		folder.firstPack = packIndex
+		packIndex += len(folder.packed)
+		me.folders = append(me.folders, folder)
+	}
+	if buf.byte() != kCodersUnpackSize {
+		return ErrFormat
+	}
+	for _, folder := range me.folders {
+		for i := range folder.unpackSizes {
+			folder.unpackSizes[i] = int64(buf.number())
+		}
+	}
+	id := buf.byte()
+	if id == kCRC {
+		crcs, defined := buf.digests(count)
+		for i, folder := range me.folders {
+			folder.crc, folder.hasCRC = crcs[i], defined[i]
+		}
+		id = buf.byte()
+	}
+	if id != kEnd {
+		return ErrFormat
+	}
+	return nil
+}
+
+func parseFolder(buf *buffer) (*folder, error) {
+	me := &folder{}
+	numIn := 0
+	numOut := 0
+	count := int(buf.number())
+	for i := 0; i < count && buf.err == nil; i++ {
+		flags := buf.byte()
+		if flags&0x80 != 0 { // alternative methods aren't used
+			return nil, ErrFormat
+		}
+		coder := coder{id: buf.bytes(int(flags & 0x0F)), numIn: 1,
+			numOut: 1}
+		if flags&0x10 != 0 {
+			coder.numIn = int(buf.number())
+			coder.numOut = int(buf.number())
+		}
+		if flags&0x20 != 0 {
+			coder.props = buf.bytes(int(buf.number()))
+		}
+		if coder.numIn != 1 || coder.numOut != 1 {
+			return nil, fmt.Errorf("sevenzip: unsupported coder %X",
+				coder.id)
+		}
+		numIn += coder.numIn
+		numOut += coder.numOut
+		me.coders = append(me.coders, coder)
+	}
+	if numOut == 0 || buf.err != nil {
+		return nil, ErrFormat
+	}
+	for i := 0; i < numOut-1; i++ {
+		me.bindPairs = append(me.bindPairs, bindPair{
+			in: int(buf.number()), out: int(buf.number())})
+	}
+	numPacked := numIn - len(me.bindPairs)
+	if numPacked == 1 {
+		for i := 0; i < numIn; i++ {
+			if me.bindPairForIn(i) == -1 {
+				me.packed = append(me.packed, i)
+				break
+			}
+		}
+	} else {
+		for i := 0; i < numPacked; i++ {
+			me.packed = append(me.packed, int(buf.number()))
+		}
+	}
+	me.unpackSizes = make([]int64, numOut)
+	return me, nil
+}
+
+func (me *streams) parseSubStreams(buf *buffer) {
+	for _, folder := range me.folders {
+		folder.substreams = 1
+	}
+	id := buf.byte()
+	if id == kNumUnpackStream {
+		for _, folder := range me.folders {
+			folder.substreams = int(buf.number())
+		}
+		id = buf.byte()
+	}
+	for _, folder := range me.folders {
+		if folder.substreams == 0 {
+			continue
+		}
+		sum := int64(0)
+		if id == kSize {
+			for i := 1; i < folder.substreams; i++ {
+				size := int64(buf.number())
+				me.sizes = append(me.sizes, size)
+				sum += size
+			}
+		}
+		me.sizes = append(me.sizes, folder.unpackSize()-sum)
+	}
+	if id == kSize {
+		id = buf.byte()
+	}
+	unknown := 0
+	for _, folder := range me.folders {
+		if folder.substreams != 1 || !folder.hasCRC {
+			unknown += folder.substreams
+		}
+	}
+	var crcs []uint32
+	var defined []bool
+	if id == kCRC {
+		crcs, defined = buf.digests(unknown)
+		id = buf.byte()
+	}
+	j := 0
+	for _, folder := range me.folders {
+		if folder.substreams == 1 && folder.hasCRC {
+			me.crcs = append(me.crcs, folder.crc)
+			me.hasCRCs = append(me.hasCRCs, true)
+			continue
+		}
+		for i := 0; i < folder.substreams; i++ {
+			if j < len(crcs) {
+				me.crcs = append(me.crcs, crcs[j])
+				me.hasCRCs = append(me.hasCRCs, defined[j])
+			} else {
+				me.crcs = append(me.crcs, 0)
+				me.hasCRCs = append(me.hasCRCs, false)
+			}
+			j++
+		}
+	}
+	if id != kEnd {
+		buf.err = ErrFormat
+	}
+}
+
+func (me *Reader) parseFiles(buf *buffer) error {
+	count := int(buf.number())
+	if buf.err != nil || count > len(buf.data) { // each file needs ≥1 byte
+		return ErrFormat
+	}
+	emptyStream := make([]bool, count)
+	var emptyFile []bool
+	var names []string
+	var times []uint64
+	var timesDefined []bool
+	var attrs []uint64
+	var attrsDefined []bool
+	numEmpty := 0
+	for {
+		id := buf.byte()
+		if id == kEnd || buf.err != nil {
+			break
+		}
+		data := &buffer{data: buf.bytes(int(buf.number()))}
+		switch id {
+		case kEmptyStream:
+			emptyStream = data.bitVector(count)
+			numEmpty = 0
+			for _, empty := range emptyStream {
+				if empty {
+					numEmpty++
+				}
+			}
+		case kEmptyFile:
+			emptyFile = data.bitVector(numEmpty)
+		case kName:
+			if data.byte() != 0 { // external
+				return ErrFormat
+			}
+			names = decodeNames(data.data[data.pos:])
+		case kMTime:
+			times, timesDefined = data.values(count, 8)
+		case kWinAttributes:
+			attrs, attrsDefined = data.values(count, 4)
+		}
+		if data.err != nil {
+			return ErrFormat
+		}
+	}
+	if buf.err != nil || len(names) < count {
+		return ErrFormat
+	}
+	emptyIndex := 0
+	streamIndex := 0
+	for i := 0; i < count; i++ {
+		file := &File{Name: strings.ReplaceAll(names[i], "\\", "/"),
+			Mode: 0o644}
+		if emptyStream[i] {
+			if emptyIndex >= len(emptyFile) || !emptyFile[emptyIndex] {
+				file.Mode = os.ModeDir | 0o755
+			}
+			emptyIndex++
+		} else {
+			if streamIndex >= len(me.streams.sizes) {
+				return ErrFormat
+			}
+			file.isStream = true
+			file.Size = me.streams.sizes[streamIndex]
+			file.crc = me.streams.crcs[streamIndex]
+			file.hasCRC = me.streams.hasCRCs[streamIndex]
+			streamIndex++
+		}
+		if i < len(attrs) && attrsDefined[i] {
+			file.Mode = attrMode(uint32(attrs[i]), file.Mode)
+		}
+		if i < len(times) && timesDefined[i] {
+			file.Modified = fileTime(times[i])
+		}
+		me.File = append(me.File, file)
+	}
+	return nil
+}
+
+// Returns the mode corresponding to the Windows attributes (which may
+// include a Unix mode in the high 16 bits).
+func attrMode(attr uint32, mode os.FileMode) os.FileMode {
+	const (
+		readOnly      = 0x1
+		directory     = 0x10
+		unixExtension = 0x8000
+	)
+	if attr&unixExtension != 0 {
+		unix := attr >> 16
+		mode = os.FileMode(unix & 0o777)
+		switch unix & 0o170000 {
+		case 0o040000:
+			mode |= os.ModeDir
+		case 0o120000:
+			mode |= os.ModeSymlink
+		}
+		return mode
+	}
+	if attr&directory != 0 {
+		return os.ModeDir | 0o755
+	}
+	if attr&readOnly != 0 {
+		return mode &^ 0o222
+	}
+	return mode
+}
+
+// Converts a Windows FILETIME (100ns intervals since 1601-01-01).
+func fileTime(ft uint64) time.Time {
+	const epochDiff = 116444736000000000 // 1601 to 1970 in 100ns
+	if ft < epochDiff {
+		return time.Time{}
+	}
+	ft -= epochDiff
+	return time.Unix(int64(ft/10000000), int64(ft%10000000)*100)
+}
+
+func decodeNames(data []byte) []string {
+	names := []string{}
+	units := make([]uint16, 0, len(data)/2)
+	for i := 0; i+1 < len(data); i += 2 {
+		unit := binary.LittleEndian.Uint16(data[i:])
+		if unit == 0 {
+			names = append(names, string(utf16.Decode(units)))
+			units = units[:0]
+		} else {
+			units = append(units, unit)
+		}
+	}
+	return names
+}
+
+// Walk calls fn for every file in archive order with a reader of its
+// contents (which is empty for folders). The reader must not be used after
+// fn returns. If a file's contents don't match its CRC, reading them
+// returns ErrChecksum at the end. Walking stops at the first error, which
+// is returned.
+func (me *Reader) Walk(fn func(file *File, reader io.Reader) error) error {
+	folderIndex := -1
+	left := 0 // substreams left in the current folder
+	var folderReader io.Reader
+	for _, file := range me.File {
+		if !file.isStream {
+			if err := fn(file, bytes.NewReader(nil)); err != nil {
+				return err
+			}
+			continue
+		}
+		for left == 0 {
+			folderIndex++
+			if folderIndex >= len(me.streams.folders) {
+				return ErrFormat
+			}
+			left = me.streams.folders[folderIndex].substreams
+			if left > 0 {
+				var err error
+				folderReader, err = me.folderReader(me.streams,
+					folderIndex)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		left--
+		var reader io.Reader = io.LimitReader(folderReader, file.Size)
+		if file.hasCRC {
+			reader = &crcReader{reader: reader, crc: file.crc}
+		}
+		if err := fn(file, reader); err != nil {
+			return err
+		}
+		if _, err := io.Copy(io.Discard, reader); err != nil {
+			return err // fn needn't read everything
+		}
+	}
+	return nil
+}
+
+// Returns a reader of the given folder's unpacked data.
+func (me *Reader) folderReader(streams *streams,
+	index int) (io.Reader, error) {
+	folder := streams.folders[index]
+	offset := signatureHeaderLen + streams.packPos
+	for i := 0; i < folder.firstPack; i++ {
+		offset += streams.packSizes[i]
+	}
+	packed := make([]io.Reader, len(folder.packed))
+	for i := range packed {
+		j := folder.firstPack + i
+		if j >= len(streams.packSizes) {
+			return nil, ErrFormat
+		}
+		packed[i] = io.NewSectionReader(me.file, offset,
+			streams.packSizes[j])
+		offset += streams.packSizes[j]
+	}
+	out := folder.mainOut()
+	if out == -1 {
+		return nil, ErrFormat
+	}
+	return folder.coderReader(out, packed, 0)
+}
+
+// Returns a reader for the given coder (whose in and out stream indexes
+// are the same as its own since only 1-in 1-out coders are supported).
+func (me *folder) coderReader(index int, packed []io.Reader,
+	depth int) (io.Reader, error) {
+	if depth > len(me.coders) {
+		return nil, ErrFormat // a cycle
+	}
+	var source io.Reader
+	if pair := me.bindPairForIn(index); pair != -1 {
+		var err error
+		source, err = me.coderReader(me.bindPairs[pair].out, packed,
+			depth+1)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		for i, in := range me.packed {
+			if in == index {
+				source = packed[i]
+			}
+		}
+		if source == nil {
+			return nil, ErrFormat
+		}
+	}
+	return newDecoder(me.coders[index], source, me.unpackSizes[index])
+}
+
+func newDecoder(coder coder, source io.Reader,
+	size int64) (io.Reader, error) {
+	var reader io.Reader
+	var err error
+	switch string(coder.id) {
+	case "\x00": // copy
+		reader = source
+	case "\x21": // LZMA2
+		if len(coder.props) < 1 {
+			return nil, ErrFormat
+		}
+		reader, err = lzma.Reader2Config{
+			DictCap: lzma2DictCap(coder.props[0])}.NewReader2(source)
+	case "\x03\x01\x01": // LZMA
+		if len(coder.props) < 5 {
+			return nil, ErrFormat
+		}
+		header := make([]byte, lzma.HeaderLen)
+		copy(header, coder.props[:5])
+		binary.LittleEndian.PutUint64(header[5:], uint64(size))
+		reader, err = lzma.NewReader(io.MultiReader(
+			bytes.NewReader(header), source))
+	case "\x04\x01\x08": // Deflate
+		reader = flate.NewReader(source)
+	case "\x04\x02\x02": // BZip2
+		reader = bzip2.NewReader(source)
+	case "\x06\xF1\x07\x01": // AES
+		return nil, ErrEncrypted
+	default:
+		return nil, fmt.Errorf("sevenzip: unsupported coder %X", coder.id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return io.LimitReader(reader, size), nil
+}
+
+func lzma2DictCap(props byte) int {
+	if props >= 40 {
+		return lzma.MaxDictCap
+	}
+	dictCap := int64(2|props&1) << (props/2 + 11)
+	if dictCap < lzma.MinDictCap {
+		return lzma.MinDictCap
+	}
+	if dictCap > lzma.MaxDictCap {
+		return lzma.MaxDictCap
+	}
+	return int(dictCap)
+}
+
+// Returns the index of the out stream that isn't bound to an in stream
+// (i.e., the folder's final output), or -1.
+func (me *folder) mainOut() int {
+	for i := range me.unpackSizes {
+		bound := false
+		for _, pair := range me.bindPairs {
+			if pair.out == i {
+				bound = true
+				break
+			}
+		}
+		if !bound {
+			return i
+		}
+	}
+	return -1
+}
+
+func (me *folder) unpackSize() int64 {
+	if out := me.mainOut(); out != -1 {
+		return me.unpackSizes[out]
+	}
+	return 0
+}
+
+func (me *folder) bindPairForIn(in int) int {
+	for i, pair := range me.bindPairs {
+		if pair.in == in {
+			return i
+		}
+	}
+	return -1
+}
+
+// crcReader returns ErrChecksum at EOF (just once, so that a caller can
+// skip past a corrupt file) if the CRC-32 of the data read through it
+// doesn't match crc.
+type crcReader struct {
+	reader   io.Reader
+	crc      uint32
+	sum      uint32
+	reported bool // ErrChecksum is only returned once
+}
+
+func (me *crcReader) Read(p []byte) (int, error) {
+	n, err := me.reader.Read(p)
+	me.sum = crc32.Update(me.sum, crc32.IEEETable, p[:n])
+	if err == io.EOF && me.sum != me.crc && !me.reported {
+		me.reported = true
+		err = ErrChecksum
+	}
+	return n, err
+}
+
+// buffer reads 7z header values; after any error it returns zero values
+// and err is set.
+type buffer struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (me *buffer) byte() byte {
+	if me.pos >= len(me.data) {
+		me.err = ErrFormat
+		return 0
+	}
+	me.pos++
+	return me.data[me.pos-1]
+}
+
+func (me *buffer) bytes(n int) []byte {
+	if n < 0 || me.pos+n > len(me.data) {
+		me.err = ErrFormat
+		return nil
+	}
+	me.pos += n
+	return me.data[me.pos-n : me.pos]
+}
+
+// Reads a 7z variable length number: the count of leading 1 bits in the
+// first byte is the number of extra (little-endian) bytes.
+func (me *buffer) number() uint64 {
+	first := me.byte()
+	mask := byte(0x80)
+	value := uint64(0)
+	for i := 0; i < 8; i++ {
+		if first&mask == 0 {
+			high := uint64(first & (mask - 1))
+			return value | high<<(8*i)
+		}
+		value |= uint64(me.byte()) << (8 * i)
+		mask >>= 1
+	}
+	return value
+}
+
+func (me *buffer) bitVector(n int) []bool {
+	bits := make([]bool, n)
+	var b byte
+	for i := range bits {
+		if i%8 == 0 {
+			b = me.byte()
+		}
+		bits[i] = b&(0x80>>(i%8)) != 0
+	}
+	return bits
+}
+
+// Reads a vector that says which of n items are defined (or a byte
+// saying that all are).
+func (me *buffer) defined(n int) []bool {
+	if me.byte() != 0 {
+		defined := make([]bool, n)
+		for i := range defined {
+			defined[i] = true
+		}
+		return defined
+	}
+	return me.bitVector(n)
+}
+
+func (me *buffer) digests(n int) ([]uint32, []bool) {
+	defined := me.defined(n)
+	crcs := make([]uint32, n)
+	for i, ok := range defined {
+		if ok {
+			data := me.bytes(4)
+			if me.err != nil {
+				break
+			}
+			crcs[i] = binary.LittleEndian.Uint32(data)
+		}
+	}
+	return crcs, defined
+}
+
+// Reads n optional little-endian values of the given size (4 or 8).
+func (me *buffer) values(n, size int) ([]uint64, []bool) {
+	defined := me.defined(n)
+	if me.byte() != 0 { // external
+		me.err = ErrFormat
+	}
+	values := make([]uint64, n)
+	for i, ok := range defined {
+		if ok {
+			data := me.bytes(size)
+			if me.err != nil {
+				break
+			}
+			if size == 4 {
+				values[i] = uint64(binary.LittleEndian.Uint32(data))
+			} else {
+				values[i] = binary.LittleEndian.Uint64(data)
+			}
+		}
+	}
+	return values, defined
+}
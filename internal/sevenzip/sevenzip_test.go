@@ -0,0 +1,128 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package sevenzip
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// Returns each file's name, mode, and contents, walking the archive.
+func walk(data []byte) ([]string, error) {
+	reader, err := NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	err = reader.Walk(func(file *File, reader io.Reader) error {
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		files = append(files, file.Name+" "+file.Mode.String()+" "+
+			string(content))
+		return nil
+	})
+	return files, err
+}
+
+// The fixtures were made by bsdtar (3.7.7) from the same tree with each of
+// its compression methods, e.g., bsdtar -cf tree-lzma1.7z --format 7zip
+// --options 7zip:compression=lzma1 tree. bsdtar stores symlinks as files
+// holding their target and orders empty streams (empty files and folders)
+// last.
+func TestFixtures(t *testing.T) {
+	want := []string{
+		"tree/link Lrwxrwxrwx a.txt",
+		"tree/a.txt -rw-r--r-- " + strings.Repeat("alpha\n", 50),
+		"tree/sub/b.txt -rw-r--r-- beta\n",
+		"tree/sub/c.txt -rw-r--r-- ",
+		"tree/empty drwxr-xr-x ",
+		"tree/sub drwxr-xr-x ",
+		"tree drwxr-xr-x ",
+	}
+	for _, name := range []string{"tree-store.7z", "tree-lzma1.7z",
+		"tree-lzma2.7z", "tree-deflate.7z", "tree-bzip2.7z"} {
+		t.Run(name, func(t *testing.T) {
+			data := readTestdata(t, name)
+			if !bytes.HasPrefix(data, Magic) {
+				t.Error("expected the 7z signature")
+			}
+			got, err := walk(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if strings.Join(got, "|") != strings.Join(want, "|") {
+				t.Errorf("expected\n%q\ngot\n%q", want, got)
+			}
+			reader, err := NewReader(bytes.NewReader(data),
+				int64(len(data)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			modified := time.Date(2023, 1, 1, 0, 0, 0, 0, time.Local)
+			for _, file := range reader.File {
+				if !file.Modified.Equal(modified) {
+					t.Errorf("%s: expected %s, got %s", file.Name,
+						modified, file.Modified)
+				}
+			}
+		})
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	store := readTestdata(t, "tree-store.7z")
+	content := bytes.Index(store, []byte("alpha\n"))
+	if content == -1 {
+		t.Fatal("expected tree-store.7z to hold a.txt uncompressed")
+	}
+	for _, test := range []struct {
+		name   string
+		data   []byte
+		offset int // of the byte to change (-1 for none)
+		want   error
+	}{
+		{"magic", store, 0, ErrFormat},
+		{"start header", store, 20, ErrFormat},
+		{"next header", store, len(store) - 10, ErrChecksum},
+		{"content", store, content, ErrChecksum},
+		{"truncated", store[:len(store)/2], -1, ErrFormat},
+		{"empty", []byte{}, -1, ErrFormat},
+	} {
+		data := append([]byte{}, test.data...)
+		if test.offset >= 0 {
+			data[test.offset] ^= 0x55
+		}
+		if _, err := walk(data); !errors.Is(err, test.want) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.want, err)
+		}
+	}
+}
+
+func TestCorruptCompressed(t *testing.T) {
+	for _, name := range []string{"tree-lzma1.7z", "tree-lzma2.7z",
+		"tree-deflate.7z", "tree-bzip2.7z"} {
+		data := readTestdata(t, name)
+		data[signatureHeaderLen+20] ^= 0x55 // in the packed stream
+		if _, err := walk(data); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+// Package zipcrypt provides readers that decrypt the raw (still
+// compressed) data of encrypted zip members: both traditional PKWARE
+// encryption ("ZipCrypto") and WinZip AES encryption (AE-1 and AE-2).
+//
+// The standard library's archive/zip can read an encrypted member's raw
+// data (via File.OpenRaw) but can't decrypt it.
+package zipcrypt
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+var (
+	ErrPassword = errors.New("zipcrypt: incorrect password")
+	ErrAuth     = errors.New("zipcrypt: authentication failed (corrupt " +
+		"data or incorrect password)")
+	ErrStrength = errors.New("zipcrypt: invalid AES strength")
+	ErrShort    = errors.New("zipcrypt: encrypted data is too short")
+)
+
+const (
+	zipCryptoHeaderLen = 12
+	pwvLen             = 2  // AES password verification value
+	authLen            = 10 // AES authentication code
+	iterations         = 1000
+	AESExtraID         = 0x9901 // the zip extra field header ID for AES
+	AESMethod          = 99     // the zip compression method for AES
+)
+
+// zipCryptoReader decrypts traditional PKWARE encryption.
+type zipCryptoReader struct {
+	reader io.Reader
+	keys   [3]uint32
+}
+
+// NewZipCryptoReader returns a reader that decrypts r, the raw data of a
+// member encrypted with traditional PKWARE encryption, or ErrPassword if
+// the decrypted header's last byte doesn't match check (which is the high
+// byte of the member's CRC-32, or of its DOS modification time if the
+// member has a data descriptor).
+func NewZipCryptoReader(r io.Reader, password string,
+	check byte) (io.Reader, error) {
+	me := &zipCryptoReader{reader: r,
+		keys: [3]uint32{0x12345678, 0x23456789, 0x34567890}}
+	for i := 0; i < len(password); i++ {
+		me.update(password[i])
+	}
+	var header [zipCryptoHeaderLen]byte
+	if _, err := io.ReadFull(me, header[:]); err != nil {
+		return nil, ErrShort
+	}
+	if header[zipCryptoHeaderLen-1] != check {
+		return nil, ErrPassword
+	}
+	return me, nil
+}
+
+func (me *zipCryptoReader) Read(p []byte) (int, error) {
+	n, err := me.reader.Read(p)
+	for i := 0; i < n; i++ {
+		temp := me.keys[2] | 2
+		p[i] ^= byte((temp * (temp ^ 1)) >> 8)
+		me.update(p[i])
+	}
+	return n, err
+}
+
+func (me *zipCryptoReader) update(b byte) {
+	me.keys[0] = crc32Update(me.keys[0], b)
+	me.keys[1] = (me.keys[1]+(me.keys[0]&0xFF))*134775813 + 1
+	me.keys[2] = crc32Update(me.keys[2], byte(me.keys[1]>>24))
+}
+
+func crc32Update(crc uint32, b byte) uint32 {
+	return crc32.IEEETable[byte(crc)^b] ^ (crc >> 8)
+}
+
+// aesReader decrypts WinZip AES encryption (AES in CTR mode with a
+// little-endian counter, authenticated with HMAC-SHA1).
+type aesReader struct {
+	reader  *bufio.Reader
+	left    int64 // ciphertext bytes not yet read
+	block   cipher.Block
+	counter [aes.BlockSize]byte
+	stream  [aes.BlockSize]byte
+	used    int // bytes of stream already used
+	mac     hash.Hash
+	err     error
+}
+
+// NewAESReader returns a reader that decrypts r, the size bytes of raw
+// data of a member encrypted with WinZip AES of the given strength (1, 2,
+// or 3 for 128, 192, or 256 bit keys), or ErrPassword if the password is
+// wrong. The reader returns ErrAuth at the end if the data fails
+// authentication.
+func NewAESReader(r io.Reader, size int64, password string,
+	strength int) (io.Reader, error) {
+	if strength < 1 || strength > 3 {
+		return nil, ErrStrength
+	}
+	keyLen := 8 + 8*strength
+	saltLen := 4 + 4*strength
+	if size < int64(saltLen+pwvLen+authLen) {
+		return nil, ErrShort
+	}
+	reader := bufio.NewReader(r)
+	salt := make([]byte, saltLen+pwvLen)
+	if _, err := io.ReadFull(reader, salt); err != nil {
+		return nil, ErrShort
+	}
+	key := pbkdf2([]byte(password), salt[:saltLen], iterations,
+		2*keyLen+pwvLen)
+	if subtle.ConstantTimeCompare(key[2*keyLen:], salt[saltLen:]) != 1 {
+		return nil, ErrPassword
+	}
+	block, err := aes.NewCipher(key[:keyLen])
+	if err != nil {
+		return nil, err
+	}
+	return &aesReader{reader: reader,
+		left: size - int64(saltLen+pwvLen+authLen), block: block,
+		used: aes.BlockSize,
+		mac:  hmac.New(sha1.New, key[keyLen:2*keyLen])}, nil
+}
+
+func (me *aesReader) Read(p []byte) (int, error) {
+	if me.err != nil {
+		return 0, me.err
+	}
+	if me.left == 0 {
+		me.err = me.authenticate()
+		return 0, me.err
+	}
+	if int64(len(p)) > me.left {
+		p = p[:me.left]
+	}
+	n, err := me.reader.Read(p)
+	me.left -= int64(n)
+	me.mac.Write(p[:n])
+	for i := 0; i < n; i++ {
+		if me.used == aes.BlockSize {
+			me.nextStream()
+		}
+		p[i] ^= me.stream[me.used]
+		me.used++
+	}
+	if err == io.EOF && me.left > 0 {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil && err != io.EOF {
+		me.err = err
+	}
+	return n, me.err
+}
+
+// Increments the little-endian counter and encrypts it to produce the
+// next block of key stream.
+func (me *aesReader) nextStream() {
+	for i := range me.counter {
+		me.counter[i]++
+		if me.counter[i] != 0 {
+			break
+		}
+	}
+	me.block.Encrypt(me.stream[:], me.counter[:])
+	me.used = 0
+}
+
+func (me *aesReader) authenticate() error {
+	var code [authLen]byte
+	if _, err := io.ReadFull(me.reader, code[:]); err != nil {
+		return ErrShort
+	}
+	if !hmac.Equal(me.mac.Sum(nil)[:authLen], code[:]) {
+		return ErrAuth
+	}
+	return io.EOF
+}
+
+// AESInfo returns the AES strength (1, 2, or 3), the actual compression
+// method, and whether the CRC-32 is unused (AE-2, in which case only the
+// authentication code is checked) from a zip member's extra field, or 0,
+// 0, false if it doesn't have a valid AES extra field.
+func AESInfo(extra []byte) (int, uint16, bool) {
+	for len(extra) >= 4 {
+		id := binary.LittleEndian.Uint16(extra)
+		size := int(binary.LittleEndian.Uint16(extra[2:]))
+		extra = extra[4:]
+		if size > len(extra) {
+			break
+		}
+		if id == AESExtraID && size >= 7 {
+			return int(extra[4]), binary.LittleEndian.Uint16(extra[5:]),
+				binary.LittleEndian.Uint16(extra) == 2
+		}
+		extra = extra[size:]
+	}
+	return 0, 0, false
+}
+
+// pbkdf2 implements PBKDF2 with HMAC-SHA1 (RFC 8018).
+func pbkdf2(password, salt []byte, iterations, keyLen int) []byte {
+	prf := hmac.New(sha1.New, password)
+	key := make([]byte, 0, keyLen)
+	var buf [4]byte
+	for block := uint32(1); len(key) < keyLen; block++ {
+		prf.Reset()
+		prf.Write(salt)
+		binary.BigEndian.PutUint32(buf[:], block)
+		prf.Write(buf[:])
+		u := prf.Sum(nil)
+		t := append([]byte(nil), u...)
+		for i := 1; i < iterations; i++ {
+			prf.Reset()
+			prf.Write(u)
+			u = prf.Sum(u[:0])
+			for j := range t {
+				t[j] ^= u[j]
+			}
+		}
+		key = append(key, t...)
+	}
+	return key[:keyLen]
+}
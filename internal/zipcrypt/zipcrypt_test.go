@@ -0,0 +1,194 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package zipcrypt
+
+import (
+	"archive/zip"
+	"compress/flate"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Returns the decrypted and decompressed content of the archive's
+// encrypted members, keyed by name.
+func decrypt(t *testing.T, archive, password string) (map[string]string,
+	error) {
+	t.Helper()
+	zipReader, err := zip.OpenReader(filepath.Join("testdata", archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zipReader.Close()
+	contents := map[string]string{}
+	for _, member := range zipReader.File {
+		if member.Flags&0x1 == 0 {
+			continue
+		}
+		raw, err := member.OpenRaw()
+		if err != nil {
+			t.Fatal(err)
+		}
+		method := member.Method
+		var reader io.Reader
+		if method == AESMethod {
+			var strength int
+			strength, method, _ = AESInfo(member.Extra)
+			reader, err = NewAESReader(raw, int64(member.CompressedSize64),
+				password, strength)
+		} else {
+			check := byte(member.CRC32 >> 24)
+			if member.Flags&0x8 != 0 {
+				check = byte(member.ModifiedTime >> 8)
+			}
+			reader, err = NewZipCryptoReader(raw, password, check)
+		}
+		if err != nil {
+			return contents, err
+		}
+		if method == zip.Deflate {
+			reader = flate.NewReader(reader)
+		}
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return contents, err
+		}
+		contents[member.Name] = string(content)
+	}
+	return contents, nil
+}
+
+// The fixtures were made by bsdtar (3.7.7) with the passphrase "secret",
+// e.g., bsdtar -cf aes128.zip --format zip --options
+// zip:encryption=aes128 --passphrase secret tree/a.txt tree/sub/b.txt
+// tree/sub/c.txt; c.txt is empty and so isn't encrypted. All the members
+// have data descriptors, so ZipCrypto's check byte is from the time.
+func TestFixtures(t *testing.T) {
+	alpha := strings.Repeat("alpha\n", 50)
+	for _, archive := range []string{"zipcrypt.zip", "zipcrypt-store.zip",
+		"aes128.zip", "aes256.zip"} {
+		t.Run(archive, func(t *testing.T) {
+			contents, err := decrypt(t, archive, "secret")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(contents) != 2 || contents["tree/a.txt"] != alpha ||
+				contents["tree/sub/b.txt"] != "beta\n" {
+				t.Errorf("got %q", contents)
+			}
+		})
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+	for _, test := range []struct {
+		archive  string
+		password string
+		want     error
+	}{
+		{"zipcrypt.zip", "wrong", ErrPassword},
+		{"zipcrypt.zip", "", ErrPassword},
+		{"zipcrypt-store.zip", "Secret", ErrPassword},
+		{"aes128.zip", "wrong", ErrPassword},
+		{"aes256.zip", "secret ", ErrPassword},
+	} {
+		if _, err := decrypt(t, test.archive,
+			test.password); !errors.Is(err, test.want) {
+			t.Errorf("%s %q: expected %v, got %v", test.archive,
+				test.password, test.want, err)
+		}
+	}
+}
+
+func TestAESAuth(t *testing.T) {
+	zipReader, err := zip.OpenReader(filepath.Join("testdata",
+		"aes256.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer zipReader.Close()
+	member := zipReader.File[0]
+	raw, err := member.OpenRaw()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-authLen-1] ^= 0x55 // the last byte of ciphertext
+	strength, _, _ := AESInfo(member.Extra)
+	reader, err := NewAESReader(strings.NewReader(string(data)),
+		int64(len(data)), "secret", strength)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.ReadAll(reader); !errors.Is(err, ErrAuth) {
+		t.Errorf("expected %v, got %v", ErrAuth, err)
+	}
+	if _, err := NewAESReader(strings.NewReader(string(data[:10])), 10,
+		"secret", strength); !errors.Is(err, ErrShort) {
+		t.Errorf("expected %v, got %v", ErrShort, err)
+	}
+	if _, err := NewAESReader(strings.NewReader(string(data)),
+		int64(len(data)), "secret", 4); !errors.Is(err, ErrStrength) {
+		t.Errorf("expected %v, got %v", ErrStrength, err)
+	}
+}
+
+// The vectors are from RFC 6070.
+func TestPBKDF2(t *testing.T) {
+	for _, test := range []struct {
+		password   string
+		salt       string
+		iterations int
+		keyLen     int
+		want       string
+	}{
+		{"password", "salt", 1, 20,
+			"0c60c80f961f0e71f3a9b524af6012062fe037a6"},
+		{"password", "salt", 2, 20,
+			"ea6c014dc72d6f8ccd1ed92ace1d41f0d8de8957"},
+		{"password", "salt", 4096, 20,
+			"4b007901b765489abead49d926f721d065a429c1"},
+		{"passwordPASSWORDpassword",
+			"saltSALTsaltSALTsaltSALTsaltSALTsalt", 4096, 25,
+			"3d2eec4fe41c849b80c8d83662c0e44a8b291a964cf2f07038"},
+		{"pass\x00word", "sa\x00lt", 4096, 16,
+			"56fa6aa75548099dcc37d7f03425e0c3"},
+	} {
+		got := hex.EncodeToString(pbkdf2([]byte(test.password),
+			[]byte(test.salt), test.iterations, test.keyLen))
+		if got != test.want {
+			t.Errorf("%q %q %d: expected %s, got %s", test.password,
+				test.salt, test.iterations, test.want, got)
+		}
+	}
+}
+
+func TestAESInfo(t *testing.T) {
+	for _, test := range []struct {
+		extra    string
+		strength int
+		method   uint16
+		ae2      bool
+	}{
+		{"", 0, 0, false},
+		{"\x01\x99\x07\x00\x02\x00AE\x03\x08\x00", 3, 8, true},
+		{"\x01\x99\x07\x00\x01\x00AE\x01\x00\x00", 1, 0, false},
+		{"\x55\x54\x01\x00\x00\x01\x99\x07\x00\x02\x00AE\x02\x08\x00",
+			2, 8, true}, // after another field
+		{"\x01\x99\x08\x00\x02\x00AE\x03\x08\x00", 0, 0, false}, // short
+	} {
+		strength, method, ae2 := AESInfo([]byte(test.extra))
+		if strength != test.strength || method != test.method ||
+			ae2 != test.ae2 {
+			t.Errorf("%q: expected %d %d %t, got %d %d %t", test.extra,
+				test.strength, test.method, test.ae2, strength, method, ae2)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package zstd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+)
+
+func readTestdata(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func readAllFrom(compressed []byte, oneByte bool) ([]byte, error) {
+	var source io.Reader = bytes.NewReader(compressed)
+	if oneByte {
+		source = iotest.OneByteReader(source)
+	}
+	return io.ReadAll(NewReader(source))
+}
+
+// Returns a skippable frame holding the given data.
+func skippableFrame(data string) []byte {
+	frame := []byte{0x50, 0x2A, 0x4D, 0x18, byte(len(data)), 0, 0, 0}
+	return append(frame, data...)
+}
+
+// The .zst files were made by the zstd program (v1.5.6) from text (which
+// spans two 128K blocks) and random, e.g., zstd -19 -c text >
+// text-19.zst; text-nosize.zst was made from stdin so its frame doesn't
+// record the content size.
+func TestFixtures(t *testing.T) {
+	text := readTestdata(t, "text")
+	random := readTestdata(t, "random")
+	for _, test := range []struct {
+		name string
+		want []byte
+	}{
+		{"empty.zst", []byte{}},
+		{"random.zst", random},     // raw blocks
+		{"text-1.zst", text},       // -1
+		{"text-19.zst", text},      // -19
+		{"text-nocheck.zst", text}, // --no-check
+		{"text-nosize.zst", text},  // no content size
+	} {
+		compressed := readTestdata(t, test.name)
+		for _, oneByte := range []bool{false, true} {
+			got, err := readAllFrom(compressed, oneByte)
+			if err != nil {
+				t.Errorf("%s: %s", test.name, err)
+			} else if !bytes.Equal(got, test.want) {
+				t.Errorf("%s: got %d bytes, expected %d", test.name,
+					len(got), len(test.want))
+			}
+		}
+	}
+}
+
+func TestConcatenated(t *testing.T) {
+	text := readTestdata(t, "text")
+	random := readTestdata(t, "random")
+	var compressed []byte
+	compressed = append(compressed, skippableFrame("before")...)
+	compressed = append(compressed, readTestdata(t, "text-19.zst")...)
+	compressed = append(compressed, skippableFrame("between")...)
+	compressed = append(compressed, readTestdata(t, "random.zst")...)
+	compressed = append(compressed, skippableFrame("")...)
+	got, err := readAllFrom(compressed, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := append(append([]byte{}, text...), random...); !bytes.Equal(
+		got, want) {
+		t.Errorf("got %d bytes, expected %d", len(got), len(want))
+	}
+}
+
+func TestCorrupt(t *testing.T) {
+	valid := readTestdata(t, "text-19.zst")
+	for _, test := range []struct {
+		name   string
+		data   []byte
+		offset int // of the byte to change (-1 for none)
+		want   string
+	}{
+		{"magic", valid, 0, "invalid magic number"},
+		{"reserved bit", valid, 4, "reserved bit"},
+		{"checksum", valid, len(valid) - 1, "checksum"},
+		{"truncated", valid[:len(valid)/2], -1, "EOF"},
+		{"no frame", []byte{0x28, 0xB5}, -1, "EOF"},
+	} {
+		data := append([]byte{}, test.data...)
+		if test.offset >= 0 {
+			if test.name == "reserved bit" {
+				data[test.offset] |= 0x08
+			} else {
+				data[test.offset] ^= 0x55
+			}
+		}
+		_, err := readAllFrom(data, false)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: expected an error with %q, got %v", test.name,
+				test.want, err)
+		}
+	}
+}
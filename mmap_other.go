@@ -0,0 +1,17 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+//go:build !linux && !darwin
+
+package unz
+
+import (
+	"io"
+	"os"
+)
+
+// Files are never memory-mapped on this platform.
+func mapFile(file *os.File, size int64) (io.ReaderAt, func() error,
+	bool) {
+	return nil, nil, false
+}
@@ -0,0 +1,47 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+//go:build linux || darwin
+
+package unz
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Returns the whole of the (read-only) file memory-mapped as an
+// io.ReaderAt and a function that unmaps it, or false if it can't be
+// mapped (e.g., it is too big for the address space).
+func mapFile(file *os.File, size int64) (io.ReaderAt, func() error,
+	bool) {
+	if size <= 0 || int64(int(size)) != size {
+		return nil, nil, false
+	}
+	data, err := unix.Mmap(int(file.Fd()), 0, int(size), unix.PROT_READ,
+		unix.MAP_SHARED)
+	if err != nil {
+		return nil, nil, false
+	}
+	return mapped(data), func() error { return unix.Munmap(data) }, true
+}
+
+// mapped is a memory-mapped file. ReadAt() is safe for concurrent use.
+type mapped []byte
+
+func (me mapped) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	if offset >= int64(len(me)) {
+		return 0, io.EOF
+	}
+	n := copy(p, me[offset:])
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
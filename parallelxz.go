@@ -0,0 +1,357 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// xz files made by multithreaded compressors (e.g., xz -T0, the default
+// since xz 5.4) are split into blocks that are compressed independently
+// and whose sizes are recorded in the index at the end of the file, so
+// the blocks can be decompressed in parallel. Only single-stream files
+// whose blocks all use just the LZMA2 filter (as xz makes by default) are
+// read this way; others are read sequentially by xz.Reader.
+
+// maxXZBlockSize is the largest uncompressed block that is decompressed in
+// parallel, since each block is held in memory until it is read.
+const maxXZBlockSize = 1 << 26
+
+var (
+	errXZCorrupt = errors.New("xz: corrupt block")
+	errXZCheck   = errors.New("xz: block check mismatch")
+)
+
+var (
+	xzHeaderMagic = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+	xzFooterMagic = []byte{'Y', 'Z'}
+	crc64Table    = crc64.MakeTable(crc64.ECMA)
+)
+
+const (
+	xzStreamHeaderSize = 12
+	xzFooterSize       = 12
+	xzLZMA2Filter      = 0x21
+)
+
+// Returns the size of the check each block ends with, for each of the
+// check types that is supported.
+var xzCheckSizes = map[byte]int64{0x00: 0, 0x01: 4, 0x04: 8, 0x0A: 32}
+
+type xzBlock struct {
+	dataOffset int64 // of the compressed data
+	dataSize   int64 // of the compressed data
+	usize      int64 // uncompressed size
+	dictCap    int
+}
+
+// parallelXZReader decompresses up to jobs blocks at a time, each in its
+// own goroutine, and returns their content in order.
+type parallelXZReader struct {
+	results chan chan xzResult
+	done    chan struct{}
+	wait    sync.WaitGroup
+	once    sync.Once
+	data    []byte
+	err     error
+}
+
+type xzResult struct {
+	data []byte
+	err  error
+}
+
+// Returns a reader that decompresses the file's blocks using jobs
+// goroutines, or nil if the file doesn't have more than one block or
+// can't be read this way (in which case it should be read sequentially).
+// The reader must be closed before the file.
+func newParallelXZReader(file *os.File, jobs int) *parallelXZReader {
+	blocks, check := xzBlocks(file)
+	if len(blocks) < 2 {
+		return nil
+	}
+	me := &parallelXZReader{results: make(chan chan xzResult, jobs),
+		done: make(chan struct{})}
+	me.wait.Add(1)
+	go me.dispatch(file, blocks, check, jobs)
+	return me
+}
+
+func (me *parallelXZReader) dispatch(file *os.File, blocks []xzBlock,
+	check byte, jobs int) {
+	defer me.wait.Done()
+	defer close(me.results)
+	tokens := make(chan struct{}, jobs)
+	for _, block := range blocks {
+		select {
+		case tokens <- struct{}{}:
+		case <-me.done:
+			return
+		}
+		result := make(chan xzResult, 1) // so that workers never block
+		select {
+		case me.results <- result:
+		case <-me.done:
+			return
+		}
+		me.wait.Add(1)
+		go func(block xzBlock) {
+			defer me.wait.Done()
+			data, err := block.decode(file, check)
+			result <- xzResult{data, err}
+			<-tokens
+		}(block)
+	}
+}
+
+func (me *parallelXZReader) Read(p []byte) (int, error) {
+	for len(me.data) == 0 {
+		if me.err != nil {
+			return 0, me.err
+		}
+		result, ok := <-me.results
+		if !ok {
+			me.err = io.EOF
+			continue
+		}
+		block := <-result
+		me.data, me.err = block.data, block.err
+	}
+	n := copy(p, me.data)
+	me.data = me.data[n:]
+	return n, nil
+}
+
+// Stops decompressing; must be called before the file is closed.
+func (me *parallelXZReader) Close() {
+	me.once.Do(func() {
+		close(me.done)
+		me.wait.Wait()
+	})
+}
+
+// Returns the file's blocks and check type going by its index and block
+// headers, or nil if it isn't a single stream of LZMA2 blocks of at most
+// maxXZBlockSize.
+func xzBlocks(file *os.File) ([]xzBlock, byte) {
+	info, err := file.Stat()
+	if err != nil || info.Size() < xzStreamHeaderSize+xzFooterSize {
+		return nil, 0
+	}
+	header := make([]byte, xzStreamHeaderSize)
+	footer := make([]byte, xzFooterSize)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return nil, 0
+	}
+	if _, err := file.ReadAt(footer,
+		info.Size()-xzFooterSize); err != nil {
+		return nil, 0
+	}
+	if !bytes.Equal(header[:6], xzHeaderMagic) || !bytes.Equal(footer[10:],
+		xzFooterMagic) || !bytes.Equal(header[6:8], footer[8:10]) ||
+		crc32.ChecksumIEEE(header[6:8]) !=
+			binary.LittleEndian.Uint32(header[8:]) ||
+		crc32.ChecksumIEEE(footer[4:10]) !=
+			binary.LittleEndian.Uint32(footer[:4]) {
+		return nil, 0
+	}
+	check := header[7]
+	checkSize, ok := xzCheckSizes[check]
+	if header[6] != 0 || !ok {
+		return nil, 0
+	}
+	indexSize := (int64(binary.LittleEndian.Uint32(footer[4:8])) + 1) * 4
+	indexOffset := info.Size() - xzFooterSize - indexSize
+	if indexOffset < xzStreamHeaderSize {
+		return nil, 0
+	}
+	index := make([]byte, indexSize)
+	if _, err := file.ReadAt(index, indexOffset); err != nil {
+		return nil, 0
+	}
+	records, ok := xzIndexRecords(index)
+	if !ok {
+		return nil, 0
+	}
+	blocks := make([]xzBlock, 0, len(records)/2)
+	offset := int64(xzStreamHeaderSize)
+	for i := 0; i < len(records); i += 2 {
+		unpadded, usize := records[i], records[i+1]
+		if usize > maxXZBlockSize || unpadded > indexOffset-offset {
+			return nil, 0
+		}
+		block, ok := xzBlockInfo(file, offset, unpadded, checkSize)
+		if !ok {
+			return nil, 0
+		}
+		block.usize = usize
+		blocks = append(blocks, block)
+		offset += padded4(unpadded)
+	}
+	if offset != indexOffset { // e.g., concatenated streams
+		return nil, 0
+	}
+	return blocks, check
+}
+
+// Returns the unpadded and uncompressed sizes of each block recorded in
+// the index, one after the other, and true if the index is valid.
+func xzIndexRecords(index []byte) ([]int64, bool) {
+	size := len(index) - 4
+	if size < 2 || index[0] != 0 || crc32.ChecksumIEEE(index[:size]) !=
+		binary.LittleEndian.Uint32(index[size:]) {
+		return nil, false
+	}
+	pos := 1
+	count, ok := xzVarint(index[:size], &pos)
+	if !ok || count > int64(size) {
+		return nil, false
+	}
+	records := make([]int64, 0, count*2)
+	for i := int64(0); i < count*2; i++ {
+		value, ok := xzVarint(index[:size], &pos)
+		if !ok {
+			return nil, false
+		}
+		records = append(records, value)
+	}
+	for ; pos < size; pos++ {
+		if index[pos] != 0 { // padding
+			return nil, false
+		}
+	}
+	return records, pos%4 == 0
+}
+
+// Returns the block's data offset, data size, and dictionary size going
+// by its header at offset, and true if it uses just the LZMA2 filter.
+func xzBlockInfo(file *os.File, offset, unpadded,
+	checkSize int64) (xzBlock, bool) {
+	var size [1]byte
+	if _, err := file.ReadAt(size[:], offset); err != nil || size[0] == 0 {
+		return xzBlock{}, false
+	}
+	header := make([]byte, (int(size[0])+1)*4)
+	if _, err := file.ReadAt(header, offset); err != nil {
+		return xzBlock{}, false
+	}
+	end := len(header) - 4
+	flags := header[1]
+	if crc32.ChecksumIEEE(header[:end]) !=
+		binary.LittleEndian.Uint32(header[end:]) ||
+		flags&0x3F != 0 { // reserved bits or more than one filter
+		return xzBlock{}, false
+	}
+	pos := 2
+	if flags&0x40 != 0 { // compressed size (which the index implies)
+		if _, ok := xzVarint(header[:end], &pos); !ok {
+			return xzBlock{}, false
+		}
+	}
+	if flags&0x80 != 0 { // uncompressed size (as is in the index)
+		if _, ok := xzVarint(header[:end], &pos); !ok {
+			return xzBlock{}, false
+		}
+	}
+	id, ok := xzVarint(header[:end], &pos)
+	if !ok || id != xzLZMA2Filter {
+		return xzBlock{}, false
+	}
+	propsSize, ok := xzVarint(header[:end], &pos)
+	if !ok || propsSize != 1 || pos >= end || header[pos] > 40 {
+		return xzBlock{}, false
+	}
+	props := header[pos]
+	dictCap := int64(2|props&1) << (props/2 + 11)
+	if props == 40 || dictCap > maxXZBlockSize {
+		dictCap = maxXZBlockSize // no need for more than the block size
+	}
+	dataSize := unpadded - int64(len(header)) - checkSize
+	if dataSize <= 0 {
+		return xzBlock{}, false
+	}
+	return xzBlock{dataOffset: offset + int64(len(header)),
+		dataSize: dataSize, dictCap: int(dictCap)}, true
+}
+
+// Returns the block's decompressed content having verified its size and
+// check.
+func (me xzBlock) decode(file *os.File, check byte) ([]byte, error) {
+	section := io.NewSectionReader(file, me.dataOffset, me.dataSize)
+	reader, err := lzma.Reader2Config{DictCap: me.dictCap}.NewReader2(
+		bufio.NewReaderSize(section, bufferSize))
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, me.usize)
+	if _, err := io.ReadFull(reader, data); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			err = errXZCorrupt // shorter than the index says
+		}
+		return nil, err
+	}
+	var extra [1]byte
+	if n, err := reader.Read(extra[:]); n != 0 || err != io.EOF {
+		return nil, errXZCorrupt // longer than the index says
+	}
+	var sum hash.Hash
+	switch check {
+	case 0x00:
+		return data, nil
+	case 0x01:
+		sum = crc32.NewIEEE()
+	case 0x04:
+		sum = crc64.New(crc64Table)
+	default:
+		sum = sha256.New()
+	}
+	sum.Write(data)
+	want := make([]byte, sum.Size())
+	if _, err := file.ReadAt(want,
+		me.dataOffset+padded4(me.dataSize)); err != nil {
+		return nil, err
+	}
+	got := sum.Sum(nil)
+	if check != 0x0A { // CRCs are stored little-endian
+		for i, j := 0, len(got)-1; i < j; i, j = i+1, j-1 {
+			got[i], got[j] = got[j], got[i]
+		}
+	}
+	if !bytes.Equal(got, want) {
+		return nil, errXZCheck
+	}
+	return data, nil
+}
+
+// Returns the xz variable-length integer at *pos (advancing *pos past
+// it) and true, or false if it isn't valid.
+func xzVarint(data []byte, pos *int) (int64, bool) {
+	var value int64
+	for i := 0; i < 9 && *pos < len(data); i++ {
+		b := data[*pos]
+		*pos++
+		value |= int64(b&0x7F) << (7 * i)
+		if b&0x80 == 0 {
+			return value, b != 0 || i == 0
+		}
+	}
+	return 0, false
+}
+
+// Returns size rounded up to a multiple of 4.
+func padded4(size int64) int64 {
+	return (size + 3) &^ 3
+}
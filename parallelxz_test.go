@@ -0,0 +1,166 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+// Returns the xz file's content decompressed sequentially by xz.Reader.
+func xzContent(t *testing.T, name string) []byte {
+	t.Helper()
+	file, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader, err := xz.NewReader(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParallelXZReader(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		parallel bool
+	}{
+		{"blocks-none.txt.xz", true},
+		{"blocks-crc32.txt.xz", true},
+		{"blocks-crc64.txt.xz", true},
+		{"blocks-sha256.txt.xz", true},
+		{"concatenated.txt.xz", false}, // two streams
+		{"oneblock.txt.xz", false},
+	} {
+		name := filepath.Join("testdata", test.name)
+		want := xzContent(t, name)
+		for _, jobs := range []int{1, 2, 4} {
+			file, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			reader := newParallelXZReader(file, jobs)
+			if (reader != nil) != test.parallel {
+				t.Errorf("%s: expected parallel %t", test.name,
+					test.parallel)
+			}
+			if reader != nil {
+				got, err := io.ReadAll(reader)
+				if err != nil {
+					t.Errorf("%s with %d jobs: %s", test.name, jobs, err)
+				} else if !bytes.Equal(got, want) {
+					t.Errorf("%s with %d jobs: content differs", test.name,
+						jobs)
+				}
+				reader.Close()
+			}
+			file.Close()
+		}
+	}
+}
+
+func TestParallelXZReaderCorrupt(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata",
+		"blocks-crc64.txt.xz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name     string
+		offset   int // of the byte to change
+		parallel bool
+		want     error
+	}{
+		{"unchanged", -1, true, nil},
+		{"data", 3000, true, nil},                    // in block 3's data
+		{"check", 2932 + 1460 - 1, true, errXZCheck}, // block 3's check
+		{"index", len(data) - 12 - 1, false, nil},    // index CRC
+		{"footer", len(data) - 1, false, nil},        // footer magic
+		{"header", 7, false, nil},                    // check type
+		{"block-header", 12 + 1, false, nil},         // block 1's flags
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			corrupt := append([]byte{}, data...)
+			if test.offset >= 0 {
+				corrupt[test.offset] ^= 0x55
+			}
+			name := filepath.Join(t.TempDir(), "corrupt.txt.xz")
+			if err := os.WriteFile(name, corrupt, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			file, err := os.Open(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer file.Close()
+			reader := newParallelXZReader(file, 2)
+			if (reader != nil) != test.parallel {
+				t.Fatalf("expected parallel %t", test.parallel)
+			}
+			if reader == nil {
+				return // read sequentially, so xz.Reader reports errors
+			}
+			defer reader.Close()
+			_, err = io.ReadAll(reader)
+			if test.offset < 0 {
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+			} else if err == nil {
+				t.Error("expected an error")
+			} else if test.want != nil && !errors.Is(err, test.want) {
+				t.Errorf("expected %s, got %s", test.want, err)
+			}
+		})
+	}
+}
+
+func TestParallelXZReaderClose(t *testing.T) {
+	file, err := os.Open(filepath.Join("testdata", "blocks-crc64.txt.xz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	reader := newParallelXZReader(file, 2)
+	if reader == nil {
+		t.Fatal("expected a parallel reader")
+	}
+	buffer := make([]byte, 100)
+	if _, err := reader.Read(buffer); err != nil {
+		t.Fatal(err)
+	}
+	reader.Close() // before reading to the end: mustn't hang
+	reader.Close() // idempotent
+}
+
+func TestUnpackParallelXZ(t *testing.T) {
+	defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(4))
+	dest := t.TempDir() // blocks/ is a wrapper so is stripped
+	archive := filepath.Join("testdata", "blocks.tar.xz")
+	if err := Unpack(archive, dest, quiet()); err != nil {
+		t.Fatal(err)
+	}
+	want := xzContent(t, filepath.Join("testdata", "blocks-crc64.txt.xz"))
+	if got := readFile(t, filepath.Join(dest, "a.txt")); got !=
+		string(want) {
+		t.Error("a.txt differs")
+	}
+	if got := readFile(t, filepath.Join(dest, "b.txt")); len(got) !=
+		13893 {
+		t.Errorf("expected b.txt to have 13893 bytes, got %d", len(got))
+	}
+}
@@ -0,0 +1,142 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Rename is a sed-style substitution applied to members' paths (see
+// ParseRename()).
+type Rename struct {
+	regexp   *regexp.Regexp
+	template string // in regexp.Expand()'s syntax
+	all      bool   // replace every match rather than just the first
+}
+
+// ParseRename returns the Rename for a sed-style substitution such as
+// "s/old/new/" or "s|^v[0-9.]+/|src/|". The delimiter is the character
+// after the s, and may be escaped with \ in the pattern and replacement.
+// The pattern uses Go's regexp syntax. In the replacement & stands for
+// the whole match and \1 to \9 for the capture groups (\& is a literal
+// &). The optional flags are g to replace every match (not just the
+// first) and i to ignore case.
+func ParseRename(expr string) (Rename, error) {
+	if len(expr) < 2 || expr[0] != 's' || expr[1] == '\\' ||
+		expr[1] == '\n' || isAlnum(expr[1]) {
+		return Rename{}, fmt.Errorf("%q: %w", expr, ErrRename)
+	}
+	delim := expr[1]
+	parts := splitUnescaped(expr[2:], delim)
+	if len(parts) != 3 {
+		return Rename{}, fmt.Errorf("%q: %w", expr, ErrRename)
+	}
+	pattern := parts[0]
+	var rename Rename
+	for _, flag := range parts[2] {
+		switch flag {
+		case 'g':
+			rename.all = true
+		case 'i':
+			pattern = "(?i)" + pattern
+		default:
+			return Rename{}, fmt.Errorf("%q: unknown flag %q: %w", expr,
+				flag, ErrRename)
+		}
+	}
+	var err error
+	if rename.regexp, err = regexp.Compile(pattern); err != nil {
+		return Rename{}, fmt.Errorf("%q: %s: %w", expr, err, ErrRename)
+	}
+	rename.template = sedTemplate(parts[1])
+	return rename, nil
+}
+
+// Returns the name with the first match (or if all, every match)
+// replaced.
+func (me Rename) apply(name string) string {
+	if me.all {
+		return me.regexp.ReplaceAllString(name, me.template)
+	}
+	match := me.regexp.FindStringSubmatchIndex(name)
+	if match == nil {
+		return name
+	}
+	replacement := me.regexp.ExpandString(nil, me.template, name, match)
+	return name[:match[0]] + string(replacement) + name[match[1]:]
+}
+
+// Returns the name with each of Renames applied in turn. A folder's
+// trailing "/" isn't part of what is matched, and is kept (unless the
+// name becomes "").
+func (me *Options) Renamed(name string) string {
+	if len(me.Renames) == 0 {
+		return name
+	}
+	trimmed := strings.TrimSuffix(name, "/")
+	for _, rename := range me.Renames {
+		trimmed = rename.apply(trimmed)
+	}
+	if trimmed != "" && strings.HasSuffix(name, "/") &&
+		!strings.HasSuffix(trimmed, "/") {
+		trimmed += "/"
+	}
+	return trimmed
+}
+
+// Splits text at each delim that isn't escaped with \, replacing escaped
+// delims with the delim itself (other escapes are kept as they are).
+func splitUnescaped(text string, delim byte) []string {
+	parts := []string{}
+	var part strings.Builder
+	for i := 0; i < len(text); i++ {
+		switch c := text[i]; {
+		case c == '\\' && i+1 < len(text) && text[i+1] == delim:
+			part.WriteByte(delim)
+			i++
+		case c == '\\' && i+1 < len(text):
+			part.WriteString(text[i : i+2])
+			i++
+		case c == delim:
+			parts = append(parts, part.String())
+			part.Reset()
+		default:
+			part.WriteByte(c)
+		}
+	}
+	return append(parts, part.String())
+}
+
+// Converts a sed replacement (with & and \1 to \9) into a template for
+// regexp.Expand() (with ${0} and ${1} to ${9}).
+func sedTemplate(replacement string) string {
+	var template strings.Builder
+	for i := 0; i < len(replacement); i++ {
+		switch c := replacement[i]; {
+		case c == '&':
+			template.WriteString("${0}")
+		case c == '$':
+			template.WriteString("$$")
+		case c == '\\' && i+1 < len(replacement):
+			i++
+			if next := replacement[i]; '0' <= next && next <= '9' {
+				template.WriteString("${" + string(next) + "}")
+			} else if next == '$' {
+				template.WriteString("$$")
+			} else {
+				template.WriteByte(next) // e.g., \& or \\
+			}
+		default:
+			template.WriteByte(c)
+		}
+	}
+	return template.String()
+}
+
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' ||
+		'0' <= c && c <= '9'
+}
@@ -0,0 +1,135 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Returns the Options with the given renames parsed.
+func renameOptions(t *testing.T, exprs ...string) Options {
+	t.Helper()
+	options := quiet()
+	for _, expr := range exprs {
+		rename, err := ParseRename(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		options.Renames = append(options.Renames, rename)
+	}
+	return options
+}
+
+func TestParseRenameErrors(t *testing.T) {
+	for _, expr := range []string{
+		"", "s", "x/a/b/", "s/a/b", "s/a/b/c/", "sa/b/c/", "s\\a\\b\\",
+		"s/a/b/x", "s/(/b/", "s/a\\/b/",
+	} {
+		if _, err := ParseRename(expr); !errors.Is(err, ErrRename) {
+			t.Errorf("%q: expected %v, got %v", expr, ErrRename, err)
+		}
+	}
+}
+
+func TestRenamed(t *testing.T) {
+	for _, test := range []struct {
+		exprs []string
+		name  string
+		want  string
+	}{
+		{nil, "a/b.txt", "a/b.txt"},
+		{[]string{"s/^project-[0-9.]+/project/"}, "project-1.2.3/src/a.go",
+			"project/src/a.go"},
+		{[]string{"s/^project-[0-9.]+/project/"}, "project-1.2.3/",
+			"project/"}, // a folder keeps its "/"
+		{[]string{`s/^(\w+)-(\w+)/\2-\1/`}, "alpha-beta/x", "beta-alpha/x"},
+		{[]string{`s/(b+)/[&:\1]/`}, "abbc/bb", "a[bb:bb]c/bb"},
+		{[]string{`s/(b+)/[&:\1]/g`}, "abbc/bb", "a[bb:bb]c/[bb:bb]"},
+		{[]string{`s|\.TXT$|.txt|i`}, "docs/README.TxT", "docs/README.txt"},
+		{[]string{`s/\.TXT$/.txt/`}, "docs/README.TxT", "docs/README.TxT"},
+		{[]string{`s/a/\&\$1/`}, "a.txt", "&$1.txt"},
+		{[]string{`s,\,,;,g`}, "a,b,c", "a;b;c"},
+		{[]string{"s/^src/lib/", "s/^lib/pkg/", `s/\.go$/.txt/`},
+			"src/main.go", "pkg/main.txt"}, // chained in order
+		{[]string{"s/^lib/pkg/", "s/^src/lib/"}, "src/main.go",
+			"lib/main.go"},
+		{[]string{"s/.*//"}, "a/b/", ""},
+	} {
+		options := renameOptions(t, test.exprs...)
+		if got := options.Renamed(test.name); got != test.want {
+			t.Errorf("%q %s: expected %q, got %q", test.exprs, test.name,
+				test.want, got)
+		}
+	}
+}
+
+// Renames are applied after StripComponents, and renamed paths are then
+// checked, so a rename can't escape the destination. (Those that try to
+// leave members without a shared top-level folder, so the rest are
+// unpacked into the v1 subfolder, and the hard link to the renamed
+// main.go is skipped too.)
+func TestUnpackRenames(t *testing.T) {
+	entries := []tarEntry{
+		{name: "v1/project-1.2/", typeflag: tar.TypeDir},
+		{name: "v1/project-1.2/src/main.go", content: "main"},
+		{name: "v1/project-1.2/README.TXT", content: "readme"},
+		{name: "v1/project-1.2/link.go", typeflag: tar.TypeLink,
+			linkname: "v1/project-1.2/src/main.go"},
+	}
+	for _, test := range []struct {
+		name   string
+		exprs  []string
+		want   []string
+		report string // in stderr
+	}{
+		{"none", nil, []string{"README.TXT", "link.go", "src",
+			"src/main.go"}, ""},
+		{"chained", []string{`s/^project-[0-9.]+/project/`,
+			`s|\.txt$|.md|i`, `s|^(project)/src/(.*)|\1/lib/\2|`},
+			[]string{"README.md", "lib", "lib/main.go", "link.go"}, ""},
+		{"escape", []string{`s|^project-[0-9.]+/src|..|`}, []string{"v1",
+			"v1/project-1.2", "v1/project-1.2/README.TXT"}, "unsafe path"},
+		{"absolute", []string{`s|^project-[0-9.]+/src|DIR|`}, []string{
+			"v1", "v1/project-1.2", "v1/project-1.2/README.TXT"},
+			"absolute path"},
+		{"dropped", []string{`s|.*\.TXT$||`}, []string{"link.go", "src",
+			"src/main.go"}, ""},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "v1.tar")
+			writeTar(t, archive, entries)
+			var stderr bytes.Buffer
+			exprs := make([]string, 0, len(test.exprs))
+			for _, expr := range test.exprs {
+				exprs = append(exprs, strings.ReplaceAll(expr, "DIR",
+					filepath.ToSlash(dir)))
+			}
+			options := renameOptions(t, exprs...)
+			options.Stderr = &stderr
+			options.StripComponents = 1
+			dest := filepath.Join(dir, "out")
+			if err := Unpack(archive, dest, options); err != nil {
+				t.Fatal(err)
+			}
+			if got := treePaths(t, dest); !equalStrs(got, test.want) {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+			for _, path := range treePaths(t, dir) {
+				if path != "v1.tar" && path != "out" &&
+					!strings.HasPrefix(path, "out/") {
+					t.Errorf("unexpected %s outside the destination", path)
+				}
+			}
+			if !strings.Contains(stderr.String(), test.report) {
+				t.Errorf("expected %q in %q", test.report, stderr.String())
+			}
+		})
+	}
+}
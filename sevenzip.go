@@ -0,0 +1,150 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/mark-summerfield/unz/internal/sevenzip"
+)
+
+// 7z archives are usually solid, so members are unpacked in archive order
+// as the archive is decompressed.
+func unpackSevenZip(archive, dest string, options *Options) error {
+	file, reader, err := openSevenZip(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	members := sevenZipFileMembers(reader.File)
+	if err := options.checkMembers(archive, members); err != nil {
+		return err
+	}
+	names := options.unpackNames(members)
+	if len(names) == 0 {
+		if options.Verbose {
+			fmt.Fprintln(options.Stdout, "no members to unpack")
+		}
+		return nil
+	}
+	unpacker, err := newArchiveUnpacker(archive, dest, members, options)
+	if err != nil {
+		return err
+	}
+	defer unpacker.finish()
+	var memberErr error // already has context
+	err = reader.Walk(func(member *sevenzip.File, r io.Reader) error {
+		memberErr = unpacker.unpackOneSevenZipMember(member, r)
+		return memberErr
+	})
+	if err != nil && err != memberErr {
+		err = fmt.Errorf("failed to read %s: %w", archive,
+			brokenError{err})
+	}
+	if err != nil {
+		if options.KeepBroken && isBroken(err) {
+			return err // can't resync, but keep what was unpacked
+		}
+		return unpacker.failed(err)
+	}
+	return unpacker.brokenErr()
+}
+
+func (me *unpacker) unpackOneSevenZipMember(member *sevenzip.File,
+	reader io.Reader) error {
+	if err := me.options.canceled(); err != nil {
+		return fmt.Errorf("failed to unpack %s: %w", me.archive, err)
+	}
+	if !me.options.wanted(member.Name, member.Modified) {
+		return nil // try next one
+	}
+	name, ok := me.stripped(member.Name)
+	if !ok {
+		return nil // try next one
+	}
+	name, ok = me.memberPath(name, member.Mode.IsDir())
+	if !ok {
+		return nil // try next one
+	}
+	switch {
+	case member.Mode.IsDir():
+		if err := os.MkdirAll(name, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create folder %s: %w", name, err)
+		}
+		me.addDir(name, member.Mode, member.Modified)
+		me.report("created folder %s", name)
+		me.recordCreated(name)
+	case member.Mode&os.ModeSymlink != 0:
+		if !me.shouldWrite(name, member.Modified) {
+			return nil // try next one
+		}
+		target, err := io.ReadAll(io.LimitReader(reader, 4096))
+		if err != nil {
+			if err = (brokenError{err}); me.keptBroken(member.Name, err) {
+				return nil // try next one
+			}
+			return fmt.Errorf("failed to unpack %s from %s: %w",
+				member.Name, me.archive, err)
+		}
+		return me.unpackSymlink(name, string(target))
+	default:
+		if me.alreadyWritten(name, member.Modified, member.Size) ||
+			!me.shouldWrite(name, member.Modified) {
+			return nil // try next one
+		}
+		if err := makeParent(name); err != nil {
+			return err
+		}
+		mode := me.fileMode(member.Mode)
+		if err := me.writeFile(reader, name, mode,
+			member.Size); err != nil {
+			if me.keptBroken(member.Name, err) {
+				return nil // try next one
+			}
+			return fmt.Errorf("failed to unpack %s from %s: %w",
+				member.Name, me.archive, err)
+		}
+		me.setMode(name, mode)
+		me.setTime(name, member.Modified)
+		me.report("created file %s", name)
+		me.recordCreated(name)
+	}
+	return nil
+}
+
+func sevenZipMembers(archive string) ([]Member, error) {
+	file, reader, err := openSevenZip(archive)
+	if err != nil {
+		return []Member{}, err
+	}
+	defer file.Close()
+	return sevenZipFileMembers(reader.File), nil
+}
+
+func sevenZipFileMembers(files []*sevenzip.File) []Member {
+	members := make([]Member, 0, len(files))
+	for _, file := range files {
+		members = append(members, Member{Name: file.Name, Size: file.Size,
+			Mode: file.Mode, ModTime: file.Modified})
+	}
+	return members
+}
+
+func openSevenZip(archive string) (*os.File, *sevenzip.Reader, error) {
+	file, err := os.Open(archive)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open %s: %w", archive, err)
+	}
+	info, err := file.Stat()
+	if err == nil {
+		var reader *sevenzip.Reader
+		if reader, err = sevenzip.NewReader(file, info.Size()); err == nil {
+			return file, reader, nil
+		}
+	}
+	file.Close()
+	return nil, nil, fmt.Errorf("failed to open %s: %w", archive, err)
+}
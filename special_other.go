@@ -0,0 +1,15 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+//go:build !linux && !darwin
+
+package unz
+
+import (
+	"archive/tar"
+	"errors"
+)
+
+func makeSpecial(name string, header *tar.Header) error {
+	return errors.New("devices and FIFOs aren't supported on this platform")
+}
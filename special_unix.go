@@ -0,0 +1,33 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+//go:build linux || darwin
+
+package unz
+
+import (
+	"archive/tar"
+	"errors"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Creates the FIFO or (if running as root) the character or block device
+// described by the header.
+func makeSpecial(name string, header *tar.Header) error {
+	mode := uint32(header.Mode & 0o7777)
+	switch header.Typeflag {
+	case tar.TypeChar:
+		mode |= unix.S_IFCHR
+	case tar.TypeBlock:
+		mode |= unix.S_IFBLK
+	default:
+		return unix.Mkfifo(name, mode)
+	}
+	if os.Geteuid() != 0 {
+		return errors.New("cannot create device (not privileged)")
+	}
+	return unix.Mknod(name, mode, int(unix.Mkdev(uint32(header.Devmajor),
+		uint32(header.Devminor))))
+}
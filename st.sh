@@ -1,8 +1,8 @@
 #!/bin/bash
 clc -sS
 go mod tidy
-go fmt .
-staticcheck .
-go vet .
+go fmt ./...
+staticcheck ./...
+go vet ./...
 golangci-lint run
 git st
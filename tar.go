@@ -0,0 +1,407 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"os"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mark-summerfield/unz/internal/ar"
+	"github.com/mark-summerfield/unz/internal/cpio"
+)
+
+// headerReader reads a tarball's members in order: tar.Reader, and
+// cpio.Reader and ar.Reader which present cpio and ar archives' members as
+// tar headers (so these archives are handled as tarballs throughout).
+type headerReader interface {
+	Next() (*tar.Header, error)
+	io.Reader
+}
+
+// Returns a reader of the tarball (or if kind is cpioKind or arKind, the
+// cpio or ar archive) that reader reads.
+func newHeaderReader(kind kind, reader io.Reader) headerReader {
+	switch kind {
+	case cpioKind:
+		return cpio.NewReader(reader)
+	case arKind:
+		return ar.NewReader(reader)
+	}
+	return tar.NewReader(reader)
+}
+
+// The members must be known before unpacking starts (see
+// newArchiveUnpacker()), so tarballs are read twice, the first pass
+// keeping only the headers. To avoid decompressing twice, the first pass
+// may spool the decompressed tarball to a temporary file which the second
+// pass reads instead (see spooledTarballMembers()); otherwise the tarball
+// is reopened.
+func unpackTarball(archive, dest string, form format,
+	options *Options) error {
+	members, spooled, err := spooledTarballMembers(archive, form, options)
+	if spooled != "" {
+		defer os.Remove(spooled)
+	}
+	if err != nil && (!(options.KeepBroken && len(members) > 0) ||
+		options.canceled() != nil) {
+		return err // else unpack the members before the broken one
+	}
+	if err := options.checkMembers(archive, members); err != nil {
+		return err
+	}
+	names := options.unpackNames(members)
+	if len(names) == 0 {
+		if options.Verbose {
+			fmt.Fprintln(options.Stdout, "no members to unpack")
+		}
+		return nil
+	}
+	unpacker, err := newArchiveUnpacker(archive, dest, members, options)
+	if err != nil {
+		return err
+	}
+	if options.PreserveOwner {
+		if os.Geteuid() == 0 {
+			unpacker.owner = true
+		} else {
+			unpacker.warn("cannot set owner (not privileged)")
+		}
+	}
+	var reader headerReader
+	var closer closer
+	if spooled != "" {
+		reader, closer, err = openTarball(spooled, format{form.kind,
+			uncompressed})
+	} else { // can't reuse the first one
+		reader, closer, err = openTarball(archive, form)
+	}
+	if err != nil {
+		return err
+	}
+	defer closer()
+	defer unpacker.finish()
+	for {
+		if err := unpacker.unpackOneTarMember(reader); err != nil {
+			if err == io.EOF {
+				return unpacker.brokenErr()
+			}
+			if options.KeepBroken && isBroken(err) {
+				return err // can't resync, but keep what was unpacked
+			}
+			return unpacker.failed(err)
+		}
+	}
+}
+
+// Returns io.EOF when there are no more members, another error if
+// unpacking can't continue, or nil.
+func (me *unpacker) unpackOneTarMember(reader headerReader) error {
+	if err := me.options.canceled(); err != nil {
+		return fmt.Errorf("failed to unpack %s: %w", me.archive, err)
+	}
+	header, err := reader.Next()
+	if err == io.EOF {
+		return err // no more to do
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", me.archive,
+			brokenError{err})
+	}
+	if !me.options.wanted(header.Name, header.ModTime) {
+		return nil // try next one
+	}
+	name, ok := me.stripped(header.Name)
+	if !ok {
+		return nil // try next one
+	}
+	name, ok = me.memberPath(name, header.Typeflag == tar.TypeDir)
+	if !ok {
+		return nil // try next one
+	}
+	switch header.Typeflag {
+	case tar.TypeDir:
+		if err := os.MkdirAll(name, os.ModePerm); err != nil {
+			return fmt.Errorf("failed to create folder %s: %w", name, err)
+		}
+		me.setOwner(name, header)
+		me.setXattrs(name, header)
+		me.addDir(name, header.FileInfo().Mode(), header.ModTime)
+		me.report("created folder %s", name)
+		me.recordCreated(name)
+	case tar.TypeReg:
+		if me.alreadyWritten(name, header.ModTime, header.Size) ||
+			!me.shouldWrite(name, header.ModTime) {
+			return nil // try next one
+		}
+		if err := makeParent(name); err != nil {
+			return err
+		}
+		mode := me.fileMode(header.FileInfo().Mode())
+		if err := me.writeFile(reader, name, mode,
+			header.Size); err != nil {
+			if me.keptBroken(header.Name, err) {
+				return nil // the next header can't be read either
+			}
+			return fmt.Errorf("failed to unpack %s from %s: %w",
+				header.Name, me.archive, err)
+		}
+		me.setOwner(name, header)
+		me.setXattrs(name, header) // after chown which clears capabilities
+		me.setMode(name, mode)     // after chown which may clear setuid
+		me.setTime(name, header.ModTime)
+		me.report("created file %s", name)
+		me.recordCreated(name)
+	case tar.TypeSymlink:
+		if !me.shouldWrite(name, header.ModTime) {
+			return nil // try next one
+		}
+		if err := me.unpackSymlink(name, header.Linkname); err != nil {
+			return err
+		}
+		if info, err := os.Lstat(name); err == nil &&
+			info.Mode()&os.ModeSymlink != 0 { // it may have been skipped
+			me.setOwner(name, header)
+			me.setXattrs(name, header)
+		}
+	case tar.TypeLink:
+		if !me.shouldWrite(name, header.ModTime) {
+			return nil // try next one
+		}
+		target, ok := me.stripped(header.Linkname)
+		if !ok {
+			me.skip(strippedTarget, "skipping hard link %s whose target "+
+				"%s was stripped", name, header.Linkname)
+			return nil // try next one
+		}
+		return me.unpackHardlink(name, target)
+	case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+		if !me.options.Special {
+			me.skip(special, "skipping device or FIFO %s", name)
+			return nil // try next one
+		}
+		return me.unpackSpecial(name, header)
+	default:
+		me.skip(unsupported, "skipping unsupported member type %q %s",
+			header.Typeflag, name)
+	}
+	return nil
+}
+
+func (me *unpacker) unpackSpecial(name string, header *tar.Header) error {
+	if !me.shouldWrite(name, header.ModTime) {
+		return nil // try next one
+	}
+	if err := makeParent(name); err != nil {
+		return err
+	}
+	_ = os.Remove(name) // in case it already exists
+	if err := makeSpecial(name, header); err != nil {
+		me.skip(special, "skipping device or FIFO %s: %s", name, err)
+		return nil // try next one
+	}
+	me.setOwner(name, header)
+	me.setXattrs(name, header)
+	// the device or FIFO was made with its archived type, so its archived
+	// permissions apply
+	me.setMode(name, me.fileMode(header.FileInfo().Mode().Perm()))
+	me.setTime(name, header.ModTime)
+	me.report("created special file %s", name)
+	me.recordCreated(name)
+	return nil
+}
+
+// Sets the owner and group of name (without following soft links) from
+// the header if PreserveOwner and running as root. Ids in OwnerMap or
+// GroupMap are mapped to local ones; otherwise if the header's numeric
+// ids are 0 its user and group names are looked up instead.
+func (me *unpacker) setOwner(name string, header *tar.Header) {
+	if !me.owner {
+		return
+	}
+	uid, mapped := mappedID(me.options.OwnerMap, header.Uid, header.Uname)
+	if !mapped && uid == 0 && header.Uname != "" {
+		if u, err := user.Lookup(header.Uname); err == nil {
+			uid, _ = strconv.Atoi(u.Uid)
+		}
+	}
+	gid, mapped := mappedID(me.options.GroupMap, header.Gid, header.Gname)
+	if !mapped && gid == 0 && header.Gname != "" {
+		if g, err := user.LookupGroup(header.Gname); err == nil {
+			gid, _ = strconv.Atoi(g.Gid)
+		}
+	}
+	if err := os.Lchown(name, uid, gid); err != nil {
+		me.warn("failed to set owner for %s: %s", name, err)
+	}
+}
+
+// Returns the local id that ids maps the archived id (as a decimal string)
+// or failing that its name to and true, or the id and false if neither is
+// mapped.
+func mappedID(ids map[string]int, id int, name string) (int, bool) {
+	if local, ok := ids[strconv.Itoa(id)]; ok {
+		return local, true
+	}
+	if local, ok := ids[name]; ok && name != "" {
+		return local, true
+	}
+	return id, false
+}
+
+// PAX records with this prefix hold extended attributes (as written by
+// GNU tar --xattrs and bsdtar).
+const xattrPrefix = "SCHILY.xattr."
+
+// Sets the extended attributes of name (without following soft links)
+// from the header's PAX records if Xattrs and they're supported. Those
+// that can't be set (e.g., security.capability when not run as root) are
+// skipped with a warning.
+func (me *unpacker) setXattrs(name string, header *tar.Header) {
+	if !me.options.Xattrs || !XattrsSupported {
+		return
+	}
+	attrs := []string{}
+	for key := range header.PAXRecords {
+		if strings.HasPrefix(key, xattrPrefix) {
+			attrs = append(attrs, key[len(xattrPrefix):])
+		}
+	}
+	sort.Strings(attrs)
+	for _, attr := range attrs {
+		value := header.PAXRecords[xattrPrefix+attr]
+		if err := setXattr(name, attr, []byte(value)); err != nil {
+			me.warn("failed to set extended attribute %s for %s: %s", attr,
+				name, err)
+		}
+	}
+}
+
+func tarballMembers(archive string, form format) ([]Member, error) {
+	reader, closer, err := openTarball(archive, form)
+	if err != nil {
+		return []Member{}, err
+	}
+	defer closer()
+	return readTarballMembers(archive, reader)
+}
+
+// spoolLimit is the most decompressed bytes that are spooled (see
+// spooledTarballMembers()): larger tarballs are decompressed twice rather
+// than use so much temporary space.
+const spoolLimit = 1 << 30
+
+// Returns the tarball's members, and if it is compressed and all its
+// members may be wanted (i.e., there are no filters, since otherwise the
+// tarball would mostly be spooled for nothing), the name of a temporary
+// file in TempFolder (or the OS's temporary folder) holding its
+// decompressed content (which the caller must remove). If the
+// decompressed content can't be spooled or would exceed spoolLimit (or
+// MaxSize if that's smaller), the name is "" and the tarball must be
+// reopened.
+func spooledTarballMembers(archive string, form format,
+	options *Options) ([]Member, string, error) {
+	if form.compression == uncompressed { // tar.Reader can seek past data
+		members, err := tarballMembers(archive, form)
+		return members, "", err
+	}
+	reader, closer, err := openDecompressed(archive, form.compression)
+	if err != nil {
+		return []Member{}, "", err
+	}
+	defer closer()
+	if options.Context != nil {
+		reader = contextReader{reader: reader, ctx: options.Context}
+	}
+	var file *os.File
+	if !options.selective() {
+		file, _ = os.CreateTemp(options.TempFolder, "unz-*.tar")
+	}
+	if file == nil {
+		members, err := readTarballMembers(archive,
+			newHeaderReader(form.kind, reader))
+		return members, "", err
+	}
+	limit := int64(spoolLimit)
+	if options.MaxSize > 0 && options.MaxSize < limit {
+		limit = options.MaxSize
+	}
+	spool := &spooler{file: file, limit: limit}
+	members, err := readTarballMembers(archive,
+		newHeaderReader(form.kind, io.TeeReader(reader, spool)))
+	if cerr := file.Close(); cerr != nil {
+		spool.failed = true
+	}
+	if spool.failed {
+		os.Remove(file.Name())
+		return members, "", err
+	}
+	return members, file.Name(), err
+}
+
+// Returns true if members may be filtered out by name or time, so that
+// only some may be wanted.
+func (me *Options) selective() bool {
+	return len(me.Members) > 0 || len(me.Includes) > 0 ||
+		len(me.Excludes) > 0 || !me.Since.IsZero() || !me.Before.IsZero()
+}
+
+// spooler writes to its file until it fails or exceeds its limit after
+// which it silently discards everything (and stops using more space).
+type spooler struct {
+	file    *os.File
+	limit   int64
+	written int64
+	failed  bool
+}
+
+func (me *spooler) Write(p []byte) (int, error) {
+	if !me.failed {
+		me.written += int64(len(p))
+		if me.written > me.limit {
+			me.failed = true
+			_ = me.file.Truncate(0)
+		} else if _, err := me.file.Write(p); err != nil {
+			me.failed = true
+		}
+	}
+	return len(p), nil
+}
+
+func readTarballMembers(archive string, reader headerReader) ([]Member,
+	error) {
+	members := []Member{}
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil { // tar.Reader errors are sticky
+			return members, fmt.Errorf("failed to read from %s: %w",
+				archive, err)
+		}
+		members = append(members, tarMember(header))
+	}
+	return members, nil
+}
+
+func tarMember(header *tar.Header) Member {
+	return Member{Name: header.Name, Size: header.Size,
+		Mode: header.FileInfo().Mode(), ModTime: header.ModTime,
+		Header: header}
+}
+
+func openTarball(archive string, form format) (headerReader, closer,
+	error) {
+	reader, closer, err := openDecompressed(archive, form.compression)
+	if err != nil {
+		return nil, nil, err
+	}
+	return newHeaderReader(form.kind, reader), closer, nil
+}
@@ -0,0 +1,368 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tarEntry is an entry for writeTar(); a typeflag of 0 means a file and a
+// zero modified time means now.
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+	modified time.Time
+}
+
+// Writes an uncompressed tarball of the given entries.
+func writeTar(t *testing.T, archive string, entries []tarEntry) {
+	t.Helper()
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := tar.NewWriter(file)
+	for _, entry := range entries {
+		header := &tar.Header{Name: entry.name, Mode: 0o644,
+			Typeflag: entry.typeflag, Linkname: entry.linkname,
+			Size: int64(len(entry.content)), ModTime: entry.modified}
+		if header.ModTime.IsZero() {
+			header.ModTime = time.Now()
+		}
+		switch header.Typeflag {
+		case 0:
+			header.Typeflag = tar.TypeReg
+		case tar.TypeDir:
+			header.Mode = 0o755
+		}
+		if err := writer.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := writer.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUnpackTarSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "links.tar")
+	entries := []tarEntry{{name: "top/a.txt", content: "a"},
+		{name: "top/sub/", typeflag: tar.TypeDir}}
+	for _, link := range []struct{ name, target string }{
+		{"top/same", "a.txt"},
+		{"top/sub/up", "../a.txt"},
+		{"top/sub/dot", "."},
+		{"top/dangling", "missing.txt"},
+		{"top/sub/out", "../../../outside"},
+		{"top/abs", filepath.Join(dir, "outside")},
+	} {
+		entries = append(entries, tarEntry{name: link.name,
+			typeflag: tar.TypeSymlink, linkname: link.target})
+	}
+	writeTar(t, archive, entries)
+	dest := filepath.Join(dir, "out")
+	if err := Unpack(archive, dest, quiet()); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name   string
+		target string // "" if skipped
+	}{
+		{"same", "a.txt"},
+		{"sub/up", "../a.txt"},
+		{"sub/dot", "."},
+		{"dangling", "missing.txt"}, // inside, even though missing
+		{"sub/out", ""},             // outside
+		{"abs", ""},                 // absolute
+	} {
+		target, err := os.Readlink(filepath.Join(dest,
+			filepath.FromSlash(test.name)))
+		if test.target == "" {
+			if err == nil {
+				t.Errorf("%s: expected no link, got → %s", test.name,
+					target)
+			}
+		} else if err != nil || target != test.target {
+			t.Errorf("%s: expected → %s, got → %s %v", test.name,
+				test.target, target, err)
+		}
+	}
+	if got := readFile(t, filepath.Join(dest, "sub", "up")); got != "a" {
+		t.Errorf("expected %q via sub/up, got %q", "a", got)
+	}
+}
+
+func TestUnpackIncludeExclude(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "src.tar")
+	writeTar(t, archive, []tarEntry{
+		{name: "src/", typeflag: tar.TypeDir},
+		{name: "src/main.go", content: "m"},
+		{name: "src/main_test.go", content: "t"},
+		{name: "src/docs/", typeflag: tar.TypeDir},
+		{name: "src/docs/c.txt", content: "c"},
+		{name: "src/README", content: "r"},
+	})
+	for _, test := range []struct {
+		includes []string
+		excludes []string
+		want     []string
+	}{
+		{nil, nil, []string{"README", "docs", "docs/c.txt", "main.go",
+			"main_test.go"}},
+		{[]string{"src/*.go"}, nil, []string{"main.go", "main_test.go"}},
+		{[]string{"src/*.go"}, []string{"**/*_test.go"},
+			[]string{"main.go"}},
+		{nil, []string{"src/docs"}, []string{"README", "main.go",
+			"main_test.go"}},
+	} {
+		dest := t.TempDir()
+		options := quiet()
+		options.Includes, options.Excludes = test.includes, test.excludes
+		if err := Unpack(archive, dest, options); err != nil {
+			t.Fatal(err)
+		}
+		if got := treePaths(t, dest); !equalStrs(got, test.want) {
+			t.Errorf("%q %q: expected %q, got %q", test.includes,
+				test.excludes, test.want, got)
+		}
+	}
+}
+
+func TestUnpackStripComponents(t *testing.T) {
+	archive := filepath.Join(t.TempDir(), "release.tar")
+	writeTar(t, archive, []tarEntry{
+		{name: "project-1.2.3/", typeflag: tar.TypeDir},
+		{name: "project-1.2.3/README", content: "r"},
+		{name: "project-1.2.3/src/", typeflag: tar.TypeDir},
+		{name: "project-1.2.3/src/a.go", content: "a"},
+		{name: "project-1.2.3/src/b/", typeflag: tar.TypeDir},
+		{name: "project-1.2.3/src/b/c.go", content: "c"},
+	})
+	for _, test := range []struct {
+		strip int
+		want  []string
+	}{
+		{0, []string{"README", "src", "src/a.go", "src/b", "src/b/c.go"}},
+		{1, []string{"release", "release/README", "release/src",
+			"release/src/a.go", "release/src/b", "release/src/b/c.go"}},
+		{2, []string{"release", "release/a.go", "release/b",
+			"release/b/c.go"}},
+		{3, []string{"c.go"}},
+		{4, []string{}},
+	} {
+		dest := t.TempDir()
+		options := quiet()
+		options.StripComponents = test.strip
+		if err := Unpack(archive, dest, options); err != nil {
+			t.Fatal(err)
+		}
+		if got := treePaths(t, dest); !equalStrs(got, test.want) {
+			t.Errorf("%d: expected %q, got %q", test.strip, test.want, got)
+		}
+	}
+}
+
+// testdata/duplicates.tar.gz holds dup/a.txt three times (the second as
+// ./dup/a.txt) with contents first, second, and third, and dup/ twice.
+func TestUnpackDuplicates(t *testing.T) {
+	archive := filepath.Join("testdata", "duplicates.tar.gz")
+	for _, test := range []struct {
+		name     string
+		warn     bool
+		fail     bool
+		warnings int
+	}{
+		{"default", false, false, 0},
+		{"warn", true, false, 2},
+		{"fail", false, true, 0},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			var stderr bytes.Buffer
+			options := quiet()
+			options.Stderr = &stderr
+			options.WarnDuplicates = test.warn
+			options.NoDuplicates = test.fail
+			dest := filepath.Join(t.TempDir(), "out")
+			err := Unpack(archive, dest, options)
+			if test.fail {
+				if !errors.Is(err, ErrDuplicate) {
+					t.Errorf("expected %v, got %v", ErrDuplicate, err)
+				}
+				if got := treePaths(t, dest); len(got) != 0 {
+					t.Errorf("expected nothing to be unpacked, got %q", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Count(stderr.String(),
+				"duplicate member"); got != test.warnings {
+				t.Errorf("expected %d warnings, got %d: %q", test.warnings,
+					got, stderr.String())
+			}
+			if got := readFile(t, filepath.Join(dest,
+				"a.txt")); got != "third\n" {
+				t.Errorf("expected the last a.txt, got %q", got)
+			}
+			if got := readFile(t, filepath.Join(dest,
+				"b.txt")); got != "only\n" {
+				t.Errorf("expected %q, got %q", "only\n", got)
+			}
+		})
+	}
+}
+
+// Zip members are unpacked concurrently but the last of those with the
+// same path must still win.
+func TestUnpackZipDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "dup.zip")
+	var names []string
+	var modes []os.FileMode
+	for i := 0; i < 50; i++ {
+		names = append(names, "dup/a.txt")
+		modes = append(modes, 0o644)
+	}
+	names = append(names, "./dup/a.txt", "dup/b.txt")
+	modes = append(modes, 0o644, 0o644)
+	writeZip(t, archive, names, modes)
+	members, err := List(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := len(Duplicates(members)); got != 50 {
+		t.Errorf("expected 50 duplicates, got %d", got)
+	}
+	dest := filepath.Join(dir, "out")
+	options := quiet()
+	options.Jobs = 8
+	if err := Unpack(archive, dest, options); err != nil {
+		t.Fatal(err)
+	}
+	if got := readFile(t, filepath.Join(dest, "a.txt")); got != names[50] {
+		t.Errorf("expected %q, got %q", names[50], got)
+	}
+	options.NoDuplicates = true
+	if err := Unpack(archive, filepath.Join(dir, "none"),
+		options); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("expected %v, got %v", ErrDuplicate, err)
+	}
+}
+
+// Returns the name of a .tar.gz made by Create from the given files (see
+// makeTree()) inside a folder called "src".
+func makeTarball(t *testing.T, dir string, files map[string]string) string {
+	t.Helper()
+	src := filepath.Join(dir, "src")
+	makeTree(t, src, files)
+	archive := filepath.Join(dir, "src.tar.gz")
+	if err := Create(archive, []string{src}, quiet()); err != nil {
+		t.Fatal(err)
+	}
+	return archive
+}
+
+func TestSpooledTarballMembers(t *testing.T) {
+	dir := t.TempDir()
+	archive := makeTarball(t, dir, map[string]string{
+		"a.txt": strings.Repeat("a", 5000), "b/c.txt": "c"})
+	form, err := archiveFormat(archive, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	temp := filepath.Join(dir, "temp")
+	if err := os.Mkdir(temp, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TMPDIR", temp)
+	other := filepath.Join(dir, "other")
+	if err := os.Mkdir(other, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name    string
+		options Options
+		spooled bool
+	}{
+		{"all", Options{}, true},
+		{"temp-folder", Options{TempFolder: other}, true},
+		{"members", Options{Members: []string{"src/a.txt"}}, false},
+		{"includes", Options{Includes: []string{"*.txt"}}, false},
+		{"excludes", Options{Excludes: []string{"b"}}, false},
+		{"max-size", Options{MaxSize: 1000}, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			members, spooled, err := spooledTarballMembers(archive, form,
+				&test.options)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if spooled != "" {
+				defer os.Remove(spooled)
+			}
+			if len(members) != 4 {
+				t.Errorf("expected 4 members, got %q", memberNames(members))
+			}
+			if !test.spooled {
+				if spooled != "" {
+					t.Errorf("expected no spool, got %s", spooled)
+				}
+				if paths := treePaths(t, temp); len(paths) != 0 {
+					t.Errorf("expected no temporary files, got %q", paths)
+				}
+			} else if folder := test.options.TempFolder; folder == "" &&
+				filepath.Dir(spooled) != temp ||
+				folder != "" && filepath.Dir(spooled) != folder {
+				t.Errorf("expected a spool in %s or %q, got %q", temp,
+					folder, spooled)
+			}
+		})
+	}
+}
+
+func TestUnpackTarballLeavesNoSpool(t *testing.T) {
+	dir := t.TempDir()
+	archive := makeTarball(t, dir, map[string]string{"a.txt": "a",
+		"b/c.txt": "c"})
+	temp := filepath.Join(dir, "temp")
+	if err := os.Mkdir(temp, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TMPDIR", temp)
+	for _, includes := range [][]string{nil, {"b"}} {
+		options := quiet()
+		options.Includes = includes
+		dest := filepath.Join(dir, "out")
+		if err := Unpack(archive, dest, options); err != nil {
+			t.Fatal(err)
+		}
+		if paths := treePaths(t, temp); len(paths) != 0 {
+			t.Errorf("expected no temporary files, got %q", paths)
+		}
+		for _, path := range treePaths(t, dest) {
+			if strings.Contains(path, ".unz-") || strings.HasSuffix(path,
+				".tar") {
+				t.Errorf("unexpected file in destination: %s", path)
+			}
+		}
+		if err := os.RemoveAll(dest); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
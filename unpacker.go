@@ -0,0 +1,979 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// unpacker holds the state needed while unpacking a single archive. Its
+// methods may be called concurrently (see unpackZip()).
+type unpacker struct {
+	options *Options
+	archive string
+	folder  string
+	wrapper bool              // if true strip the single top-level folder
+	owner   bool              // if true set owners (PreserveOwner and root)
+	created bool              // if true folder was created for the archive
+	removed bool              // if true folder was removed (see failed())
+	flat    map[string]string // if Flatten, stripped names → basenames
+	mutex   sync.Mutex        // guards dirs, links, nested, written, output
+	written int64             // total bytes written (for MaxSize)
+	skipped [skipReasons]int  // the number of members skipped per reason
+	dirs    []dirInfo
+	links   []hardlink // hard links whose targets weren't yet unpacked
+	copies  []hardlink // if DerefLinks, soft links to copy at the end
+	buffers sync.Pool  // copy buffers of BufferSize bytes (see writeFile())
+}
+
+// skipReason is why a member wasn't unpacked.
+type skipReason uint8
+
+const (
+	absolutePath skipReason = iota
+	unsafePath
+	riskySymlink
+	failedSymlink
+	riskyHardlink
+	failedHardlink
+	strippedTarget
+	missingTarget
+	special
+	unsupported
+	existing
+	brokenMember
+	longName
+	existingLink
+	missingLinkTarget
+	uncopiedLink
+	alreadyWritten
+	skipReasons // the number of reasons
+)
+
+// The singular and plural descriptions of each skipReason.
+var skipNames = [skipReasons][2]string{
+	{"absolute path", "absolute paths"},
+	{"unsafe path", "unsafe paths"},
+	{"risky soft link", "risky soft links"},
+	{"soft link that couldn't be created",
+		"soft links that couldn't be created"},
+	{"risky hard link", "risky hard links"},
+	{"hard link that couldn't be created",
+		"hard links that couldn't be created"},
+	{"hard link to a stripped member", "hard links to stripped members"},
+	{"hard link to a missing member", "hard links to missing members"},
+	{"device or FIFO", "devices or FIFOs"},
+	{"unsupported member type", "unsupported member types"},
+	{"existing file", "existing files"},
+	{"broken member", "broken members"},
+	{"name that's too long", "names that are too long"},
+	{"existing soft link", "existing soft links"},
+	{"soft link to a missing member", "soft links to missing members"},
+	{"soft link that couldn't be copied",
+		"soft links that couldn't be copied"},
+	{"file already unpacked", "files already unpacked"},
+}
+
+type hardlink struct {
+	name   string
+	target string
+}
+
+type dirInfo struct {
+	name    string
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func newUnpacker(archive, folder string, options *Options) *unpacker {
+	unpacker := &unpacker{options: options, archive: archive,
+		folder: folder}
+	size := options.BufferSize
+	if size <= 0 {
+		size = DefaultBufferSize
+	}
+	unpacker.buffers.New = func() any {
+		buffer := make([]byte, size)
+		return &buffer
+	}
+	return unpacker
+}
+
+// Returns an unpacker for an archive with the given members. If the
+// wanted (and stripped) member names are all inside a single top-level folder
+// (e.g., GitHub's "repo-abcdef1/") that folder is stripped (unless
+// KeepWrapper) and its contents are unpacked directly into dest. Otherwise
+// if the names share a single root they are unpacked into dest, or failing
+// that, into a new subfolder of dest named after the archive. Named
+// Members and flattened members are always unpacked directly into dest.
+func newArchiveUnpacker(archive, dest string, members []Member,
+	options *Options) (*unpacker, error) {
+	unpacker := newUnpacker(archive, dest, options)
+	if options.Flatten != NoFlatten {
+		flat, err := options.flatNames(members)
+		if err != nil {
+			return nil, fmt.Errorf("failed to flatten %s: %w", archive, err)
+		}
+		unpacker.flat = flat
+		return unpacker, nil
+	}
+	if len(options.Members) > 0 {
+		return unpacker, nil
+	}
+	names := options.unpackNames(members)
+	if !options.KeepWrapper && isWrapped(names) {
+		unpacker.wrapper = true
+	} else if !hasSingleRoot(names) {
+		unpacker.folder = filepath.Join(dest, archiveFolder(archive))
+		_, err := os.Lstat(unpacker.folder)
+		unpacker.created = err != nil
+		if err := makeFolder(unpacker.folder, options.Force); err != nil {
+			return nil, err
+		}
+	}
+	return unpacker, nil
+}
+
+// Creates the folder (and any missing parents). If a file (or anything
+// else that isn't a folder) already has the folder's name, it is removed
+// if force is true; otherwise an ErrNotFolder error is returned.
+func makeFolder(folder string, force bool) error {
+	if info, err := os.Stat(folder); err == nil && !info.IsDir() {
+		if !force {
+			return fmt.Errorf("cannot create output folder %s: %w", folder,
+				ErrNotFolder)
+		}
+		if err := os.Remove(folder); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", folder, err)
+		}
+	}
+	if err := os.MkdirAll(folder, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", folder, err)
+	}
+	return nil
+}
+
+// Returns the name with StripComponents leading path components removed,
+// and if the archive has a wrapper folder, that too; or if flattening,
+// the name's (possibly renamed) basename. Returns "" and false if there's
+// nothing left (or if flattening and the name is a folder's).
+func (me *unpacker) stripped(name string) (string, bool) {
+	name, ok := me.options.stripped(name)
+	if ok && me.wrapper {
+		_, name, ok = strings.Cut(strings.TrimPrefix(
+			filepath.ToSlash(filepath.Clean(name)), "./"), "/")
+	}
+	if ok && me.flat != nil {
+		name, ok = me.flat[name]
+	}
+	return name, ok && name != ""
+}
+
+// Writes the message to Stdout if Verbose.
+func (me *unpacker) report(format string, args ...any) {
+	if me.options.Verbose {
+		me.mutex.Lock()
+		defer me.mutex.Unlock()
+		fmt.Fprintln(me.options.Stdout, me.options.shown(fmt.Sprintf(
+			format, args...)))
+	}
+}
+
+// Warns that a member is being skipped and counts it for summarize().
+func (me *unpacker) skip(reason skipReason, format string, args ...any) {
+	me.warn(format, args...)
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.skipped[reason]++
+}
+
+// Writes a summary of the skipped members (if any) to Stderr, e.g.,
+// "skipped 3 members of x.tar: 1 absolute path, 2 risky hard links".
+func (me *unpacker) summarize() {
+	total := 0
+	details := make([]string, 0, skipReasons)
+	for reason, count := range me.skipped {
+		if count > 0 {
+			total += count
+			name := skipNames[reason][1]
+			if count == 1 {
+				name = skipNames[reason][0]
+			}
+			details = append(details, fmt.Sprintf("%d %s", count, name))
+		}
+	}
+	if total > 0 {
+		members := "members"
+		if total == 1 {
+			members = "member"
+		}
+		me.warn("skipped %d %s of %s: %s", total, members, me.archive,
+			strings.Join(details, ", "))
+	}
+}
+
+// Writes the message to Stderr.
+func (me *unpacker) warn(format string, args ...any) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	fmt.Fprintln(me.options.Stderr, me.options.shown(fmt.Sprintf(format,
+		args...)))
+}
+
+// Returns the path the member should be unpacked to and true, or warns
+// why the member is being skipped and returns false. For a member that
+// isn't a folder, a soft link at the path itself is allowed since it is
+// removed rather than written via (see shouldWrite()).
+func (me *unpacker) memberPath(name string, folder bool) (string, bool) {
+	if reason, unsafe := riskyPath(name); unsafe {
+		if reason == absolutePath {
+			me.skip(absolutePath, "skipping risky absolute path member %s",
+				name)
+		} else {
+			me.skip(unsafePath, "skipping unsafe path %s", name)
+		}
+		return "", false
+	}
+	path, _ := safeJoin(me.folder, name)
+	fitted, problem := me.fitPath(path)
+	if problem != "" {
+		me.skip(longName, "skipping %s since %s", name, problem)
+		return "", false
+	}
+	if fitted != path {
+		me.warn("renamed %s to %s since its name is too long", name,
+			fitted)
+		path = fitted
+	}
+	checked := path
+	if !folder {
+		checked = filepath.Dir(path)
+	}
+	if !me.linksInside(checked) {
+		me.skip(unsafePath, "skipping unsafe path %s which is reached "+
+			"via a soft link to outside %s", name, me.folder)
+		return path, false
+	}
+	return path, true
+}
+
+// Returns absolutePath and true if the member name is absolute, or
+// unsafePath and true if it would escape the folder it is unpacked into
+// (e.g., "../../etc/passwd"), or false if it is safe.
+func riskyPath(name string) (skipReason, bool) {
+	name = filepath.Clean(name)
+	if filepath.IsAbs(name) {
+		return absolutePath, true
+	}
+	if _, ok := safeJoin(".", name); !ok {
+		return unsafePath, true
+	}
+	return 0, false
+}
+
+// UnsafeReason returns why the member with the given (stripped) name
+// would be skipped when unpacked, e.g., "absolute path", or "" if it
+// wouldn't be. These are the checks Unpack() makes on every member's
+// name, but not those that depend on the destination folder (whether the
+// whole path is too long, or is reached via a soft link to outside it).
+func (me *Options) UnsafeReason(name string) string {
+	if reason, unsafe := riskyPath(name); unsafe {
+		return skipNames[reason][0]
+	}
+	if !me.TruncateNames {
+		limit := me.nameLimit()
+		for _, part := range strings.Split(filepath.Clean(name),
+			string(filepath.Separator)) {
+			if len(part) > limit {
+				return skipNames[longName][0]
+			}
+		}
+	}
+	return ""
+}
+
+// Returns MaxNameLength or if that's 0 (or less), DefaultMaxNameLength.
+func (me *Options) nameLimit() int {
+	if me.MaxNameLength <= 0 {
+		return DefaultMaxNameLength
+	}
+	return me.MaxNameLength
+}
+
+// The longest path in bytes that may be passed to the OS: PATH_MAX (less
+// the terminating NUL) on Linux and on macOS and the BSDs, and on Windows
+// the limit for the \\?\ paths that Go uses for long paths.
+var maxPathLength = func() int {
+	switch runtime.GOOS {
+	case "linux":
+		return 4095
+	case "windows":
+		return 32767
+	default:
+		return 1023
+	}
+}()
+
+// Returns the path with any of its names (below the unpacker's folder)
+// that are longer than MaxNameLength bytes shortened by truncateName() if
+// TruncateNames, and "". Otherwise, or if the whole path is too long for
+// the OS, returns the path and why it won't fit.
+func (me *unpacker) fitPath(path string) (string, string) {
+	limit := me.options.nameLimit()
+	rel, err := filepath.Rel(me.folder, path)
+	if err != nil {
+		return path, "" // can't happen: safeJoin() made the path
+	}
+	parts := strings.Split(rel, string(filepath.Separator))
+	renamed := false
+	for i, part := range parts {
+		if len(part) > limit {
+			if !me.options.TruncateNames {
+				return path, fmt.Sprintf("its name %.20s... is longer "+
+					"than %d bytes", part, limit)
+			}
+			parts[i] = truncateName(part, limit)
+			renamed = true
+		}
+	}
+	if renamed {
+		path = filepath.Join(me.folder, filepath.Join(parts...))
+	}
+	if len(path) > maxPathLength {
+		return path, fmt.Sprintf("its path is longer than %d bytes",
+			maxPathLength)
+	}
+	return path, ""
+}
+
+// Returns the name shortened to limit bytes by replacing the end of its
+// stem with "~" and the first 16 hex digits of the name's SHA-256, so the
+// same name is always shortened the same way (and different names almost
+// certainly differently). A short extension (e.g., ".txt") is kept, and
+// the name is only cut between UTF-8 characters.
+func truncateName(name string, limit int) string {
+	sum := sha256.Sum256([]byte(name))
+	tag := "~" + hex.EncodeToString(sum[:8])
+	ext := filepath.Ext(name)
+	if len(ext) > limit/8 {
+		ext = ""
+	}
+	keep := limit - len(tag) - len(ext)
+	if keep < 0 {
+		return tag[1 : limit+1] // limit is tiny
+	}
+	for keep > 0 && !utf8.RuneStart(name[keep]) {
+		keep--
+	}
+	return name[:keep] + tag + ext
+}
+
+// Returns true if every existing file or folder on the path from the
+// unpacker's folder to the path (inclusive) that is a soft link resolves
+// to somewhere inside the folder. This stops members being written
+// (created, truncated, or chmod-ed) outside the folder via a link that
+// was unpacked earlier (e.g., data → /etc followed by data/passwd, or
+// a → . followed by a/b → .. and b/x) or was already in the folder.
+// Dangling links count as outside since writing via them would create
+// their targets.
+func (me *unpacker) linksInside(path string) bool {
+	rel, err := filepath.Rel(me.folder, path)
+	if err != nil {
+		return false
+	}
+	if rel == "." {
+		return true
+	}
+	var root string
+	current := me.folder
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if err != nil {
+			return true // the rest doesn't exist yet
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+		if root == "" {
+			if root, err = filepath.EvalSymlinks(me.folder); err != nil {
+				return false
+			}
+		}
+		target, err := filepath.EvalSymlinks(current)
+		if err != nil || !isInside(root, target) {
+			return false
+		}
+	}
+	return true
+}
+
+// The target is checked both as given and relative to the link's folder
+// with any soft links resolved (e.g., a → . makes a/b → .. point outside).
+func (me *unpacker) unpackSymlink(name, target string) error {
+	risky := filepath.IsAbs(target) || !isInside(me.folder,
+		filepath.Join(filepath.Dir(name), target))
+	if !risky {
+		if err := makeParent(name); err != nil {
+			return err
+		}
+		root, rerr := filepath.EvalSymlinks(me.folder)
+		parent, perr := filepath.EvalSymlinks(filepath.Dir(name))
+		risky = rerr != nil || perr != nil || !isInside(root,
+			filepath.Join(parent, target))
+	}
+	if risky {
+		if me.options.DerefLinks && me.options.StrictLinks {
+			return fmt.Errorf("failed to copy soft link %s → %s: %w",
+				name, target, ErrOutsideLink)
+		}
+		me.skip(riskySymlink, "skipping risky soft link %s → %s", name,
+			target)
+		return nil // try next one
+	}
+	if me.options.DerefLinks {
+		me.mutex.Lock()
+		me.copies = append(me.copies, hardlink{name,
+			filepath.Join(filepath.Dir(name), target)})
+		me.mutex.Unlock()
+		return nil // copy its target once everything has been unpacked
+	}
+	_ = os.Remove(name) // in case it already exists
+	if err := symlink(target, name); err != nil {
+		// e.g., on Windows without the necessary privileges
+		me.skip(failedSymlink, "skipping soft link %s → %s which "+
+			"couldn't be created: %s", name, target, err)
+		return nil // try next one
+	}
+	me.report("created soft link %s → %s", name, target)
+	me.recordCreated(name)
+	return nil
+}
+
+// symlink creates soft links; tests replace it to simulate filesystems
+// (e.g., FAT) that don't support them.
+var symlink = os.Symlink
+
+func (me *unpacker) unpackHardlink(name, target string) error {
+	target, ok := safeJoin(me.folder, target)
+	if ok { // the target was renamed if it was too long
+		var problem string
+		if target, problem = me.fitPath(target); problem != "" {
+			me.skip(longName, "skipping hard link %s → %s since the "+
+				"target is too long: %s", name, target, problem)
+			return nil // try next one
+		}
+	}
+	if !ok || !me.linksInside(target) {
+		me.skip(riskyHardlink, "skipping risky hard link %s → %s", name,
+			target)
+		return nil // try next one
+	}
+	if _, err := os.Lstat(target); err != nil {
+		me.mutex.Lock()
+		me.links = append(me.links, hardlink{name, target})
+		me.mutex.Unlock()
+		return nil // create it once the target has been unpacked
+	}
+	return me.createHardlink(name, target)
+}
+
+func (me *unpacker) createHardlink(name, target string) error {
+	if err := makeParent(name); err != nil {
+		return err
+	}
+	_ = os.Remove(name) // in case it already exists
+	if err := os.Link(target, name); err != nil {
+		me.skip(failedHardlink, "skipping hard link %s → %s which "+
+			"couldn't be created: %s", name, target, err)
+		return nil // try next one
+	}
+	me.report("created hard link %s → %s", name, target)
+	me.recordCreated(name)
+	return nil
+}
+
+// Replaces each of the soft links deferred by DerefLinks with a copy of
+// its target. Since a target may itself be a deferred soft link, copies
+// are made in passes until no more can be made.
+func (me *unpacker) copyLinks() {
+	pending := me.copies
+	for progress := true; progress && len(pending) > 0; {
+		var waiting []hardlink
+		for _, link := range pending {
+			if _, err := os.Lstat(link.target); err != nil {
+				waiting = append(waiting, link)
+			} else {
+				me.copyLink(link.name, link.target)
+			}
+		}
+		progress = len(waiting) < len(pending)
+		pending = waiting
+	}
+	for _, link := range pending {
+		me.skip(missingLinkTarget, "skipping soft link %s → %s whose "+
+			"target isn't in the archive", link.name, link.target)
+	}
+}
+
+// Only a regular file can be copied; a soft link to a folder is skipped.
+func (me *unpacker) copyLink(name, target string) {
+	info, err := os.Lstat(target)
+	if err == nil && !info.Mode().IsRegular() {
+		err = errors.New("it isn't a regular file")
+	} else if err == nil && !me.linksInside(target) {
+		err = errors.New("it is outside the archive's folder")
+	}
+	var source *os.File
+	if err == nil {
+		source, err = os.Open(target)
+	}
+	if err == nil {
+		_ = os.Remove(name) // in case it already exists
+		err = me.writeFile(source, name, info.Mode().Perm(), info.Size())
+		source.Close()
+	}
+	if err != nil {
+		me.skip(uncopiedLink, "skipping soft link %s → %s whose target "+
+			"couldn't be copied: %s", name, target, err)
+		return
+	}
+	me.setMode(name, info.Mode().Perm())
+	me.setTime(name, info.ModTime())
+	me.report("created file %s (copied from soft link target %s)", name,
+		target)
+	me.recordCreated(name)
+}
+
+func makeParent(name string) error {
+	parent := filepath.Dir(name)
+	if err := os.MkdirAll(parent, os.ModePerm); err != nil {
+		return fmt.Errorf("failed to create folder %s: %w", parent, err)
+	}
+	return nil
+}
+
+// Writes the reader's bytes to the named file, reporting progress if the
+// options have a Progress function. The total is the expected size (or -1
+// if unknown).
+func (me *unpacker) writeFile(reader io.Reader, name string,
+	mode os.FileMode, total int64) error {
+	file, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC,
+		mode)
+	if err != nil {
+		return err
+	}
+	reader = brokenReader{reader}
+	if me.options.Context != nil { // outside brokenReader: not KeepBroken
+		reader = contextReader{reader: reader, ctx: me.options.Context}
+	}
+	if me.options.MaxSize > 0 || me.options.MaxFileSize > 0 {
+		reader = &limitedReader{reader: reader, unpacker: me}
+	}
+	if me.options.Progress != nil {
+		reader = &progressReader{reader: reader, name: name, total: total,
+			progress: me.progress}
+	}
+	buffer := me.buffers.Get().(*[]byte) // one per concurrent job
+	// Hide the file's ReadFrom() since io.CopyBuffer() would use it and it
+	// ignores the buffer (copying 32KB at a time).
+	done, err := io.CopyBuffer(struct{ io.Writer }{file}, reader, *buffer)
+	me.buffers.Put(buffer)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		// Don't leave a partial (or garbage) file
+		if rerr := os.Remove(name); rerr == nil {
+			me.warn("removed partly written file %s", name)
+		}
+	}
+	if err == nil && me.options.Progress != nil && (total == -1 ||
+		done == 0) {
+		me.progress(name, done, done) // there may be no final Read
+	}
+	if err == nil && me.options.Depth > 0 && isArchiveName(name) {
+		me.mutex.Lock()
+		me.options.nested = append(me.options.nested, name)
+		me.mutex.Unlock()
+	}
+	return err
+}
+
+// brokenError is an error reading an archive member (rather than writing
+// what was read), e.g., because the archive is corrupt.
+type brokenError struct {
+	err error
+}
+
+func (me brokenError) Error() string { return me.err.Error() }
+func (me brokenError) Unwrap() error { return me.err }
+
+func isBroken(err error) bool {
+	var broken brokenError
+	return errors.As(err, &broken)
+}
+
+// brokenReader returns any error (other than io.EOF) from its reader as a
+// brokenError.
+type brokenReader struct {
+	reader io.Reader
+}
+
+func (me brokenReader) Read(p []byte) (int, error) {
+	n, err := me.reader.Read(p)
+	if err != nil && err != io.EOF {
+		err = brokenError{err}
+	}
+	return n, err
+}
+
+// Returns true if KeepBroken and err is a brokenError, in which case the
+// member is skipped with a warning.
+func (me *unpacker) keptBroken(member string, err error) bool {
+	var broken brokenError
+	if !me.options.KeepBroken || !errors.As(err, &broken) {
+		return false
+	}
+	me.skip(brokenMember, "skipping broken member %s of %s: %s", member,
+		me.archive, broken.err)
+	return true
+}
+
+// Returns an ErrBroken error if any members were skipped by keptBroken(),
+// or nil.
+func (me *unpacker) brokenErr() error {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	switch n := me.skipped[brokenMember]; n {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%s has a broken member: %w", me.archive,
+			ErrBroken)
+	default:
+		return fmt.Errorf("%s has %d broken members: %w", me.archive, n,
+			ErrBroken)
+	}
+}
+
+// Calls the Created function (if any; it need not be safe for concurrent
+// use) with the path that was created.
+func (me *unpacker) recordCreated(name string) {
+	if me.options.Created != nil {
+		me.mutex.Lock()
+		defer me.mutex.Unlock()
+		me.options.Created(me.options.shown(name))
+	}
+}
+
+// Calls the Progress function (which need not be safe for concurrent use).
+func (me *unpacker) progress(name string, done, total int64) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.options.Progress(me.options.shown(name), done, total)
+}
+
+// limitedReader fails with ErrMaxFileSize if more than MaxFileSize bytes
+// are read through it, or with ErrMaxSize if the unpacker's total written
+// would exceed MaxSize.
+type limitedReader struct {
+	reader   io.Reader
+	unpacker *unpacker
+	done     int64
+}
+
+func (me *limitedReader) Read(p []byte) (int, error) {
+	n, err := me.reader.Read(p)
+	me.done += int64(n)
+	options := me.unpacker.options
+	if options.MaxFileSize > 0 && me.done > options.MaxFileSize {
+		return 0, ErrMaxFileSize
+	}
+	if options.MaxSize > 0 && me.unpacker.addWritten(int64(n)) >
+		options.MaxSize {
+		return 0, ErrMaxSize
+	}
+	return n, err
+}
+
+// contextReader fails with its context's error once the context is done.
+type contextReader struct {
+	reader io.Reader
+	ctx    context.Context
+}
+
+func (me contextReader) Read(p []byte) (int, error) {
+	if err := me.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return me.reader.Read(p)
+}
+
+// Returns the Context's error if it is done, or nil (including if there's
+// no Context).
+func (me *Options) canceled() error {
+	if me.Context == nil {
+		return nil
+	}
+	return me.Context.Err()
+}
+
+// Adds n to the total written and returns the new total.
+func (me *unpacker) addWritten(n int64) int64 {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.written += n
+	return me.written
+}
+
+// progressReader counts the bytes read through it and reports the count
+// after every read.
+type progressReader struct {
+	reader   io.Reader
+	name     string
+	done     int64
+	total    int64
+	progress ProgressFunc
+}
+
+func (me *progressReader) Read(p []byte) (int, error) {
+	n, err := me.reader.Read(p)
+	if n > 0 {
+		me.done += int64(n)
+		me.progress(me.name, me.done, me.total)
+	}
+	return n, err
+}
+
+// Returns true if name doesn't exist or if it does and the overwrite policy
+// says it should be replaced by a member with the given modTime. If name
+// is a soft link that should be replaced, it is removed (so that writing
+// creates a new file rather than following the link to wherever it
+// points), unless NoClobberLinks in which case the member is skipped.
+// Returns true if the Overwrite policy is Resume and the named file was
+// fully written by an earlier unpacking, i.e., it is a regular file with
+// the member's size and (to the second) modification time. A file that
+// was only partly written (e.g., because unz was killed) has the wrong
+// size, or if not, the wrong time (since the time is set last), so it is
+// written again. If NoPreserveTimes (or the member has no time), only the
+// size can be compared. Files already written are counted as skipped but
+// only reported if Verbose since there may be very many.
+func (me *unpacker) alreadyWritten(name string, modTime time.Time,
+	size int64) bool {
+	if me.options.Overwrite != Resume || size < 0 {
+		return false
+	}
+	info, err := os.Lstat(name)
+	if err != nil || !info.Mode().IsRegular() || info.Size() != size ||
+		(!me.options.NoPreserveTimes && !modTime.IsZero() &&
+			info.ModTime().Unix() != modTime.Unix()) {
+		return false
+	}
+	me.report("skipping already unpacked %s", name)
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.skipped[alreadyWritten]++
+	return true
+}
+
+func (me *unpacker) shouldWrite(name string, modTime time.Time) bool {
+	info, err := os.Lstat(name)
+	if err != nil {
+		return true
+	}
+	switch me.options.Overwrite {
+	case KeepNewer:
+		if !modTime.After(info.ModTime()) {
+			me.skip(existing, "skipping existing newer %s", name)
+			return false
+		}
+	case SkipExisting:
+		me.skip(existing, "skipping existing %s", name)
+		return false
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		if me.options.NoClobberLinks {
+			me.skip(existingLink, "skipping %s which is an existing soft "+
+				"link", name)
+			return false
+		}
+		if err := os.Remove(name); err != nil {
+			me.skip(existingLink, "skipping %s whose existing soft link "+
+				"couldn't be removed: %s", name, err)
+			return false
+		}
+		me.report("removed existing soft link %s", name)
+	}
+	return true
+}
+
+// Returns the permission bits to use for a file with the given mode. Only
+// a regular file's archived permissions are used: a member of any other
+// type that is written as a file (e.g., a zip member with a device's mode)
+// gets the default mode.
+func (me *unpacker) fileMode(mode os.FileMode) os.FileMode {
+	if me.options.NoPreservePerms || !mode.IsRegular() {
+		return 0o644
+	}
+	return mode.Perm()
+}
+
+// Folders' modes and times are set by finish() since a read-only folder
+// can't have children created inside it, and creating children would
+// change its modification time.
+func (me *unpacker) addDir(name string, mode os.FileMode,
+	modTime time.Time) {
+	me.mutex.Lock()
+	defer me.mutex.Unlock()
+	me.dirs = append(me.dirs, dirInfo{name, mode.Perm(), modTime})
+}
+
+// Sets the mode explicitly since the mode given when creating a file is
+// subject to the umask.
+func (me *unpacker) setMode(name string, mode os.FileMode) {
+	if err := os.Chmod(name, mode); err != nil {
+		me.warn("failed to set permissions for %s: %s", name, err)
+	}
+}
+
+func (me *unpacker) setTime(name string, modTime time.Time) {
+	if !me.options.NoPreserveTimes && !modTime.IsZero() {
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			me.warn("failed to set time for %s: %s", name, err)
+		}
+	}
+}
+
+// Must be called if unpacking fails; returns err. If Cleanup and the
+// unpacker created a subfolder for the archive, removes the subfolder.
+func (me *unpacker) failed(err error) error {
+	if me.options.Cleanup && me.created {
+		if rerr := os.RemoveAll(me.folder); rerr != nil {
+			me.warn("failed to remove partly unpacked folder %s: %s",
+				me.folder, rerr)
+		} else {
+			me.removed = true
+			me.warn("removed partly unpacked folder %s", me.folder)
+		}
+	}
+	return err
+}
+
+// Must be called after all the archive's members have been unpacked.
+// Deepest folders are done first, and then any skipped members are
+// summarized.
+func (me *unpacker) finish() {
+	if me.removed {
+		return
+	}
+	me.copyLinks() // first since hard links may be to these copies
+	for _, link := range me.links {
+		if _, err := os.Lstat(link.target); err != nil {
+			me.skip(missingTarget, "skipping hard link %s → %s whose "+
+				"target isn't in the archive", link.name, link.target)
+		} else if err := me.createHardlink(link.name,
+			link.target); err != nil {
+			me.warn("%s", err)
+		}
+	}
+	for i := len(me.dirs) - 1; i >= 0; i-- {
+		dir := me.dirs[i]
+		mode := dir.mode
+		if me.options.NoPreservePerms {
+			mode = 0o755
+		}
+		if mode != 0 {
+			me.setMode(dir.name, mode)
+		}
+		me.setTime(dir.name, dir.modTime)
+	}
+	me.summarize()
+}
+
+// Returns true if all the names share the same first path component (or
+// there's only one name).
+func hasSingleRoot(names []string) bool {
+	root := ""
+	for _, name := range names {
+		name = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(name)),
+			"./")
+		first, _, _ := strings.Cut(name, "/")
+		if root == "" {
+			root = first
+		} else if first != root {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns true if all the names share the same first path component and
+// at least one name is inside it (so it must be a folder, not a file).
+func isWrapped(names []string) bool {
+	if !hasSingleRoot(names) {
+		return false
+	}
+	for _, name := range names {
+		name = strings.TrimPrefix(filepath.ToSlash(filepath.Clean(name)),
+			"./")
+		if strings.Contains(name, "/") {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the archive's basename without its archive suffix, e.g.,
+// "/tmp/project.tar.gz" → "project".
+func archiveFolder(archive string) string {
+	name := filepath.Base(archive)
+	for _, suffix := range []string{".TAR.GZ", ".TAR.BZ2", ".TAR.XZ",
+		".TAR.ZST", ".TAR.LZ4", ".TAR.BR", ".TAR.Z", ".TGZ", ".TZST",
+		".TLZ4", ".TBR", ".TAZ", ".TAR", ".CPIO.GZ", ".CPIO.BZ2", ".CPIO.XZ",
+		".CPIO.ZST", ".CPIO.LZ4", ".CPIO.BR", ".CPIO.Z", ".CPIO", ".DEB",
+		".UDEB", ".AR", ".A", ".ZIP", ".7Z"} {
+		if hasSuffixFold(name, suffix) {
+			return name[:len(name)-len(suffix)]
+		}
+	}
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// Returns root joined with the (relative) name and true, or the cleaned
+// name and false if the name is absolute or would escape root, e.g.,
+// "../../etc/passwd".
+func safeJoin(root, name string) (string, bool) {
+	name = filepath.Clean(name)
+	if filepath.IsAbs(name) {
+		return name, false
+	}
+	path := filepath.Join(root, name)
+	if !isInside(root, path) {
+		return name, false
+	}
+	return path, true
+}
+
+// Returns true if path is root or is inside root.
+func isInside(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel != ".." && !strings.HasPrefix(rel,
+		".."+string(filepath.Separator))
+}
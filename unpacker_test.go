@@ -0,0 +1,550 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"archive/tar"
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSafeJoin(t *testing.T) {
+	root := filepath.FromSlash("/dest")
+	for _, test := range []struct {
+		name string
+		path string // slash-separated
+		ok   bool
+	}{
+		{"a.txt", "/dest/a.txt", true},
+		{"a/b/../c.txt", "/dest/a/c.txt", true},
+		{"./a.txt", "/dest/a.txt", true},
+		{"..a.txt", "/dest/..a.txt", true},
+		{".", "/dest", true},
+		{"../x", "../x", false},
+		{"..", "..", false},
+		{"a/../../x", "../x", false},
+		{"a/../../dest/x", "/dest/x", true}, // back inside root
+		{"/abs", "/abs", false},
+		{"/dest/a.txt", "/dest/a.txt", false},
+	} {
+		path, ok := safeJoin(root, filepath.FromSlash(test.name))
+		if filepath.ToSlash(path) != test.path || ok != test.ok {
+			t.Errorf("%s: expected %s %t, got %s %t", test.name, test.path,
+				test.ok, path, ok)
+		}
+	}
+}
+
+func TestRiskyPath(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		reason string // "" for safe
+	}{
+		{"a.txt", ""},
+		{"a/../b.txt", ""},
+		{"../x", "unsafe path"},
+		{"a/../../x", "unsafe path"},
+		{"a/../../dest/x", "unsafe path"}, // whatever the destination
+		{"/abs", "absolute path"},
+		{"/abs/../x", "absolute path"},
+	} {
+		var options Options
+		if reason := options.UnsafeReason(
+			filepath.FromSlash(test.name)); reason != test.reason {
+			t.Errorf("%s: expected %q, got %q", test.name, test.reason,
+				reason)
+		}
+	}
+}
+
+// Unpacks crafted tar and zip archives whose members try to escape the
+// destination, which must be skipped (with a report) while the safe
+// members are unpacked (into a subfolder since the names don't share a
+// top-level folder).
+func TestUnpackTraversal(t *testing.T) {
+	for _, format := range []string{"tar", "zip"} {
+		t.Run(format, func(t *testing.T) {
+			base := t.TempDir()
+			names := []string{"top/ok.txt", "../x", "top/../../y",
+				filepath.ToSlash(filepath.Join(base, "abs")),
+				"top/a/../b.txt"}
+			archive := filepath.Join(base, "crafted."+format)
+			if format == "tar" {
+				entries := make([]tarEntry, 0, len(names))
+				for _, name := range names {
+					entries = append(entries, tarEntry{name: name,
+						content: name})
+				}
+				writeTar(t, archive, entries)
+			} else {
+				modes := make([]os.FileMode, len(names))
+				for i := range modes {
+					modes[i] = 0o644
+				}
+				writeZip(t, archive, names, modes)
+			}
+			dest := filepath.Join(base, "out", "dest")
+			var report bytes.Buffer
+			options := Options{Stdout: &report, Stderr: &report}
+			if err := Unpack(archive, dest, options); err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"crafted." + format, "out", "out/dest",
+				"out/dest/crafted", "out/dest/crafted/top",
+				"out/dest/crafted/top/b.txt", "out/dest/crafted/top/ok.txt"}
+			if got := treePaths(t, base); !equalStrs(got, want) {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+			for _, name := range names[1:4] {
+				if !strings.Contains(report.String(), name) {
+					t.Errorf("expected %s to be reported in %q", name,
+						report.String())
+				}
+			}
+			if !strings.Contains(report.String(), "skipping unsafe path") {
+				t.Errorf("expected unsafe paths to be reported in %q",
+					report.String())
+			}
+		})
+	}
+}
+
+// Unpacks crafted tarballs that make a soft link to outside the
+// destination and then try to write through it, which mustn't write
+// anything outside the destination. (Each tarball has other.txt so that
+// it is unpacked into the crafted subfolder.)
+func TestUnpackSymlinkEscape(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		existing map[string]string // already in dest/crafted
+		entries  []tarEntry
+	}{
+		{"relative", nil, []tarEntry{
+			{name: "lnk", typeflag: tar.TypeSymlink, linkname: "../.."},
+			{name: "lnk/evil", content: "evil"},
+		}},
+		{"absolute", nil, []tarEntry{
+			{name: "lnk", typeflag: tar.TypeSymlink, linkname: "BASE"},
+			{name: "lnk/evil", content: "evil"},
+		}},
+		{"chained", nil, []tarEntry{
+			{name: "a", typeflag: tar.TypeSymlink, linkname: "."},
+			{name: "a/b", typeflag: tar.TypeSymlink, linkname: "../.."},
+			{name: "a/b/evil", content: "evil"}, // a/b is really b
+		}},
+		{"folder then link", nil, []tarEntry{
+			{name: "lnk/", typeflag: tar.TypeDir},
+			{name: "lnk", typeflag: tar.TypeSymlink, linkname: "../.."},
+			{name: "lnk/evil", content: "evil"},
+		}},
+		{"existing", map[string]string{"lnk": "->BASE"}, []tarEntry{
+			{name: "lnk/evil", content: "evil"},
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			base := t.TempDir()
+			dest := filepath.Join(base, "out", "dest")
+			existing := map[string]string{}
+			for name, content := range test.existing {
+				existing[name] = strings.ReplaceAll(content, "BASE", base)
+			}
+			makeTree(t, filepath.Join(dest, "crafted"), existing)
+			entries := []tarEntry{{name: "other.txt", content: "other"}}
+			for _, entry := range test.entries {
+				entry.linkname = strings.ReplaceAll(entry.linkname, "BASE",
+					base)
+				entries = append(entries, entry)
+			}
+			archive := filepath.Join(base, "crafted.tar")
+			writeTar(t, archive, entries)
+			if err := Unpack(archive, dest, quiet()); err != nil {
+				t.Fatal(err)
+			}
+			for _, path := range treePaths(t, base) {
+				switch {
+				case path == "crafted.tar", path == "out",
+					path == "out/dest", strings.HasPrefix(path, "out/dest/"):
+				default:
+					t.Errorf("unexpected %s outside the destination", path)
+				}
+			}
+			if got := readFile(t, filepath.Join(dest, "crafted",
+				"other.txt")); got != "other" {
+				t.Errorf("expected %q, got %q", "other", got)
+			}
+		})
+	}
+}
+
+func TestOverwritePolicies(t *testing.T) {
+	older := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	same := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	newer := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	archive := filepath.Join(t.TempDir(), "top.tar")
+	writeTar(t, archive, []tarEntry{
+		{name: "top/", typeflag: tar.TypeDir},
+		{name: "top/older.txt", content: "archive", modified: older},
+		{name: "top/same.txt", content: "archive", modified: same},
+		{name: "top/newer.txt", content: "archive", modified: newer},
+		{name: "top/absent.txt", content: "archive", modified: older},
+	})
+	const a, e = "archive", "existed" // the same size
+	names := []string{"older.txt", "same.txt", "newer.txt", "absent.txt"}
+	for _, test := range []struct {
+		policy OverwritePolicy
+		want   [4]string // older, same, newer, absent
+	}{
+		{Overwrite, [4]string{a, a, a, a}},
+		{KeepNewer, [4]string{e, e, a, a}},
+		{SkipExisting, [4]string{e, e, e, a}},
+		{Resume, [4]string{a, e, a, a}}, // same size and time: skipped
+	} {
+		dest := t.TempDir()
+		for _, name := range names[:3] {
+			name = filepath.Join(dest, name)
+			if err := os.WriteFile(name, []byte(e), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.Chtimes(name, same, same); err != nil {
+				t.Fatal(err)
+			}
+		}
+		options := quiet()
+		options.Overwrite = test.policy
+		if err := Unpack(archive, dest, options); err != nil {
+			t.Fatal(err)
+		}
+		for i, name := range names {
+			if got := readFile(t, filepath.Join(dest,
+				name)); got != test.want[i] {
+				t.Errorf("policy %d: %s: expected %q, got %q", test.policy,
+					name, test.want[i], got)
+			}
+		}
+	}
+}
+
+func TestArchiveFolder(t *testing.T) {
+	for _, test := range []struct {
+		archive string
+		want    string
+	}{
+		{"/tmp/project.tar.gz", "project"},
+		{"project.TGZ", "project"},
+		{"Résumé-ſ.TAR.GZ", "Résumé-ſ"},
+		{"日本語.zip", "日本語"},
+		{"a.tar.backup.zip", "a.tar.backup"},
+		{"notes.txt", "notes"},
+	} {
+		if got := archiveFolder(test.archive); got != test.want {
+			t.Errorf("%s: expected %q, got %q", test.archive, test.want,
+				got)
+		}
+	}
+}
+
+func TestUnpackFileInTheWay(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		existing map[string]string // relative to the base folder
+		force    bool
+		want     error
+	}{
+		{"no file", nil, false, nil},
+		{"folder", map[string]string{"out/multi/": ""}, false, nil},
+		{"subfolder file", map[string]string{"out/multi": "x"}, false,
+			ErrNotFolder},
+		{"subfolder file forced", map[string]string{"out/multi": "x"},
+			true, nil},
+		{"dest file", map[string]string{"out": "x"}, false, ErrNotFolder},
+		{"dest file forced", map[string]string{"out": "x"}, true, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			base := t.TempDir()
+			archive := filepath.Join(base, "multi.tar")
+			writeTar(t, archive, []tarEntry{{name: "a.txt", content: "a"},
+				{name: "b.txt", content: "b"}})
+			makeTree(t, base, test.existing)
+			before := treePaths(t, base)
+			options := quiet()
+			options.Force = test.force
+			err := Unpack(archive, filepath.Join(base, "out"), options)
+			if !errors.Is(err, test.want) {
+				t.Fatalf("expected %v, got %v", test.want, err)
+			}
+			want := []string{"multi.tar", "out", "out/multi",
+				"out/multi/a.txt", "out/multi/b.txt"}
+			if err != nil { // nothing is changed
+				want = before
+			}
+			if got := treePaths(t, base); !equalStrs(got, want) {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestTruncateName(t *testing.T) {
+	long := strings.Repeat("a", 300)
+	for _, test := range []struct {
+		name   string
+		limit  int
+		length int    // of the result
+		suffix string // of the result
+	}{
+		{long + ".txt", 255, 255, ".txt"},
+		{long, 255, 255, ""},
+		{long + ".txt", 100, 100, ".txt"},
+		{long + "." + strings.Repeat("e", 40), 255, 255, ""}, // long ext
+		{"x" + strings.Repeat("é", 134) + ".txt", 255, 254, ".txt"},
+		{strings.Repeat("日", 100), 255, 254, ""},
+		{long + ".txt", 16, 16, ""}, // just the hash
+	} {
+		got := truncateName(test.name, test.limit)
+		if len(got) != test.length || !utf8.ValidString(got) ||
+			!strings.HasSuffix(got, test.suffix) {
+			t.Errorf("%.10s... %d: expected %d bytes ending %q, got %q",
+				test.name, test.limit, test.length, test.suffix, got)
+		}
+		if again := truncateName(test.name, test.limit); again != got {
+			t.Errorf("%.10s...: expected %q again, got %q", test.name, got,
+				again)
+		}
+	}
+	if truncateName(long+"1", 255) == truncateName(long+"2", 255) {
+		t.Error("expected different names to be truncated differently")
+	}
+}
+
+// Unpacks a tarball with a file name, a folder name, and a name of
+// two-byte characters that are too long, a path that is too long for any
+// OS, and a hard link to the long file name.
+func TestUnpackLongNames(t *testing.T) {
+	longFile := strings.Repeat("a", 300) + ".txt"
+	longFolder := strings.Repeat("b", 260)
+	wideFile := "x" + strings.Repeat("é", 134) + ".txt"
+	deep := strings.Repeat(strings.Repeat("c", 200)+"/", 170) + "d.txt"
+	archive := filepath.Join(t.TempDir(), "long.tar")
+	writeTar(t, archive, []tarEntry{
+		{name: "long/ok.txt", content: "ok"},
+		{name: "long/" + longFile, content: "file"},
+		{name: "long/" + longFolder + "/x.txt", content: "x"},
+		{name: "long/" + wideFile, content: "wide"},
+		{name: "long/" + deep, content: "deep"},
+		{name: "long/hard.txt", typeflag: tar.TypeLink,
+			linkname: "long/" + longFile},
+	})
+	for _, test := range []struct {
+		name     string
+		truncate bool
+		limit    int
+		want     []string
+	}{
+		{"skip", false, 0, []string{"ok.txt"}},
+		{"truncate", true, 0, []string{"hard.txt", "ok.txt",
+			truncateName(longFile, 255), truncateName(wideFile, 255),
+			truncateName(longFolder, 255), truncateName(longFolder, 255) +
+				"/x.txt"}},
+		{"truncate 100", true, 100, []string{"hard.txt", "ok.txt",
+			truncateName(longFile, 100), truncateName(wideFile, 100),
+			truncateName(longFolder, 100), truncateName(longFolder, 100) +
+				"/x.txt"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dest := t.TempDir()
+			var stderr bytes.Buffer
+			options := quiet()
+			options.Stderr = &stderr
+			options.TruncateNames = test.truncate
+			options.MaxNameLength = test.limit
+			if err := Unpack(archive, dest, options); err != nil {
+				t.Fatal(err)
+			}
+			want := append([]string{}, test.want...)
+			sort.Strings(want)
+			if got := treePaths(t, dest); !equalStrs(got, want) {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+			if !strings.Contains(stderr.String(), "d.txt since its path "+
+				"is longer than") {
+				t.Errorf("expected the deep path to be skipped in %q",
+					stderr.String())
+			}
+			if test.truncate {
+				if got := strings.Count(stderr.String(),
+					"renamed"); got != 3 {
+					t.Errorf("expected 3 renames, got %d in %q", got,
+						stderr.String())
+				}
+				if got := readFile(t, filepath.Join(dest,
+					"hard.txt")); got != "file" {
+					t.Errorf("expected %q, got %q", "file", got)
+				}
+			}
+		})
+	}
+}
+
+// Unpacks over soft links at members' paths: one dangling, one to a file
+// inside the destination, and one to a file outside it, none of which may
+// be written through.
+func TestUnpackOverLinks(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		noClobber bool
+		policy    OverwritePolicy
+		replaced  bool // the links are replaced by the members
+	}{
+		{"default", false, Overwrite, true},
+		{"no clobber", true, Overwrite, false},
+		{"skip existing", false, SkipExisting, false},
+		{"no clobber resume", true, Resume, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			base := t.TempDir()
+			outside := filepath.Join(base, "outside.txt")
+			makeTree(t, base, map[string]string{"outside.txt": "outside"})
+			for _, format := range []string{"tar", "zip"} {
+				dest := filepath.Join(base, format, "out")
+				makeTree(t, dest, map[string]string{"a.txt": "->nowhere/x",
+					"b.txt": "->keep/v.txt", "keep/v.txt": "kept",
+					"c.txt": "->" + outside})
+				archive := filepath.Join(base, "out."+format)
+				names := []string{"out/a.txt", "out/b.txt", "out/c.txt"}
+				if format == "tar" {
+					writeTar(t, archive, []tarEntry{
+						{name: names[0], content: names[0]},
+						{name: names[1], content: names[1]},
+						{name: names[2], content: names[2]}})
+				} else {
+					writeZip(t, archive, names, []os.FileMode{0o644, 0o644,
+						0o644})
+				}
+				options := quiet()
+				options.NoClobberLinks = test.noClobber
+				options.Overwrite = test.policy
+				if err := Unpack(archive, dest, options); err != nil {
+					t.Fatal(err)
+				}
+				for _, name := range names {
+					path := filepath.Join(base, format, name)
+					info, err := os.Lstat(path)
+					if err != nil {
+						t.Fatal(err)
+					}
+					if isLink := info.Mode()&os.ModeSymlink != 0; isLink ==
+						test.replaced {
+						t.Errorf("%s: %s: expected replaced %t, got %s",
+							format, name, test.replaced, info.Mode())
+					} else if test.replaced {
+						if got := readFile(t, path); got != name {
+							t.Errorf("%s: expected %q, got %q", format,
+								name, got)
+						}
+					}
+				}
+				if got := readFile(t, filepath.Join(dest, "keep",
+					"v.txt")); got != "kept" {
+					t.Errorf("%s: expected keep/v.txt unchanged, got %q",
+						format, got)
+				}
+				if got := readFile(t, outside); got != "outside" {
+					t.Errorf("%s: expected outside.txt unchanged, got %q",
+						format, got)
+				}
+				if _, err := os.Stat(filepath.Join(dest,
+					"nowhere")); !os.IsNotExist(err) {
+					t.Errorf("%s: expected no nowhere folder, got %v",
+						format, err)
+				}
+			}
+		})
+	}
+}
+
+// Unpacks soft links as if onto a filesystem that doesn't support them.
+func TestUnpackDerefLinks(t *testing.T) {
+	defer func(create func(string, string) error) {
+		symlink = create
+	}(symlink)
+	symlink = func(target, name string) error {
+		return &os.LinkError{Op: "symlink", Old: target, New: name,
+			Err: errors.New("operation not supported")}
+	}
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "top.tar")
+	writeTar(t, archive, []tarEntry{
+		{name: "top/chain.txt", typeflag: tar.TypeSymlink,
+			linkname: "link.txt"}, // before its target
+		{name: "top/a.txt", content: "alpha"},
+		{name: "top/link.txt", typeflag: tar.TypeSymlink,
+			linkname: "a.txt"},
+		{name: "top/sub/", typeflag: tar.TypeDir},
+		{name: "top/sub/up.txt", typeflag: tar.TypeSymlink,
+			linkname: "../a.txt"},
+		{name: "top/sublink", typeflag: tar.TypeSymlink, linkname: "sub"},
+		{name: "top/dangling", typeflag: tar.TypeSymlink,
+			linkname: "nowhere"},
+		{name: "top/hard.txt", typeflag: tar.TypeLink,
+			linkname: "top/chain.txt"},
+		{name: "top/escape", typeflag: tar.TypeSymlink,
+			linkname: "../../outside"},
+	})
+	for _, test := range []struct {
+		name    string
+		deref   bool
+		strict  bool
+		want    []string
+		err     error
+		reports []string
+	}{
+		{"links", false, false, []string{"a.txt", "sub"}, nil,
+			[]string{"couldn't be created", "skipping risky soft link"}},
+		{"copies", true, false, []string{"a.txt", "chain.txt", "hard.txt",
+			"link.txt", "sub", "sub/up.txt"}, nil,
+			[]string{"isn't a regular file", "isn't in the archive",
+				"skipping risky soft link"}},
+		{"strict", true, true, nil, ErrOutsideLink, nil},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dest := filepath.Join(t.TempDir(), "top")
+			var stderr bytes.Buffer
+			options := quiet()
+			options.Stderr = &stderr
+			options.DerefLinks = test.deref
+			options.StrictLinks = test.strict
+			err := Unpack(archive, dest, options)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("expected %v, got %v", test.err, err)
+			}
+			if err != nil {
+				return
+			}
+			if got := treePaths(t, dest); !equalStrs(got, test.want) {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+			for _, path := range test.want {
+				if strings.HasSuffix(path, ".txt") {
+					if got := readFile(t, filepath.Join(dest,
+						path)); got != "alpha" {
+						t.Errorf("%s: expected %q, got %q", path, "alpha",
+							got)
+					}
+				}
+			}
+			for _, report := range test.reports {
+				if !strings.Contains(stderr.String(), report) {
+					t.Errorf("expected %q in %q", report, stderr.String())
+				}
+			}
+		})
+	}
+}
@@ -1,305 +1,581 @@
 // Copyright © 2023 Mark Summerfield. All rights reserved.
 // License: Apache-2.0
 
-package main
+// Package unz lists and unpacks archives: tarballs (compressed or not), zip
+// files, 7z files, cpio archives (newc or odc, compressed or not), ar
+// archives (e.g., .deb packages), and single compressed files.
+//
+// When unpacking, if all the archive's members are inside a single
+// top-level folder (a "wrapper", e.g., GitHub's "repo-abcdef1/"), that
+// folder is stripped and its contents are unpacked directly into the
+// destination folder (unless Options.KeepWrapper). Otherwise at most one
+// file or folder is created in the destination folder: if the archive's
+// members share a single root they are unpacked directly; otherwise a
+// subfolder named after the archive is created to hold them.
+//
+// Compressed files made of concatenated streams (e.g., by cat a.gz b.gz)
+// are decompressed as a whole, so a tarball split across several streams
+// is read across their boundaries. However, whole tarballs that have been
+// concatenated (e.g., cat a.tar.gz b.tar.gz) yield only the first
+// tarball's members, since its end-of-archive marker ends the tarball (as
+// it does for tar unless given --ignore-zeros).
+package unz
 
 import (
-	"archive/tar"
-	"archive/zip"
-	"compress/bzip2"
-	"compress/gzip"
+	"context"
 	_ "embed"
+	"errors"
 	"fmt"
 	"io"
-	"log"
 	"os"
+	"path"
 	"path/filepath"
-	"strconv"
 	"strings"
-
-	"github.com/mark-summerfield/clip"
-	"github.com/mark-summerfield/gong"
-	"github.com/ulikunitz/xz"
+	"time"
 )
 
 //go:embed Version.dat
 var Version string
 
-func main() {
-	log.SetFlags(0)
-	verbose, unpack, archives := getConfig()
-	for _, archive := range archives {
-		if unpack {
-			unpackArchive(archive, verbose)
-		} else {
-			listArchive(archive, verbose)
-		}
-	}
+var (
+	ErrMaxSize     = errors.New("extraction exceeds max-size")
+	ErrMaxFileSize = errors.New("extraction exceeds max-file-size")
+	ErrNoPassword  = errors.New("member is encrypted but no password " +
+		"was given")
+	ErrUnsupported = errors.New("unsupported archive format")
+	ErrNoMember    = errors.New("no such member")
+	ErrFlatten     = errors.New("flattening would make members clash")
+	ErrFormat      = errors.New("unknown format")
+	ErrNotFolder   = errors.New("a file with that name exists")
+	ErrExists      = errors.New("a file or folder with that name exists")
+	ErrBroken      = errors.New("some members couldn't be read")
+	ErrCharset     = errors.New("unknown charset")
+	ErrDuplicate   = errors.New("duplicate member")
+	ErrRename      = errors.New("invalid rename expression")
+	ErrOutsideLink = errors.New("soft link points outside the archive")
+)
+
+// Member holds the metadata of one archive member.
+type Member struct {
+	Name    string
+	Size    int64 // uncompressed size in bytes
+	Mode    os.FileMode
+	ModTime time.Time
+	Header  any // the *tar.Header or *zip.FileHeader (or nil), read-only
+}
+
+// IsDir returns true if the member is a folder (going by its mode, or for
+// zip members that lack a folder mode, by a trailing "/").
+func (me Member) IsDir() bool {
+	return me.Mode.IsDir() || strings.HasSuffix(me.Name, "/")
+}
+
+// OverwritePolicy says what to do when a member would replace an existing
+// file. Resume is for continuing an unpacking that stopped part way:
+// existing regular files with the member's size and modification time (to
+// the second) are skipped, and any others (e.g., partly written ones) are
+// replaced.
+type OverwritePolicy uint8
+
+const (
+	Overwrite    OverwritePolicy = iota // replace existing files
+	KeepNewer                           // replace only older files
+	SkipExisting                        // never replace files
+	Resume                              // replace only partly written files
+)
+
+// FlattenPolicy says whether to drop members' folders when unpacking and
+// what to do if two members would have the same name as a result.
+type FlattenPolicy uint8
+
+const (
+	NoFlatten     FlattenPolicy = iota // keep the archive's folders
+	Flatten                            // drop folders; fail on clashes
+	FlattenRename                      // drop folders; rename clashes
+)
+
+// Options controls how archives are unpacked. The zero value is usable,
+// but has no limits on how much may be written, so an untrusted archive
+// (e.g., a "decompression bomb") could fill the disk: use MaxSize and
+// MaxFileSize to guard against this.
+type Options struct {
+	Verbose         bool            // report folders, files, and links
+	Overwrite       OverwritePolicy // what to do with existing files
+	StripComponents int             // leading path components to remove
+	NoPreserveTimes bool            // don't use the archive's times
+	NoPreservePerms bool            // use 0644 for files and 0755 for folders
+	Includes        []string        // glob patterns of members to unpack
+	Excludes        []string        // glob patterns of members to skip
+	Stdout          io.Writer       // for Verbose reports [default os.Stdout]
+	Stderr          io.Writer       // for warnings [default os.Stderr]
+	Progress        ProgressFunc    // called as each file is written
+	Created         CreatedFunc     // called for each path created
+	// Jobs applies only to zip files since tarballs must be read
+	// sequentially.
+	Jobs int // zip members to unpack concurrently (0 = GOMAXPROCS)
+	// Each unpacked member that is itself an archive (going by its name) is
+	// unpacked in place with Depth - 1.
+	Depth         int    // levels of nested archives to unpack
+	RemoveNested  bool   // delete nested archives once unpacked
+	MaxSize       int64  // max total bytes to write (0 = any)
+	MaxFileSize   int64  // max bytes to write per file (0 = any)
+	Password      string // for encrypted zip members
+	KeepWrapper   bool   // don't strip a single top-level folder
+	PreserveOwner bool   // set tarball members' uid/gid (root)
+	// With PreserveOwner, a member whose archived numeric id (as a decimal
+	// string, e.g., "1000") or name is a key in OwnerMap (or GroupMap) is
+	// given the mapped id; unmapped ids are used as they are.
+	OwnerMap map[string]int // archived uid or user name → local uid
+	GroupMap map[string]int // archived gid or group name → local gid
+	// The Members are unpacked directly into the destination folder
+	// (neither stripping a wrapper nor creating a subfolder).
+	Members []string // exact paths of the members to unpack
+	// Flattened members go directly into the destination folder using
+	// their basenames, and folders are skipped.
+	Flatten FlattenPolicy // whether to drop members' folders
+	Format  string        // one of Formats or "" to detect
+	Special bool          // create tarballs' devices and FIFOs
+	Force   bool          // replace files in place of folders
+	// Atomic unpacks into a temporary folder inside the destination folder
+	// and only if that succeeds moves what was unpacked into it; but it
+	// fails with an ErrExists error if anything to be moved already exists
+	// (whatever the Overwrite policy).
+	Atomic bool // unpack everything or nothing
+	// A file that fails part way through being written is always removed.
+	Cleanup bool // on failure remove a new subfolder
+	// KeepBroken skips members that can't be read (e.g., corrupt zip
+	// members or 7z members that fail their checksums) with a warning and
+	// then returns an ErrBroken error. Tarballs (and corrupt 7z streams)
+	// can't be read past a corrupt header or stream, so for these
+	// unpacking still stops, but what was unpacked is kept (even with
+	// Cleanup).
+	KeepBroken bool // skip members that can't be read
+	// Charset is one of Charsets or "" to decide per member (see
+	// ListWith()).
+	Charset string // for zip names not flagged as UTF-8
+	// Any \ in the names of zip members made on DOS or Windows is always
+	// treated as /.
+	Backslashes bool // treat \ in all zip names as /
+	// Members without a usable modification time (see WantedMember()) are
+	// unpacked whatever Since and Before are unless StrictTimes.
+	Since       time.Time // unpack members modified from this...
+	Before      time.Time // ...up to this (zero = unbounded)
+	StrictTimes bool      // skip members without usable times
+	// If two wanted members (other than folders) have the same path (see
+	// Duplicates()), the later one replaces the earlier one (as with tar)
+	// with a warning if Verbose or WarnDuplicates.
+	WarnDuplicates bool // warn about members with same path
+	NoDuplicates   bool // fail if members have the same path
+	// Larger buffers mean fewer, larger writes.
+	BufferSize int // bytes to copy at a time (0 = DefaultBufferSize)
+	// Members with a name (i.e., a path component) longer than
+	// MaxNameLength bytes, or a path too long for the OS, are skipped with
+	// a warning unless TruncateNames (which shortens them the same way
+	// every time, and warns with the new name).
+	MaxNameLength int  // max bytes per name (0 = DefaultMaxNameLength)
+	TruncateNames bool // shorten names rather than skip them
+	// A member that isn't a folder is never written via an existing soft
+	// link at its path: the link is removed first (if the Overwrite policy
+	// allows) unless NoClobberLinks.
+	NoClobberLinks bool // skip members where soft links exist
+	// Xattrs restores extended attributes (e.g., SELinux labels and file
+	// capabilities) stored as SCHILY.xattr.* PAX records, if
+	// XattrsSupported; any that can't be set are skipped with a warning.
+	Xattrs bool // restore tarballs' extended attributes
+	// DerefLinks is for filesystems such as FAT that lack soft links: the
+	// copies are made once everything else has been unpacked, and links
+	// to folders, missing members, or outside the destination folder are
+	// skipped with a warning (or for outside with StrictLinks, an
+	// ErrOutsideLink error).
+	DerefLinks  bool // copy soft links' targets, not links
+	StrictLinks bool // fail on DerefLinks to outside
+	// Renames are applied in turn to every member's path (and hard link
+	// target) after StripComponents; the results are checked like any
+	// other path, and members renamed to "" are skipped.
+	Renames []Rename // substitutions applied to members' paths
+	// When Context is done (e.g., canceled on Ctrl-C) the partly written
+	// file (if any) is removed, as is Atomic's temporary folder (Atomic
+	// leaves handling SIGINT and SIGTERM to whatever cancels the context).
+	Context      context.Context // stops unpacking when done (or nil)
+	TempFolder   string          // for temporary files ("" = the OS's)
+	nested       []string        // nested archives written by Unpack
+	atomicFolder string          // Atomic's temporary folder...
+	destFolder   string          // ...stands in for this in reports
+}
+
+// DefaultBufferSize is the number of bytes copied at a time when writing
+// files if Options.BufferSize is 0.
+const DefaultBufferSize = 1 << 20
+
+// DefaultMaxNameLength is the usual limit on the length in bytes of a file
+// or folder name (e.g., for ext4, APFS, and NTFS) that's used if
+// Options.MaxNameLength is 0.
+const DefaultMaxNameLength = 255
+
+// ProgressFunc is called repeatedly while a regular file is being written
+// with the file's name, the number of bytes written so far, and the total
+// expected (or -1 if the total isn't known in advance, e.g., for a single
+// compressed file). The last call for each file has done == total (or
+// done == the final size if the total isn't known).
+type ProgressFunc func(name string, done, total int64)
+
+// CreatedFunc is called with the path of each file, folder, or link that
+// is created when unpacking (e.g., to record them in a manifest), once it
+// has been written. With Atomic the path is the final one (in the
+// destination folder) even though it isn't moved there until the end.
+// Skipped members aren't passed, but the path of a file that is later
+// removed (e.g., a nested archive with RemoveNested) is.
+type CreatedFunc func(path string)
+
+// List returns the archive's members in archive order. If an error occurs
+// part way through, the members read so far are returned with the error.
+func List(archive string) ([]Member, error) {
+	return ListFormat(archive, "")
 }
 
-func getConfig() (bool, bool, []string) {
-	parser := clip.NewParserUser("unz", Version)
-	parser.LongDesc = `Unpacks (or lists) each archive (.tar, .tar.gz,
-	.tar.bz2, .tar.xz, .tgz, or .zip).
-
-	When unpacking (the default behavior), for each archive at most one file
-	or folder is created in the current folder. If the archive contains one
-	file or folder, that file or folder is unpacked into the current folder.
-	If the archive contains more than one member, then a new subfolder is
-	created based on the archive's name, and all the archive's contents are
-	unpacked into the subfolder.`
-	parser.PositionalCount = clip.OneOrMorePositionals
-	_ = parser.SetPositionalVarName("ARCHIVE")
-	verboseOpt := parser.Flag("verbose", "Show actions.")
-	listOpt := parser.Flag("list",
-		"List each archive's contents (don't unpack).")
-	err := parser.Parse()
+// ListFormat is like List but treats the archive as being in the given
+// format (one of Formats) rather than detecting it; "" means detect.
+func ListFormat(archive, format string) ([]Member, error) {
+	return ListWith(archive, Options{Format: format})
+}
+
+// ListWith is like List but uses opts.Format (see ListFormat()),
+// opts.Charset, and opts.Backslashes (see Options); its other fields are
+// ignored.
+func ListWith(archive string, opts Options) ([]Member, error) {
+	if !validCharset(opts.Charset) {
+		return []Member{}, fmt.Errorf("%w: %s", ErrCharset, opts.Charset)
+	}
+	form, err := archiveFormat(archive, opts.Format)
 	if err != nil {
-		log.Fatal(gong.Underline(fmt.Sprintf("%s\n", err)))
+		return []Member{}, err
+	}
+	switch form.kind {
+	case tarKind, cpioKind, arKind:
+		return tarballMembers(archive, form)
+	case compressedKind:
+		return compressedMembers(archive, form.compression)
+	case sevenZipKind:
+		return sevenZipMembers(archive)
+	case rarKind:
+		return []Member{}, fmt.Errorf("failed to open %s: RAR: %w",
+			archive, ErrUnsupported)
+	default:
+		return zipMembers(archive, &opts)
 	}
-	return verboseOpt.Value(), !listOpt.Value(), parser.Positionals
 }
 
-func unpackArchive(archive string, verbose bool) {
-	if isTarball(archive) {
-		unpackTarball(archive, verbose)
-	} else {
-		unpackZip(archive, verbose)
+// Comment returns the archive's comment, which is "" for a zip file
+// without one and for archives in other formats (which have none). Each
+// zip member's comment is in its Member.Header's Comment. It uses the
+// same opts fields as ListWith.
+func Comment(archive string, opts Options) (string, error) {
+	if !validCharset(opts.Charset) {
+		return "", fmt.Errorf("%w: %s", ErrCharset, opts.Charset)
 	}
+	form, err := archiveFormat(archive, opts.Format)
+	if err != nil || form.kind != zipKind {
+		return "", err
+	}
+	return zipComment(archive, &opts)
 }
 
-func unpackTarball(archive string, verbose bool) {
-	names := tarballNames(archive)
-	switch len(names) {
-	case 0:
-		if verbose {
-			fmt.Println("no members to unpack")
-		}
-		return
-	case 1:
-		reader, closer := openTarball(archive)
-		if reader == nil {
-			return
-		}
-		defer closer()
-		unpackOneTarMember(archive, reader, cwd(), verbose)
-	default:
-		folder := gong.LongestCommonPath(names)
-		//if folder == ""
-		// TODO
-		// os.MkdirAll(folder, os.ModePerm)
-		fmt.Println("TODO unpackTarball", archive, verbose, folder)
+// Unpack unpacks the archive into the dest folder (which is created if
+// necessary; "" means the current folder). Members which can't safely be
+// unpacked (e.g., those with absolute paths or with paths that would
+// escape dest) are skipped with a warning written to opts.Stderr.
+func Unpack(archive, dest string, opts Options) error {
+	if dest == "" {
+		dest = "."
+	}
+	if opts.Stdout == nil {
+		opts.Stdout = os.Stdout
 	}
+	if opts.Stderr == nil {
+		opts.Stderr = os.Stderr
+	}
+	if !validCharset(opts.Charset) {
+		return fmt.Errorf("%w: %s", ErrCharset, opts.Charset)
+	}
+	form, err := archiveFormat(archive, opts.Format)
+	if err != nil {
+		return err
+	}
+	if form.kind == rarKind {
+		return fmt.Errorf("failed to open %s: RAR: %w", archive,
+			ErrUnsupported)
+	}
+	if opts.Atomic {
+		return unpackAtomic(archive, dest, form, &opts)
+	}
+	if err := makeFolder(dest, opts.Force); err != nil {
+		return err
+	}
+	return unpackInto(archive, dest, form, &opts)
 }
 
-func unpackOneTarMember(archive string, reader *tar.Reader, folder string,
-	verbose bool) bool {
-	header, err := reader.Next()
-	if err == io.EOF {
-		return false // no more to do
+// Unpacks the archive into the existing dest folder and then any nested
+// archives.
+func unpackInto(archive, dest string, form format, opts *Options) error {
+	var err error
+	switch form.kind {
+	case tarKind, cpioKind, arKind:
+		err = unpackTarball(archive, dest, form, opts)
+	case compressedKind:
+		err = unpackCompressed(archive, dest, form.compression, opts)
+	case sevenZipKind:
+		err = unpackSevenZip(archive, dest, opts)
+	default:
+		err = unpackZip(archive, dest, opts)
 	}
-	if err != nil {
-		log.Println(gong.Underline(fmt.Sprintf("failed to read %s: %s",
-			archive, err)))
-		return false // don't go further
-	}
-	name := filepath.Clean(header.Name)
-	if filepath.IsAbs(name) {
-		log.Printf("skipping risky absolute path member %s\n", name)
-		return true // try next one
-	}
-	name = filepath.Join(folder, name)
-	switch header.Typeflag {
-	case tar.TypeDir:
-		log.Printf("TODO create folder %s\n", name)
-		// TODO make dir name in given folder
-		if verbose {
-			fmt.Printf("created folder %s\n", name)
+	if err == nil {
+		err = unpackNested(opts)
+	}
+	return err
+}
+
+// Unpacks each of the nested archives that was written into its own
+// folder. Returns the first error, but tries them all.
+func unpackNested(opts *Options) error {
+	var err error
+	nested := opts.nested
+	opts.nested = nil
+	opts.Depth--
+	opts.Format = "" // nested archives' formats are detected
+	for _, archive := range nested {
+		if nerr := Unpack(archive, filepath.Dir(archive),
+			*opts); nerr != nil {
+			if err == nil {
+				err = nerr
+			}
+			continue
 		}
-	case tar.TypeReg:
-		log.Printf("TODO create file %s\n", name)
-		// TODO write file name in given folder
-		if verbose {
-			fmt.Printf("created file %s\n", name)
+		if opts.RemoveNested {
+			if rerr := os.Remove(archive); rerr != nil && err == nil {
+				err = rerr
+			}
 		}
-	case tar.TypeSymlink:
-		log.Printf("TODO create soft link %s\n", name)
-		// TODO create soft link
-		log.Printf("skipping unsupported soft link %s\n", name)
-	case tar.TypeLink:
-		log.Printf("skipping unsupported hard link %s\n", name)
-	default:
-		log.Printf("skipping unsupported member type (device or FIFO) %s\n",
-			name)
 	}
-	return true
+	return err
 }
 
-func unpackZip(archive string, verbose bool) {
-	// TODO
-	fmt.Println("TODO unpackZip", archive, verbose)
+// WantedMember returns true if the member should be listed or unpacked
+// going by its name (see Wanted()) and its modification time (see Since
+// and Before). Times before 1980 (the earliest a zip file can store, and
+// which includes the zero time and tar's 1970 epoch) count as unusable.
+func (me *Options) WantedMember(member Member) bool {
+	return me.wanted(member.Name, member.ModTime)
 }
 
-func listArchive(archive string, verbose bool) {
-	if isTarball(archive) {
-		listTarball(archive, verbose)
-	} else {
-		listZip(archive, verbose)
-	}
+func (me *Options) wanted(name string, modTime time.Time) bool {
+	return me.Wanted(name) && me.inTimeRange(modTime)
 }
 
-func listTarball(archive string, verbose bool) {
-	names := tarballNames(archive)
-	if verbose {
-		fmt.Print(gong.Bold(archive))
-		n := len(names)
-		fmt.Printf(" (%s member%s)\n", commas(n), s(n))
-	} else {
-		fmt.Println(archive)
+var earliestTime = time.Date(1980, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func (me *Options) inTimeRange(modTime time.Time) bool {
+	if me.Since.IsZero() && me.Before.IsZero() {
+		return true
 	}
-	for _, name := range names {
-		fmt.Println(name)
+	if modTime.Before(earliestTime) {
+		return !me.StrictTimes
 	}
+	return (me.Since.IsZero() || !modTime.Before(me.Since)) &&
+		(me.Before.IsZero() || modTime.Before(me.Before))
 }
 
-func tarballNames(archive string) []string {
-	names := []string{}
-	reader, closer := openTarball(archive)
-	if reader == nil {
-		return names
+// Wanted returns true if the member with the given name should be listed
+// or unpacked: i.e., if it is one of the Members (or there are none), and
+// it matches an include pattern (or there are none) and doesn't match any
+// exclude pattern. (Since and Before are ignored; see WantedMember().)
+func (me *Options) Wanted(name string) bool {
+	if len(me.Members) > 0 && !me.isMember(name) {
+		return false
 	}
-	defer closer()
-	for {
-		header, err := reader.Next()
-		if err == io.EOF {
-			break
+	for _, pattern := range me.Excludes {
+		if matches(pattern, name) {
+			return false
 		}
-		if err != nil {
-			log.Println(gong.Underline(fmt.Sprintf(
-				"failed to read from %s: %s", archive, err)))
-		} else {
-			names = append(names, header.Name)
+	}
+	if len(me.Includes) == 0 {
+		return true
+	}
+	for _, pattern := range me.Includes {
+		if matches(pattern, name) {
+			return true
 		}
 	}
-	return names
+	return false
 }
 
-func listZip(archive string, verbose bool) {
-	names := zipNames(archive)
-	if verbose {
-		fmt.Print(gong.Bold(archive))
-		n := len(names)
-		fmt.Printf(" (%s member%s)\n", commas(n), s(n))
-	} else {
-		fmt.Println(archive)
-	}
-	for _, name := range names {
-		fmt.Println(name)
+// Returns true if the name is exactly one of the Members (ignoring any
+// leading "./" or trailing "/").
+func (me *Options) isMember(name string) bool {
+	name = memberKey(name)
+	for _, member := range me.Members {
+		if memberKey(member) == name {
+			return true
+		}
 	}
+	return false
 }
 
-func zipNames(archive string) []string {
-	names := []string{}
-	reader, err := zip.OpenReader(archive)
-	if err != nil {
-		log.Println(gong.Underline(fmt.Sprintf(
-			"failed to open from %s: %s", archive, err)))
-		return names
+// Returns an error if any of the Members isn't in the archive, or if
+// NoDuplicates and any of the wanted members are duplicates (which are
+// otherwise warned about if Verbose or WarnDuplicates).
+func (me *Options) checkMembers(archive string, members []Member) error {
+	names := make(map[string]bool, len(members))
+	for _, member := range members {
+		names[memberKey(member.Name)] = true
 	}
-	defer reader.Close()
-	for _, member := range reader.File {
-		names = append(names, member.Name)
+	for _, member := range me.Members {
+		if !names[memberKey(member)] {
+			return fmt.Errorf("failed to find %s in %s: %w", member,
+				archive, ErrNoMember)
+		}
 	}
-	return names
+	if !me.NoDuplicates && !me.WarnDuplicates && !me.Verbose {
+		return nil
+	}
+	wanted := make([]Member, 0, len(members))
+	for _, member := range members {
+		if me.WantedMember(member) {
+			wanted = append(wanted, member)
+		}
+	}
+	for _, name := range Duplicates(wanted) {
+		if me.NoDuplicates {
+			return fmt.Errorf("failed to unpack %s: %s: %w", archive, name,
+				ErrDuplicate)
+		}
+		fmt.Fprintf(me.Stderr, "duplicate member %s in %s: the later one "+
+			"wins\n", name, archive)
+	}
+	return nil
 }
 
-func isTarball(name string) bool {
-	name = strings.ToUpper(name)
-	return strings.HasSuffix(name, ".TAR") ||
-		strings.HasSuffix(name, ".TGZ") || strings.Contains(name, ".TAR.")
+// Duplicates returns the names of the members (other than folders) whose
+// paths (ignoring any leading "./") are the same as an earlier member's,
+// in archive order, so a path that occurs three times is returned twice.
+func Duplicates(members []Member) []string {
+	var duplicates []string
+	seen := make(map[string]bool, len(members))
+	for _, member := range members {
+		if member.IsDir() {
+			continue
+		}
+		key := memberKey(member.Name)
+		if seen[key] {
+			duplicates = append(duplicates, member.Name)
+		}
+		seen[key] = true
+	}
+	return duplicates
 }
 
-type closer func()
-
-func openTarball(archive string) (*tar.Reader, closer) {
-	file, err := os.Open(archive)
-	if err != nil {
-		log.Println(gong.Underline(fmt.Sprintf("failed to open %s: %s",
-			archive, err)))
-		return nil, nil
-	}
-	var reader *tar.Reader
-	var closer closer
-	uarchive := strings.ToUpper(archive)
-	if strings.HasSuffix(uarchive, ".GZ") || strings.HasSuffix(uarchive,
-		".TGZ") {
-		ufile, err := gzip.NewReader(file)
-		if err != nil {
-			log.Println(gong.Underline(fmt.Sprintf("failed to open %s: %s",
-				archive, err)))
-			return nil, nil
+// Returns a map of the wanted (and stripped) names of the members that
+// aren't folders to their basenames. If two basenames are the same and
+// Flatten is FlattenRename, the later one gets a numeric suffix (e.g.,
+// foo.txt, foo-1.txt); otherwise returns an ErrFlatten error.
+func (me *Options) flatNames(members []Member) (map[string]string,
+	error) {
+	flat := make(map[string]string, len(members))
+	owners := make(map[string]string, len(members)) // basename → name
+	for _, member := range members {
+		if member.IsDir() || !me.WantedMember(member) {
+			continue
 		}
-		closer = func() {
-			ufile.Close()
-			file.Close()
+		name, ok := me.stripped(member.Name)
+		if !ok {
+			continue
 		}
-		reader = tar.NewReader(ufile)
-	} else if strings.HasSuffix(uarchive, ".BZ2") {
-		ufile := bzip2.NewReader(file)
-		reader = tar.NewReader(ufile)
-	} else if strings.HasSuffix(uarchive, ".XZ") {
-		ufile, err := xz.NewReader(file)
-		if err != nil {
-			log.Println(gong.Underline(fmt.Sprintf("failed to open %s: %s",
-				archive, err)))
-			return nil, nil
+		base := path.Base(filepath.ToSlash(name))
+		if owner, clash := owners[base]; clash {
+			if me.Flatten != FlattenRename {
+				return nil, fmt.Errorf("%s and %s: %w", owner, name,
+					ErrFlatten)
+			}
+			ext := path.Ext(base)
+			if ext == base { // e.g., .bashrc
+				ext = ""
+			}
+			stem := strings.TrimSuffix(base, ext)
+			for i := 1; clash; i++ {
+				base = fmt.Sprintf("%s-%d%s", stem, i, ext)
+				_, clash = owners[base]
+			}
 		}
-		reader = tar.NewReader(ufile)
-	} else {
-		reader = tar.NewReader(file)
-	}
-	if closer == nil {
-		closer = func() { file.Close() }
+		owners[base] = name
+		flat[name] = base
 	}
-	return reader, closer
+	return flat, nil
 }
 
-func s(n int) string {
-	if n == 1 {
-		return ""
-	}
-	return "s"
+func memberKey(name string) string {
+	return strings.TrimPrefix(path.Clean(filepath.ToSlash(name)), "./")
 }
 
-func commas(i int) string {
-	pos := true
-	s := strconv.Itoa(i)
-	if s[0] == '-' {
-		pos = false
-		s = s[1:]
-	}
-	n := len(s) - 3
-	for n >= 0 {
-		s = s[:n] + "," + s[n:]
-		n -= 3
-	}
-	if s[0] == ',' {
-		s = s[1:]
+// VCSExcludes are the exclude patterns for version control folders and
+// other junk, e.g., for Options.Excludes (see unz --exclude-vcs).
+var VCSExcludes = []string{"**/.git", "**/.svn", "**/.hg", "**/.bzr",
+	"**/CVS", "**/.DS_Store", "**/Thumbs.db", "**/__pycache__"}
+
+// Returns true if the glob pattern matches the member's full path or one
+// of its parent folders (so a pattern that matches a folder also matches
+// all of its contents). A pattern that starts with **/ matches at any
+// depth, e.g., **/.git matches .git and src/lib/.git (and their contents).
+func matches(pattern, name string) bool {
+	name = strings.TrimSuffix(filepath.ToSlash(name), "/")
+	if strings.HasPrefix(pattern, "**/") {
+		for {
+			if matches(pattern[len("**/"):], name) {
+				return true
+			}
+			i := strings.IndexByte(name, '/')
+			if i == -1 {
+				return false
+			}
+			name = name[i+1:]
+		}
 	}
-	if !pos {
-		s = "-" + s
+	for {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+		i := strings.LastIndexByte(name, '/')
+		if i == -1 {
+			return false
+		}
+		name = name[:i]
 	}
-	return s
 }
 
-func cwd() string {
-	dir, err := os.Getwd()
-	if err == nil {
-		return dir
+// Returns the name with the first StripComponents path components removed
+// and then Renames applied and true, or "" and false if the name has no
+// more components than that or is renamed to "".
+func (me *Options) stripped(name string) (string, bool) {
+	if me.StripComponents > 0 {
+		parts := strings.Split(path.Clean(filepath.ToSlash(name)), "/")
+		if len(parts) <= me.StripComponents {
+			return "", false
+		}
+		name = strings.Join(parts[me.StripComponents:], "/")
 	}
-	dir, err = filepath.Abs(".")
-	if err == nil {
-		return dir
+	name = me.Renamed(name)
+	return name, name != ""
+}
+
+// Returns the names of the wanted members with their first StripComponents
+// path components removed, omitting those that have no more components
+// than that.
+func (me *Options) unpackNames(members []Member) []string {
+	names := make([]string, 0, len(members))
+	for _, member := range members {
+		if me.WantedMember(member) {
+			if name, ok := me.stripped(member.Name); ok {
+				names = append(names, name)
+			}
+		}
 	}
-	return "."
+	return names
 }
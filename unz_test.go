@@ -0,0 +1,271 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"archive/tar"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// Returns options that discard reports and warnings.
+func quiet() Options {
+	return Options{Stdout: io.Discard, Stderr: io.Discard}
+}
+
+// Makes the named files (relative to folder) with the given contents; a
+// name ending in / is made as a folder, and content starting with "->" is
+// made as a soft link to what follows.
+func makeTree(t *testing.T, folder string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(folder, filepath.FromSlash(name))
+		if strings.HasSuffix(name, "/") {
+			if err := os.MkdirAll(path, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if strings.HasPrefix(content, "->") {
+			if err := os.Symlink(content[2:], path); err != nil {
+				t.Fatal(err)
+			}
+		} else if err := os.WriteFile(path, []byte(content),
+			0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// Returns the slash-separated paths of everything inside folder, sorted.
+func treePaths(t *testing.T, folder string) []string {
+	t.Helper()
+	paths := []string{}
+	err := filepath.Walk(folder, func(path string, info os.FileInfo,
+		err error) error {
+		if err != nil {
+			return err
+		}
+		if path != folder {
+			rel, _ := filepath.Rel(folder, path)
+			paths = append(paths, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// Returns the names of the members, in archive order.
+func memberNames(members []Member) []string {
+	names := make([]string, 0, len(members))
+	for _, member := range members {
+		names = append(names, member.Name)
+	}
+	return names
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func equalStrs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMatches(t *testing.T) {
+	for _, test := range []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "cmd/main.go", false}, // * doesn't match /
+		{"*/*.go", "cmd/main.go", true},
+		{"**/*.go", "cmd/unz/main.go", true},
+		{"**/*.go", "main.go", true},
+		{"docs/**", "docs/a/b.txt", true},
+		{"docs/**", "docs/a.txt", true},
+		{"docs/**", "docs", false},
+		{"docs/*", "docs/a.txt", true},
+		{"docs", "docs/a/b.txt", true}, // a folder matches its contents
+		{"docs", "docs/", true},
+		{"docs", "mydocs/a.txt", false},
+		{"**/.git", ".git/config", true},
+		{"**/.git", "src/lib/.git/HEAD", true},
+		{"**/.git", "src/.github/x", false},
+		{"a?c", "abc", true},
+		{"[", "[", false}, // a malformed pattern matches nothing
+	} {
+		if got := matches(test.pattern, test.name); got != test.want {
+			t.Errorf("%q %q: expected %t, got %t", test.pattern, test.name,
+				test.want, got)
+		}
+	}
+}
+
+func TestWanted(t *testing.T) {
+	for _, test := range []struct {
+		includes []string
+		excludes []string
+		members  []string
+		name     string
+		want     bool
+	}{
+		{nil, nil, nil, "a.go", true},
+		{[]string{"*.go"}, nil, nil, "a.go", true},
+		{[]string{"*.go"}, nil, nil, "a.txt", false},
+		{[]string{"*.go", "*.txt"}, nil, nil, "a.txt", true},
+		{nil, []string{"*.go"}, nil, "a.go", false},
+		{nil, []string{"*.go"}, nil, "a.txt", true},
+		{[]string{"*.go"}, []string{"*_test.go"}, nil, "a_test.go",
+			false}, // excludes take precedence
+		{[]string{"src"}, []string{"src/vendor"}, nil, "src/vendor/x.go",
+			false},
+		{[]string{"src"}, []string{"src/vendor"}, nil, "src/x.go", true},
+		{nil, nil, []string{"a.go"}, "./a.go", true},
+		{nil, nil, []string{"a.go"}, "b.go", false},
+		{nil, []string{"a.go"}, []string{"a.go"}, "a.go", false},
+	} {
+		options := Options{Includes: test.includes,
+			Excludes: test.excludes, Members: test.members}
+		if got := options.Wanted(test.name); got != test.want {
+			t.Errorf("%q %q %q %s: expected %t, got %t", test.includes,
+				test.excludes, test.members, test.name, test.want, got)
+		}
+	}
+}
+
+func TestStripped(t *testing.T) {
+	for _, test := range []struct {
+		strip int
+		name  string
+		want  string // "" if skipped
+	}{
+		{0, "p/a/b.txt", "p/a/b.txt"},
+		{1, "p/a/b.txt", "a/b.txt"},
+		{2, "p/a/b.txt", "b.txt"},
+		{3, "p/a/b.txt", ""},
+		{1, "./p/a.txt", "a.txt"},
+		{1, "p/a/", "a"},
+		{1, "p/", ""},
+		{1, "p//a.txt", "a.txt"},
+	} {
+		options := Options{StripComponents: test.strip}
+		got, ok := options.stripped(test.name)
+		if got != test.want || ok != (test.want != "") {
+			t.Errorf("%d %s: expected %q, got %q %t", test.strip,
+				test.name, test.want, got, ok)
+		}
+	}
+}
+
+func TestDuplicates(t *testing.T) {
+	for _, test := range []struct {
+		names []string
+		want  []string
+	}{
+		{nil, nil},
+		{[]string{"a.txt", "b.txt"}, nil},
+		{[]string{"a.txt", "a.txt"}, []string{"a.txt"}},
+		{[]string{"a.txt", "a.txt", "a.txt"}, []string{"a.txt", "a.txt"}},
+		{[]string{"p/a.txt", "./p/a.txt"}, []string{"./p/a.txt"}},
+		{[]string{"p/", "p/a.txt", "p/"}, nil}, // folders
+		{[]string{"A.txt", "a.txt"}, nil},
+		{[]string{"b.txt", "a.txt", "b.txt", "a.txt"}, []string{"b.txt",
+			"a.txt"}},
+	} {
+		members := make([]Member, 0, len(test.names))
+		for _, name := range test.names {
+			members = append(members, Member{Name: name})
+		}
+		if got := Duplicates(members); !equalStrs(got, test.want) {
+			t.Errorf("%q: expected %q, got %q", test.names, test.want, got)
+		}
+	}
+}
+
+// Unpacks archives that hold an empty logs/ folder (as a tar folder entry,
+// a zip folder entry, and a zip entry whose name ends in "/" but whose
+// mode isn't a folder's, with no entry for its parent), which must be
+// created even though it has no files.
+func TestUnpackEmptyFolders(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		write func(t *testing.T, archive string)
+	}{
+		{"proj.tar", func(t *testing.T, archive string) {
+			writeTar(t, archive, []tarEntry{
+				{name: "proj/", typeflag: tar.TypeDir},
+				{name: "proj/src/", typeflag: tar.TypeDir},
+				{name: "proj/src/main.go", content: "main"},
+				{name: "proj/logs/", typeflag: tar.TypeDir}})
+		}},
+		{"proj.zip", func(t *testing.T, archive string) {
+			writeZip(t, archive, []string{"proj/", "proj/src/",
+				"proj/src/main.go", "proj/logs/"}, []os.FileMode{
+				os.ModeDir | 0o755, os.ModeDir | 0o755, 0o644,
+				os.ModeDir | 0o755})
+		}},
+		{"bare.zip", func(t *testing.T, archive string) {
+			writeZip(t, archive, []string{"proj/src/main.go", "proj/logs/"},
+				[]os.FileMode{0o644, 0o644})
+		}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, test.name)
+			test.write(t, archive)
+			members, err := List(archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			found := false
+			for _, member := range members {
+				if member.Name == "proj/logs/" {
+					found = member.IsDir()
+				}
+			}
+			if !found {
+				t.Errorf("expected proj/logs/ to be listed as a folder in %q",
+					memberNames(members))
+			}
+			dest := filepath.Join(dir, "out")
+			if err := Unpack(archive, dest, quiet()); err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"logs", "src", "src/main.go"}
+			if got := treePaths(t, dest); !equalStrs(got, want) {
+				t.Errorf("expected %q, got %q", want, got)
+			}
+			if info, err := os.Stat(filepath.Join(dest,
+				"logs")); err != nil || !info.IsDir() {
+				t.Errorf("expected logs to be a folder, got %v", err)
+			}
+		})
+	}
+}
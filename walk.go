@@ -0,0 +1,185 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mark-summerfield/unz/internal/sevenzip"
+)
+
+// WalkFunc is called by Walk once per member, in archive order, with a
+// reader of the member's content (which is empty for folders, links, and
+// other members that aren't regular files). The reader is only valid until
+// the function returns: a tarball's (or 7z file's) members are read as a
+// stream, so the next call moves on past the content; a zip member's
+// content is only opened (and decompressed) if the reader is read. Errors
+// reading the content (e.g., a checksum mismatch) are returned by the
+// reader. If the function returns an error, the walk stops and Walk
+// returns that error.
+type WalkFunc func(member Member, reader io.Reader) error
+
+// Walk calls fn for each of the archive's members, e.g., to search,
+// checksum, or transform their content without unpacking them. A single
+// compressed file has one member whose Size is -1 (since it isn't known
+// without decompressing).
+func Walk(archive string, fn WalkFunc) error {
+	return WalkWith(archive, Options{}, fn)
+}
+
+// WalkWith is like Walk but only calls fn for the members that opts wants
+// (see WantedMember()), and uses opts.Format, opts.Charset,
+// opts.Backslashes, and opts.Password; its other fields are ignored.
+func WalkWith(archive string, opts Options, fn WalkFunc) error {
+	if !validCharset(opts.Charset) {
+		return fmt.Errorf("%w: %s", ErrCharset, opts.Charset)
+	}
+	form, err := archiveFormat(archive, opts.Format)
+	if err != nil {
+		return err
+	}
+	switch form.kind {
+	case tarKind, cpioKind, arKind:
+		return walkTarball(archive, form, &opts, fn)
+	case compressedKind:
+		return walkCompressed(archive, form.compression, &opts, fn)
+	case sevenZipKind:
+		return walkSevenZip(archive, &opts, fn)
+	case rarKind:
+		return fmt.Errorf("failed to open %s: RAR: %w", archive,
+			ErrUnsupported)
+	default:
+		return walkZip(archive, &opts, fn)
+	}
+}
+
+func walkTarball(archive string, form format, opts *Options,
+	fn WalkFunc) error {
+	reader, closer, err := openTarball(archive, form)
+	if err != nil {
+		return err
+	}
+	defer closer()
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", archive, err)
+		}
+		member := tarMember(header)
+		if !opts.WantedMember(member) {
+			continue
+		}
+		var content io.Reader = reader
+		if !member.Mode.IsRegular() {
+			content = strings.NewReader("")
+		}
+		if err := fn(member, content); err != nil {
+			return err
+		}
+	}
+}
+
+func walkZip(archive string, opts *Options, fn WalkFunc) error {
+	reader, err := openZip(archive, opts)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+	unpacker := newUnpacker(archive, "", opts) // for openZipMember()
+	members := zipFileMembers(reader.File)
+	for i, file := range reader.File {
+		if !opts.WantedMember(members[i]) {
+			continue
+		}
+		var content io.Reader = strings.NewReader("")
+		lazy := &lazyReader{open: func() (io.ReadCloser, error) {
+			return unpacker.openZipMember(file)
+		}}
+		if file.Mode().IsRegular() && !members[i].IsDir() {
+			content = lazy
+		}
+		err := fn(members[i], content)
+		lazy.close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var errStale = errors.New("member's reader used after its WalkFunc " +
+	"returned")
+
+// lazyReader only opens its underlying reader when first read.
+type lazyReader struct {
+	open   func() (io.ReadCloser, error)
+	reader io.ReadCloser
+	err    error
+}
+
+func (me *lazyReader) Read(p []byte) (int, error) {
+	if me.reader == nil && me.err == nil {
+		me.reader, me.err = me.open()
+	}
+	if me.err != nil {
+		return 0, me.err
+	}
+	return me.reader.Read(p)
+}
+
+func (me *lazyReader) close() {
+	if me.reader != nil {
+		me.reader.Close()
+	}
+	me.err = errStale
+}
+
+func walkSevenZip(archive string, opts *Options, fn WalkFunc) error {
+	file, reader, err := openSevenZip(archive)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var fnErr error
+	err = reader.Walk(func(file *sevenzip.File, r io.Reader) error {
+		member := sevenZipFileMembers([]*sevenzip.File{file})[0]
+		if !opts.WantedMember(member) {
+			return nil
+		}
+		if !file.Mode.IsRegular() {
+			r = strings.NewReader("")
+		}
+		fnErr = fn(member, r)
+		return fnErr
+	})
+	if err != nil && err != fnErr {
+		err = fmt.Errorf("failed to read %s: %w", archive, err)
+	}
+	return err
+}
+
+func walkCompressed(archive string, compression compression, opts *Options,
+	fn WalkFunc) error {
+	member := Member{Name: compressedName(archive), Size: -1, Mode: 0o644}
+	if info, err := os.Stat(archive); err == nil {
+		member.Mode = info.Mode().Perm()
+		member.ModTime = info.ModTime()
+	}
+	if !opts.WantedMember(member) {
+		return nil
+	}
+	reader, closer, err := openDecompressed(archive, compression)
+	if err != nil {
+		return err
+	}
+	defer closer()
+	return fn(member, reader)
+}
@@ -0,0 +1,16 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+//go:build !linux && !darwin && !freebsd && !netbsd
+
+package unz
+
+import "errors"
+
+// XattrsSupported is true if Options.Xattrs works on this platform.
+const XattrsSupported = false
+
+func setXattr(name, attr string, value []byte) error {
+	return errors.New("extended attributes aren't supported on this " +
+		"platform")
+}
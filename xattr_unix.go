@@ -0,0 +1,16 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+//go:build linux || darwin || freebsd || netbsd
+
+package unz
+
+import "golang.org/x/sys/unix"
+
+// XattrsSupported is true if Options.Xattrs works on this platform.
+const XattrsSupported = true
+
+// Sets the named extended attribute of name (not following soft links).
+func setXattr(name, attr string, value []byte) error {
+	return unix.Lsetxattr(name, attr, value, 0)
+}
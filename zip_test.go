@@ -0,0 +1,500 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"archive/zip"
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mark-summerfield/unz/internal/zipcrypt"
+)
+
+// Writes a zip file with a member for each of the given names and modes;
+// each member (other than folders) holds its name.
+func writeZip(t *testing.T, archive string, names []string,
+	modes []os.FileMode) {
+	t.Helper()
+	headers := make([]*zip.FileHeader, 0, len(names))
+	for i, name := range names {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate}
+		header.SetMode(modes[i])
+		headers = append(headers, header)
+	}
+	writeZipHeaders(t, archive, headers)
+}
+
+// Writes a zip file with a member for each of the given headers; each
+// member (other than folders) holds its name.
+func writeZipHeaders(t *testing.T, archive string,
+	headers []*zip.FileHeader) {
+	t.Helper()
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	writer := zip.NewWriter(file)
+	for _, header := range headers {
+		name := header.Name
+		member, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !header.Mode().IsDir() && !strings.HasSuffix(name, "/") {
+			if _, err := member.Write([]byte(name)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// The testdata/tree-*.zip archives hold the same files as tree.tar,
+// encrypted by bsdtar with the passphrase "secret".
+func TestUnpackEncryptedZip(t *testing.T) {
+	for _, archive := range []string{"tree-zipcrypt.zip",
+		"tree-aes256.zip"} {
+		for _, test := range []struct {
+			password string
+			want     error
+		}{
+			{"secret", nil},
+			{"wrong", zipcrypt.ErrPassword},
+			{"", ErrNoPassword},
+		} {
+			options := quiet()
+			options.Password = test.password
+			dest := t.TempDir()
+			err := Unpack(filepath.Join("testdata", archive), dest, options)
+			if !errors.Is(err, test.want) {
+				t.Errorf("%s %q: expected %v, got %v", archive,
+					test.password, test.want, err)
+				continue
+			}
+			if test.want != nil {
+				for _, name := range treePaths(t, dest) {
+					if path.Base(name) == "a.txt" {
+						t.Errorf("%s %q: garbage written to %s", archive,
+							test.password, name)
+					}
+				}
+				continue
+			}
+			for name, want := range map[string]string{
+				"a.txt":     strings.Repeat("alpha\n", 50),
+				"sub/b.txt": "beta\n", "sub/c.txt": ""} {
+				if got := readFile(t, filepath.Join(dest,
+					filepath.FromSlash(name))); got != want {
+					t.Errorf("%s: %s: expected %q, got %q", archive, name,
+						want, got)
+				}
+			}
+		}
+	}
+}
+
+const creatorUnix = 3 // the zip "version made by" host for Unix
+
+func TestUnpackZipFolders(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		host        uint16 // "version made by" host
+		members     []string
+		backslashes bool
+		want        []string
+	}{
+		{"no folder entries", creatorUnix, []string{"top/a/b/c.txt",
+			"top/d.txt"}, false, []string{"a", "a/b", "a/b/c.txt",
+			"d.txt"}},
+		{"some folder entries", creatorUnix, []string{"top/",
+			"top/a/b/c.txt", "top/e/", "top/d.txt"}, false, []string{"a",
+			"a/b", "a/b/c.txt", "d.txt", "e"}},
+		{"FAT backslashes", creatorFAT, []string{"top\\a\\c.txt",
+			"top\\e\\"}, false, []string{"a", "a/c.txt", "e"}},
+		{"NTFS backslashes", creatorNTFS, []string{"top\\a\\c.txt",
+			"top\\d.txt"}, false, []string{"a", "a/c.txt", "d.txt"}},
+		{"Unix backslashes", creatorUnix, []string{"top/a\\c.txt"},
+			false, []string{"a\\c.txt"}},
+		{"Unix backslashes option", creatorUnix, []string{"top/a\\c.txt"},
+			true, []string{"a", "a/c.txt"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			dir := t.TempDir()
+			archive := filepath.Join(dir, "folders.zip")
+			headers := make([]*zip.FileHeader, 0, len(test.members))
+			for _, name := range test.members {
+				header := &zip.FileHeader{Name: name, Method: zip.Deflate,
+					CreatorVersion: test.host << 8}
+				if test.host == creatorUnix {
+					header.SetMode(0o644)
+				}
+				headers = append(headers, header)
+			}
+			writeZipHeaders(t, archive, headers)
+			dest := filepath.Join(dir, "out")
+			options := quiet()
+			options.Backslashes = test.backslashes
+			if err := Unpack(archive, dest, options); err != nil {
+				t.Fatal(err)
+			}
+			if got := treePaths(t, dest); !equalStrs(got, test.want) {
+				t.Errorf("expected %q, got %q", test.want, got)
+			}
+		})
+	}
+}
+
+// Soft link members (as zip -y stores them) hold their targets and must be
+// unpacked as links, with the same checks as tarball links.
+func TestUnpackZipSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "links.zip")
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := zip.NewWriter(file)
+	for _, member := range []struct {
+		name    string
+		mode    os.FileMode
+		content string
+	}{
+		{"top/a.txt", 0o644, "a"},
+		{"top/sub/up", os.ModeSymlink | 0o777, "../a.txt"},
+		{"top/out", os.ModeSymlink | 0o777, "../../outside"},
+	} {
+		header := &zip.FileHeader{Name: member.name, Method: zip.Deflate}
+		header.SetMode(member.mode)
+		data, err := writer.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := data.Write([]byte(member.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	file.Close()
+	dest := filepath.Join(dir, "out")
+	if err := Unpack(archive, dest, quiet()); err != nil {
+		t.Fatal(err)
+	}
+	up := filepath.Join(dest, "sub", "up")
+	if target, err := os.Readlink(up); err != nil || target != "../a.txt" {
+		t.Errorf("expected sub/up → ../a.txt, got → %q %v", target, err)
+	}
+	if got := readFile(t, up); got != "a" {
+		t.Errorf("expected %q via sub/up, got %q", "a", got)
+	}
+	if _, err := os.Lstat(filepath.Join(dest, "out")); err == nil {
+		t.Error("expected the link that escapes to be skipped")
+	}
+}
+
+// Unpacks a zip file with a large member and checks that far less memory
+// is allocated than the member's size, i.e., that it is streamed.
+func TestUnpackZipBoundedMemory(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a large file")
+	}
+	const size = 256 << 20
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "large.zip")
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer := zip.NewWriter(file)
+	member, err := writer.Create("large.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	chunk := bytes.Repeat([]byte("0123456789abcdef"), 1<<16) // 1MiB
+	for i := 0; i < size/len(chunk); i++ {
+		if _, err := member.Write(chunk); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Close(); err != nil {
+		t.Fatal(err)
+	}
+	dest := filepath.Join(dir, "out")
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	if err := Unpack(archive, dest, quiet()); err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	if info, err := os.Stat(filepath.Join(dest,
+		"large.bin")); err != nil || info.Size() != size {
+		t.Fatalf("expected large.bin of %d bytes: %v %v", size, info, err)
+	}
+	if allocated := after.TotalAlloc - before.TotalAlloc; allocated >
+		size/16 {
+		t.Errorf("expected under %d bytes to be allocated, got %d",
+			size/16, allocated)
+	}
+}
+
+// testdata/charsets.zip's members' names aren't flagged as UTF-8: the
+// first is café.txt in CP437 made on FAT, the second ¢-ä.txt in CP437
+// made on NTFS, the third naïve.txt in Latin-1 made on Unix, and the
+// fourth has an Info-ZIP Unicode Path extra field of ünïcödé.txt.
+func TestZipCharsets(t *testing.T) {
+	archive := filepath.Join("testdata", "charsets.zip")
+	for _, test := range []struct {
+		charset string
+		want    []string
+	}{
+		{"", []string{"café.txt", "¢-ä.txt", "na\xefve.txt",
+			"ünïcödé.txt"}},
+		{"cp437", []string{"café.txt", "¢-ä.txt", "na∩ve.txt",
+			"ünïcödé.txt"}},
+		{"latin1", []string{"caf\u0082.txt", "\u009b-\u0084.txt",
+			"naïve.txt", "ünïcödé.txt"}},
+		{"utf8", []string{"caf\x82.txt", "\x9b-\x84.txt", "na\xefve.txt",
+			"ünïcödé.txt"}},
+	} {
+		options := quiet()
+		options.Charset = test.charset
+		members, err := ListWith(archive, options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if names := memberNames(members); !equalStrs(names, test.want) {
+			t.Errorf("%q: expected %q, got %q", test.charset, test.want,
+				names)
+		}
+	}
+	options := quiet()
+	options.Charset = "ebcdic"
+	if _, err := ListWith(archive, options); !errors.Is(err, ErrCharset) {
+		t.Errorf("expected %v, got %v", ErrCharset, err)
+	}
+	dest := t.TempDir()
+	if err := Unpack(archive, dest, quiet()); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"charsets", "charsets/café.txt", "charsets/na\xefve.txt",
+		"charsets/¢-ä.txt", "charsets/ünïcödé.txt"}
+	if got := treePaths(t, dest); !equalStrs(got, want) {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+// hugeSize is more than 4GiB so that it needs zip64 (and overflows 32
+// bits).
+const hugeSize = 5<<30 + 7
+
+// Writes a zip file with one member, huge.bin, whose header says it is
+// hugeSize bytes (so it has zip64 extra fields), but whose data is just
+// 100KB.
+func writeHugeZip(t *testing.T, archive string, modified time.Time) {
+	t.Helper()
+	file, err := os.Create(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var data bytes.Buffer
+	compressor, _ := flate.NewWriter(&data, flate.BestCompression)
+	_, _ = compressor.Write(bytes.Repeat([]byte("hello"), 20000))
+	_ = compressor.Close()
+	writer := zip.NewWriter(file)
+	header := &zip.FileHeader{Name: "huge.bin", Method: zip.Deflate,
+		CompressedSize64: uint64(data.Len()), UncompressedSize64: hugeSize}
+	header.SetMode(0o644)
+	header.SetModTime(modified) // CreateRaw ignores Modified
+	member, err := writer.CreateRaw(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := member.Write(data.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestZip64Sizes(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "huge.zip")
+	modified := time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)
+	writeHugeZip(t, archive, modified)
+	members, err := List(archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 1 || members[0].Size != hugeSize {
+		t.Fatalf("expected one member of %d bytes, got %v", int64(hugeSize),
+			members)
+	}
+	header := members[0].Header.(*zip.FileHeader)
+	if size := header.UncompressedSize; size != 0xFFFFFFFF {
+		t.Errorf("expected the 32-bit size to be saturated, got %d", size)
+	}
+
+	// Unpacking fails since the data is short, but the progress total is
+	// the full size.
+	dest := filepath.Join(dir, "out")
+	var total int64
+	options := quiet()
+	options.Progress = func(name string, done, size int64) { total = size }
+	if err := Unpack(archive, dest, options); err == nil {
+		t.Error("expected an error for the short data")
+	}
+	if total != hugeSize {
+		t.Errorf("expected a progress total of %d, got %d", int64(hugeSize),
+			total)
+	}
+
+	// A (sparse) file of the full size is taken to be already unpacked.
+	name := filepath.Join(dest, "huge.bin")
+	file, err := os.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := file.Truncate(hugeSize); err != nil {
+		file.Close()
+		t.Skipf("can't make a sparse file: %s", err)
+	}
+	file.Close()
+	if err := os.Chtimes(name, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+	options = quiet()
+	options.Overwrite = Resume
+	if err := Unpack(archive, dest, options); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(name); err != nil || info.Size() != hugeSize {
+		t.Errorf("expected %s to be left as it was: %v %v", name, info, err)
+	}
+}
+
+// countingReader returns size bytes (without setting them).
+type countingReader struct {
+	size int64
+}
+
+func (me *countingReader) Read(p []byte) (int, error) {
+	if me.size == 0 {
+		return 0, io.EOF
+	}
+	n := len(p)
+	if int64(n) > me.size {
+		n = int(me.size)
+	}
+	me.size -= int64(n)
+	return n, nil
+}
+
+func TestLimitsBeyond4GiB(t *testing.T) {
+	const limit = 1<<32 + 1<<20
+	for _, test := range []struct {
+		options Options
+		size    int64
+		want    error
+	}{
+		{Options{MaxSize: limit}, limit, nil},
+		{Options{MaxSize: limit}, limit + 1, ErrMaxSize},
+		{Options{MaxFileSize: limit}, limit, nil},
+		{Options{MaxFileSize: limit}, hugeSize, ErrMaxFileSize},
+	} {
+		unpacker := newUnpacker("huge.zip", t.TempDir(), &test.options)
+		reader := &limitedReader{reader: &countingReader{test.size},
+			unpacker: unpacker}
+		buffer := make([]byte, 1<<20)
+		done, err := io.CopyBuffer(io.Discard, reader, buffer)
+		if err != test.want {
+			t.Errorf("%d: expected %v, got %v", test.size, test.want, err)
+		} else if err == nil && done != test.size {
+			t.Errorf("%d: got %d", test.size, done)
+		}
+	}
+}
+
+func TestCreateUnpackSymlinks(t *testing.T) {
+	for _, suffix := range []string{".zip", ".tar.gz"} {
+		t.Run(suffix, func(t *testing.T) {
+			dir := t.TempDir()
+			src := filepath.Join(dir, "src")
+			makeTree(t, src, map[string]string{"a.txt": "hi\n",
+				"lnk": "->a.txt"})
+			archive := filepath.Join(dir, "src"+suffix)
+			if err := Create(archive, []string{src}, quiet()); err != nil {
+				t.Fatal(err)
+			}
+			dest := filepath.Join(dir, "out")
+			if err := Unpack(archive, dest, quiet()); err != nil {
+				t.Fatal(err)
+			}
+			lnk := filepath.Join(dest, "lnk")
+			info, err := os.Lstat(lnk)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode()&os.ModeSymlink == 0 {
+				t.Fatalf("%s: expected a soft link, got %s", lnk,
+					info.Mode())
+			}
+			if target, _ := os.Readlink(lnk); target != "a.txt" {
+				t.Errorf("expected target a.txt, got %q", target)
+			}
+			if content := readFile(t, lnk); content != "hi\n" {
+				t.Errorf("expected %q via the link, got %q", "hi\n",
+					content)
+			}
+		})
+	}
+}
+
+func TestZipFileModes(t *testing.T) {
+	dir := t.TempDir()
+	archive := filepath.Join(dir, "modes.zip")
+	writeZip(t, archive, []string{"m/private", "m/script", "m/pipe",
+		"m/device"}, []os.FileMode{0o600, 0o755, os.ModeNamedPipe | 0o777,
+		os.ModeDevice | 0o666})
+	dest := filepath.Join(dir, "out")
+	if err := Unpack(archive, dest, quiet()); err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name string
+		mode os.FileMode
+	}{
+		{"private", 0o600},
+		{"script", 0o755},
+		{"pipe", 0o644},
+		{"device", 0o644},
+	} {
+		info, err := os.Lstat(filepath.Join(dest, test.name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode() != test.mode {
+			t.Errorf("%s: expected mode %s, got %s", test.name, test.mode,
+				info.Mode())
+		}
+	}
+}
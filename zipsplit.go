@@ -0,0 +1,396 @@
+// Copyright © 2023 Mark Summerfield. All rights reserved.
+// License: Apache-2.0
+
+package unz
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Split (multi-volume) zip files, e.g., made by zip -s, are a set of
+// volumes x.z01, x.z02, ..., x.zip (the last) whose central directory
+// gives each member's offset within the volume (disk) it starts on.
+// archive/zip ignores disk numbers, so the volumes are presented to it as
+// one stream (see volumeReader) whose central directory is rewritten to
+// use offsets within the whole stream (see absoluteDirectory()).
+
+const (
+	dirEndSig       = 0x06054b50
+	dirEnd64Sig     = 0x06064b50
+	dirEnd64LocSig  = 0x07064b50
+	dirHeaderSig    = 0x02014b50
+	dirEndLen       = 22
+	dirEnd64Len     = 56
+	dirEnd64LocLen  = 20
+	dirHeaderLen    = 46
+	zip64ExtraID    = 0x0001
+	uint16Max       = 0xFFFF
+	uint32Max       = 0xFFFFFFFF
+	maxCommentLen   = 0xFFFF
+	maxSplitVolumes = 0xFFFF
+)
+
+// Returns the volumes preceding the archive if it is the last volume of a
+// split zip file (e.g., for x.zip, x.z01 and x.z02), or nil if it isn't
+// split (there's no x.z01). The last volume may also be given as x.z01 (or
+// any x.zNN), since that's often the name shown for a split set.
+func splitVolumes(archive string) (string, []string) {
+	if stem, ok := volumeStem(archive); ok {
+		z := archive[len(stem)+1]
+		archive = stem + ".zip"
+		if z == 'Z' {
+			archive = stem + ".ZIP"
+		}
+	}
+	if !hasSuffixFold(archive, ".ZIP") {
+		return archive, nil
+	}
+	volumes := []string{}
+	for i := 1; i < maxSplitVolumes; i++ {
+		volume := volumeName(archive, i)
+		if _, err := os.Stat(volume); err != nil {
+			break
+		}
+		volumes = append(volumes, volume)
+	}
+	if len(volumes) == 0 {
+		return archive, nil
+	}
+	return archive, volumes
+}
+
+// Returns the name of the split zip file's ith volume, e.g., for x.zip and
+// 2, x.z02 (or for x.ZIP, x.Z02).
+func volumeName(archive string, i int) string {
+	stem := archive[:len(archive)-len(".zip")]
+	return fmt.Sprintf("%s.%c%02d", stem, archive[len(stem)+1], i)
+}
+
+// Returns true if the archive (opened as file) is the last volume of a
+// split zip file (going by its end of central directory record).
+func isLastVolume(file *os.File, size int64) bool {
+	reader := &volumeReader{}
+	reader.add(file, size)
+	end, err := readDirEnd(reader, []int64{0})
+	return err == nil && end.disk > 0
+}
+
+// Returns the archive's name without its .zNN suffix and true, or false if
+// it doesn't have one.
+func volumeStem(archive string) (string, bool) {
+	i := strings.LastIndexByte(archive, '.')
+	if i == -1 || len(archive)-i < 4 || (archive[i+1] != 'z' &&
+		archive[i+1] != 'Z') {
+		return "", false
+	}
+	for _, c := range archive[i+2:] {
+		if c < '0' || c > '9' {
+			return "", false
+		}
+	}
+	return archive[:i], true
+}
+
+// volumeReader is an io.ReaderAt over a sequence of parts (the volumes
+// followed by the rewritten central directory and its end records).
+type volumeReader struct {
+	parts  []io.ReaderAt
+	starts []int64 // each part's offset in the whole
+	size   int64
+}
+
+func (me *volumeReader) add(part io.ReaderAt, size int64) {
+	me.parts = append(me.parts, part)
+	me.starts = append(me.starts, me.size)
+	me.size += size
+}
+
+func (me *volumeReader) ReadAt(p []byte, offset int64) (int, error) {
+	if offset < 0 {
+		return 0, errors.New("negative offset")
+	}
+	i := sort.Search(len(me.starts), func(i int) bool {
+		return me.starts[i] > offset
+	}) - 1 // the part that holds offset
+	total := 0
+	for ; i >= 0 && i < len(me.parts) && total < len(p); i++ {
+		end := me.size
+		if i+1 < len(me.parts) {
+			end = me.starts[i+1]
+		}
+		at := offset + int64(total)
+		if at >= end {
+			continue
+		}
+		chunk := p[total:]
+		if int64(len(chunk)) > end-at {
+			chunk = chunk[:end-at]
+		}
+		n, err := me.parts[i].ReadAt(chunk, at-me.starts[i])
+		total += n
+		if n < len(chunk) {
+			if err == nil || err == io.EOF {
+				err = io.ErrUnexpectedEOF // a volume shrank
+			}
+			return total, err
+		}
+	}
+	if total < len(p) {
+		return total, io.EOF
+	}
+	return total, nil
+}
+
+// Opens the split zip file's volumes (the last of which, archive, is
+// already open as last) and returns a reader of the whole with a
+// rewritten central directory, and the opened volumes (which the caller
+// must close, even if there's an error).
+func openSplitZip(archive string, last *os.File, lastSize int64,
+	volumes []string) (*volumeReader, []*os.File, error) {
+	files := make([]*os.File, 0, len(volumes))
+	reader := &volumeReader{}
+	starts := make([]int64, 0, len(volumes)+1)
+	for _, volume := range volumes {
+		file, err := os.Open(volume)
+		if err != nil {
+			return nil, files, err
+		}
+		files = append(files, file)
+		info, err := file.Stat()
+		if err != nil {
+			return nil, files, err
+		}
+		starts = append(starts, reader.size)
+		reader.add(file, info.Size())
+	}
+	starts = append(starts, reader.size)
+	reader.add(last, lastSize)
+	end, err := readDirEnd(reader, starts)
+	if err != nil {
+		return nil, files, err
+	}
+	if end.disk != len(volumes) {
+		if end.disk > len(volumes) {
+			return nil, files, fmt.Errorf("volume %s is missing",
+				volumeName(archive, len(volumes)+1))
+		}
+		return nil, files, fmt.Errorf("expected %d volumes, found %d",
+			end.disk+1, len(volumes)+1)
+	}
+	if end.dirDisk >= len(starts) {
+		return nil, files, errors.New("invalid central directory disk")
+	}
+	dirStart := starts[end.dirDisk] + end.dirOffset
+	if dirStart < 0 || end.dirSize < 0 || dirStart+end.dirSize >
+		reader.size {
+		return nil, files, errors.New("invalid central directory")
+	}
+	dir := make([]byte, end.dirSize)
+	if _, err := reader.ReadAt(dir, dirStart); err != nil {
+		return nil, files, err
+	}
+	dir, err = absoluteDirectory(dir, end.entries, starts)
+	if err != nil {
+		return nil, files, err
+	}
+	whole := &volumeReader{}
+	whole.add(io.NewSectionReader(reader, 0, dirStart), dirStart)
+	dir = appendDirEnd(dir, end.entries, dirStart, end.comment)
+	whole.add(bytes.NewReader(dir), int64(len(dir)))
+	return whole, files, nil
+}
+
+// dirEnd holds what's needed from a split zip's end of central directory
+// records.
+type dirEnd struct {
+	disk      int // this (i.e., the last) disk's number, from 0
+	dirDisk   int // the disk the central directory starts on
+	entries   int64
+	dirSize   int64
+	dirOffset int64 // relative to the start of dirDisk
+	comment   []byte
+}
+
+// Reads the end of central directory record (and the zip64 one, if any)
+// from the end of reader (the volumes concatenated, starting at starts).
+func readDirEnd(reader *volumeReader, starts []int64) (dirEnd, error) {
+	tail := int64(dirEndLen + maxCommentLen)
+	if tail > reader.size {
+		tail = reader.size
+	}
+	buf := make([]byte, tail)
+	if _, err := reader.ReadAt(buf, reader.size-tail); err != nil {
+		return dirEnd{}, err
+	}
+	i := len(buf) - dirEndLen
+	for ; i >= 0; i-- {
+		if binary.LittleEndian.Uint32(buf[i:]) == dirEndSig {
+			break
+		}
+	}
+	if i < 0 {
+		return dirEnd{}, errors.New("no end of central directory record")
+	}
+	record := buf[i:]
+	le := binary.LittleEndian
+	end := dirEnd{disk: int(le.Uint16(record[4:])),
+		dirDisk:   int(le.Uint16(record[6:])),
+		entries:   int64(le.Uint16(record[10:])),
+		dirSize:   int64(le.Uint32(record[12:])),
+		dirOffset: int64(le.Uint32(record[16:]))}
+	if n := int(le.Uint16(record[20:])); dirEndLen+n <= len(record) {
+		end.comment = record[dirEndLen : dirEndLen+n]
+	}
+	if end.disk != uint16Max && end.dirDisk != uint16Max &&
+		end.entries != uint16Max && end.dirSize != uint32Max &&
+		end.dirOffset != uint32Max {
+		return end, nil
+	}
+	locator := reader.size - tail + int64(i) - dirEnd64LocLen // zip64
+	loc := make([]byte, dirEnd64LocLen)
+	if _, err := reader.ReadAt(loc, locator); err != nil ||
+		le.Uint32(loc) != dirEnd64LocSig {
+		return dirEnd{}, errors.New("no zip64 end of central directory " +
+			"locator")
+	}
+	disk := int(le.Uint32(loc[4:]))
+	if disk >= len(starts) {
+		return dirEnd{}, errors.New("invalid zip64 end of central " +
+			"directory disk")
+	}
+	record = make([]byte, dirEnd64Len)
+	if _, err := reader.ReadAt(record, starts[disk]+
+		int64(le.Uint64(loc[8:]))); err != nil ||
+		le.Uint32(record) != dirEnd64Sig {
+		return dirEnd{}, errors.New("no zip64 end of central directory " +
+			"record")
+	}
+	end.disk = int(le.Uint32(record[16:]))
+	end.dirDisk = int(le.Uint32(record[20:]))
+	end.entries = int64(le.Uint64(record[32:]))
+	end.dirSize = int64(le.Uint64(record[40:]))
+	end.dirOffset = int64(le.Uint64(record[48:]))
+	return end, nil
+}
+
+// Returns the central directory with every member's disk number set to 0
+// and its local header offset made relative to the start of the first
+// volume (so it's correct for the volumes concatenated).
+func absoluteDirectory(dir []byte, entries int64, starts []int64) ([]byte,
+	error) {
+	le := binary.LittleEndian
+	result := make([]byte, 0, len(dir)+int(entries)*12)
+	for i := int64(0); i < entries; i++ {
+		if len(dir) < dirHeaderLen || le.Uint32(dir) != dirHeaderSig {
+			return nil, errors.New("invalid central directory header")
+		}
+		nameLen := int(le.Uint16(dir[28:]))
+		extraLen := int(le.Uint16(dir[30:]))
+		commentLen := int(le.Uint16(dir[32:]))
+		size := dirHeaderLen + nameLen + extraLen + commentLen
+		if len(dir) < size {
+			return nil, errors.New("invalid central directory header")
+		}
+		header := append([]byte{}, dir[:dirHeaderLen+nameLen]...)
+		extra := dir[dirHeaderLen+nameLen : dirHeaderLen+nameLen+extraLen]
+		comment := dir[dirHeaderLen+nameLen+extraLen : size]
+		dir = dir[size:]
+		usize := uint64(le.Uint32(header[24:]))
+		csize := uint64(le.Uint32(header[20:]))
+		offset := uint64(le.Uint32(header[42:]))
+		disk := uint64(le.Uint16(header[34:]))
+		others := []byte{} // extra fields other than zip64's
+		for len(extra) >= 4 {
+			id, n := le.Uint16(extra), int(le.Uint16(extra[2:]))
+			if 4+n > len(extra) {
+				break
+			}
+			field := extra[4 : 4+n]
+			if id != zip64ExtraID {
+				others = append(others, extra[:4+n]...)
+			} else {
+				for _, value := range []*uint64{&usize, &csize, &offset} {
+					if *value == uint32Max && len(field) >= 8 {
+						*value, field = le.Uint64(field), field[8:]
+					}
+				}
+				if disk == uint16Max && len(field) >= 4 {
+					disk = uint64(le.Uint32(field))
+				}
+			}
+			extra = extra[4+n:]
+		}
+		if disk >= uint64(len(starts)) {
+			return nil, errors.New("invalid member disk number")
+		}
+		offset += uint64(starts[disk])
+		zip64 := []byte{}
+		if le.Uint32(header[24:]) == uint32Max {
+			zip64 = le.AppendUint64(zip64, usize)
+		}
+		if le.Uint32(header[20:]) == uint32Max {
+			zip64 = le.AppendUint64(zip64, csize)
+		}
+		if offset >= uint32Max {
+			zip64 = le.AppendUint64(zip64, offset)
+			offset = uint32Max
+		}
+		le.PutUint32(header[42:], uint32(offset))
+		le.PutUint16(header[34:], 0)
+		if len(zip64) > 0 {
+			field := le.AppendUint16(nil, zip64ExtraID)
+			field = le.AppendUint16(field, uint16(len(zip64)))
+			others = append(append(field, zip64...), others...)
+		}
+		if len(others) > uint16Max {
+			return nil, errors.New("invalid extra field")
+		}
+		le.PutUint16(header[30:], uint16(len(others)))
+		result = append(append(append(result, header...), others...),
+			comment...)
+	}
+	return result, nil
+}
+
+// Returns the (rewritten) central directory followed by end of central
+// directory records for a single volume with the directory at dirStart.
+func appendDirEnd(dir []byte, entries, dirStart int64,
+	comment []byte) []byte {
+	le := binary.LittleEndian
+	dirSize := int64(len(dir))
+	if entries >= uint16Max || dirSize >= uint32Max ||
+		dirStart >= uint32Max {
+		end64 := dirStart + dirSize
+		dir = le.AppendUint32(dir, dirEnd64Sig)
+		dir = le.AppendUint64(dir, dirEnd64Len-12)
+		dir = le.AppendUint16(dir, 45) // version made by
+		dir = le.AppendUint16(dir, 45) // version needed
+		dir = le.AppendUint32(dir, 0)  // this disk
+		dir = le.AppendUint32(dir, 0)  // directory's disk
+		dir = le.AppendUint64(dir, uint64(entries))
+		dir = le.AppendUint64(dir, uint64(entries))
+		dir = le.AppendUint64(dir, uint64(dirSize))
+		dir = le.AppendUint64(dir, uint64(dirStart))
+		dir = le.AppendUint32(dir, dirEnd64LocSig)
+		dir = le.AppendUint32(dir, 0)
+		dir = le.AppendUint64(dir, uint64(end64))
+		dir = le.AppendUint32(dir, 1) // total disks
+		entries, dirSize, dirStart = uint16Max, uint32Max, uint32Max
+	}
+	dir = le.AppendUint32(dir, dirEndSig)
+	dir = le.AppendUint16(dir, 0) // this disk
+	dir = le.AppendUint16(dir, 0) // directory's disk
+	dir = le.AppendUint16(dir, uint16(entries))
+	dir = le.AppendUint16(dir, uint16(entries))
+	dir = le.AppendUint32(dir, uint32(dirSize))
+	dir = le.AppendUint32(dir, uint32(dirStart))
+	dir = le.AppendUint16(dir, uint16(len(comment)))
+	return append(dir, comment...)
+}